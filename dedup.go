@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// 内容寻址存储的对象前缀和引用计数索引
+const (
+	blobPrefix     = ".bridge/blobs/sha256/"
+	blobTempPrefix = ".bridge/blobs/tmp/"
+	blobIndexKey   = ".bridge/blobs/index.json"
+)
+
+// 引用对象上记录 blob 信息的元数据键
+const (
+	blobSha256MetadataKey = "Blob-Sha256"
+	blobSizeMetadataKey   = "Blob-Size"
+)
+
+// blob 引用计数
+type blobEntry struct {
+	Size int64 `json:"size"`
+	Refs int64 `json:"refs"`
+}
+
+var dedupEnabled bool
+
+// 引用计数索引中没有该 blob
+var errBlobNotFound = errors.New("blob not found")
+
+// 初始化去重：加载引用计数索引并清理残留的暂存对象，可通过 MINIO_DEDUP=false 关闭
+func initDedup() {
+	reservedMetadataKeys[blobSha256MetadataKey] = true
+	reservedMetadataKeys[blobSizeMetadataKey] = true
+
+	if strings.EqualFold(os.Getenv("MINIO_DEDUP"), "false") {
+		log.Println("未开启上传去重")
+		return
+	}
+
+	// 引用计数索引只保存在存储中，每次修改都条件写入，这里只确认可以读取
+	ctx := context.Background()
+	if _, _, err := loadJSONObject[map[string]*blobEntry](ctx, bucketName, blobIndexKey); err != nil {
+		log.Printf("加载 blob 索引失败，未开启上传去重: %v", err)
+		return
+	}
+	dedupEnabled = true
+
+	// 清理一小时前残留的暂存对象
+	opts := minio.ListObjectsOptions{Prefix: blobTempPrefix, Recursive: true}
+	for object := range minioClient.ListObjects(ctx, bucketName, opts) {
+		if object.Err != nil {
+			log.Println("Error listing staged blobs:", object.Err)
+			break
+		}
+		if time.Since(object.LastModified) > time.Hour {
+			minioClient.RemoveObject(ctx, bucketName, object.Key, minio.RemoveObjectOptions{})
+		}
+	}
+}
+
+// 租户是否使用去重存储；开启加密的租户直接存储，避免明文 blob 在租户间共享
+func dedupForKey(key string) bool {
+	return dedupEnabled && encryptionForTenant(tenantFromKey(key)).Mode == sseModeNone
+}
+
+func blobKey(sum string) string {
+	return blobPrefix + sum[:2] + "/" + sum
+}
+
+// 将元数据键统一为去掉 X-Amz-Meta- 前缀的规范形式，兼容 Stat 和列表接口
+func normalizedMetadata(metadata map[string]string) map[string]string {
+	result := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		if len(k) > len("x-amz-meta-") && strings.EqualFold(k[:len("x-amz-meta-")], "x-amz-meta-") {
+			k = k[len("x-amz-meta-"):]
+		}
+		result[textproto.CanonicalMIMEHeaderKey(k)] = v
+	}
+	return result
+}
+
+// 解析引用对象指向的 blob
+func blobRef(metadata map[string]string) (string, int64, bool) {
+	m := normalizedMetadata(metadata)
+	sum := m[blobSha256MetadataKey]
+	if len(sum) != sha256.Size*2 {
+		return "", 0, false
+	}
+	size, err := strconv.ParseInt(m[blobSizeMetadataKey], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return sum, size, true
+}
+
+// 对象的逻辑大小：引用对象取 blob 大小
+func logicalSize(object minio.ObjectInfo) int64 {
+	if _, size, ok := blobRef(object.UserMetadata); ok {
+		return size
+	}
+	return object.Size
+}
+
+// 对象的 SHA-256 校验和，只有去重存储的对象才有
+func objectSha256(object minio.ObjectInfo) string {
+	sum, _, _ := blobRef(object.UserMetadata)
+	return sum
+}
+
+// 以条件写入修改引用计数索引，多个副本同时修改时重新读取并重试；
+// update 返回 errCatalogUnchanged 时跳过写入
+func updateBlobIndex(ctx context.Context, update func(map[string]*blobEntry) error) error {
+	return updateJSONObject(ctx, bucketName, blobIndexKey, func(index map[string]*blobEntry) (map[string]*blobEntry, error) {
+		if index == nil {
+			index = make(map[string]*blobEntry)
+		}
+		if err := update(index); err != nil {
+			return nil, err
+		}
+		return index, nil
+	})
+}
+
+// 上传内容到内容寻址存储：先流式写入暂存对象并计算 SHA-256，
+// 再按哈希落到 blob（已存在则丢弃暂存对象），最后增加引用计数。
+// 只有同一内容的 blob 创建和删除在进程内串行，不同内容的上传互不影响
+func storeBlob(ctx context.Context, r io.Reader, size int64, contentType string) (string, int64, error) {
+	var token [16]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return "", 0, err
+	}
+	tempKey := blobTempPrefix + hex.EncodeToString(token[:])
+
+	hasher := sha256.New()
+	info, err := minioClient.PutObject(ctx, bucketName, tempKey, io.TeeReader(r, hasher), size, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	// 按版本删除暂存对象，开启版本控制时不留下历史版本
+	defer minioClient.RemoveObject(ctx, bucketName, tempKey, minio.RemoveObjectOptions{VersionID: info.VersionID})
+	sum := hex.EncodeToString(hasher.Sum(nil))
+
+	unlock := lockObject(bucketName, blobKey(sum))
+	defer unlock()
+
+	blobSize, err := incrementBlobRef(ctx, sum, false, 0)
+	if errors.Is(err, errBlobNotFound) {
+		_, err = minioClient.CopyObject(ctx, minio.CopyDestOptions{
+			Bucket: bucketName,
+			Object: blobKey(sum),
+		}, minio.CopySrcOptions{
+			Bucket: bucketName,
+			Object: tempKey,
+		})
+		if err != nil {
+			return "", 0, err
+		}
+		blobSize, err = incrementBlobRef(ctx, sum, true, info.Size)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	return sum, blobSize, nil
+}
+
+// 增加 blob 的引用计数并返回 blob 大小；索引中没有该 blob 时，create 为 true 则以 size 新建，否则返回 errBlobNotFound
+func incrementBlobRef(ctx context.Context, sum string, create bool, size int64) (int64, error) {
+	var blobSize int64
+	err := updateBlobIndex(ctx, func(index map[string]*blobEntry) error {
+		entry, ok := index[sum]
+		if !ok {
+			if !create {
+				return fmt.Errorf("%w: %s", errBlobNotFound, sum)
+			}
+			entry = &blobEntry{Size: size}
+			index[sum] = entry
+		}
+		entry.Refs++
+		blobSize = entry.Size
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return blobSize, nil
+}
+
+// 为已存在的 blob 增加一个引用（复制引用对象时使用）
+func addBlobRef(ctx context.Context, sum string) error {
+	_, err := incrementBlobRef(ctx, sum, false, 0)
+	return err
+}
+
+// 释放一个 blob 引用，最后一个引用消失时删除 blob。
+// 先从索引中移除再删除 blob：删除失败只会留下未被引用的 blob，不会出现索引指向已删除的 blob
+func releaseBlobRef(ctx context.Context, sum string) error {
+	unlock := lockObject(bucketName, blobKey(sum))
+	defer unlock()
+
+	var removed bool
+	err := updateBlobIndex(ctx, func(index map[string]*blobEntry) error {
+		removed = false
+		entry, ok := index[sum]
+		if !ok {
+			return errCatalogUnchanged
+		}
+		entry.Refs--
+		if entry.Refs <= 0 {
+			delete(index, sum)
+			removed = true
+		}
+		return nil
+	})
+	if err != nil || !removed {
+		return err
+	}
+	if err := removeBlob(ctx, sum); err != nil {
+		return fmt.Errorf("删除 blob %s 失败: %w", sum, err)
+	}
+	log.Printf("Deleted blob: %s\n", sum)
+	return nil
+}
+
+// 永久删除 blob：开启版本控制时删除具体版本，否则只会写入删除标记而不释放空间
+func removeBlob(ctx context.Context, sum string) error {
+	opts := minio.RemoveObjectOptions{}
+	if bucketVersioning {
+		stat, err := minioClient.StatObject(ctx, bucketName, blobKey(sum), minio.StatObjectOptions{})
+		if err != nil {
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				return nil
+			}
+			return err
+		}
+		opts.VersionID = stat.VersionID
+	}
+	return minioClient.RemoveObject(ctx, bucketName, blobKey(sum), opts)
+}
+
+// 以去重方式上传租户文件：内容写入 blob，租户 key 只保存一个指向 blob 的空引用对象
+func putDedupObject(ctx context.Context, key string, r io.Reader, size int64, contentType string, metadata map[string]string) (int64, error) {
+	sum, blobSize, err := storeBlob(ctx, r, size, contentType)
+	if err != nil {
+		return 0, err
+	}
+
+	refMetadata := map[string]string{
+		blobSha256MetadataKey: sum,
+		blobSizeMetadataKey:   strconv.FormatInt(blobSize, 10),
+	}
+	for k, v := range metadata {
+		refMetadata[k] = v
+	}
+	_, err = minioClient.PutObject(ctx, bucketName, key, bytes.NewReader(nil), 0, minio.PutObjectOptions{
+		ContentType:  contentType,
+		UserMetadata: refMetadata,
+	})
+	if err != nil {
+		if releaseErr := releaseBlobRef(ctx, sum); releaseErr != nil {
+			log.Printf("释放 blob 引用失败: %v", releaseErr)
+		}
+		return 0, err
+	}
+	return blobSize, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newTestDedup(t *testing.T) *fakeS3 {
+	t.Helper()
+	fake := newFakeMinio(t)
+	prevBucket := bucketName
+	bucketName = "nxt-tenant"
+	t.Cleanup(func() { bucketName = prevBucket })
+	return fake
+}
+
+func readTestBlobIndex(t *testing.T, fake *fakeS3) map[string]*blobEntry {
+	t.Helper()
+	index := make(map[string]*blobEntry)
+	if data, ok := fake.get(bucketName, blobIndexKey); ok {
+		if err := json.Unmarshal(data, &index); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return index
+}
+
+// 并发上传相同和不同的内容，引用计数不能丢失，相同内容只保存一个 blob
+func TestStoreBlobConcurrent(t *testing.T) {
+	fake := newTestDedup(t)
+	ctx := context.Background()
+	const contents, copies = 4, 8
+
+	var wg sync.WaitGroup
+	sums := make([][]string, contents)
+	errs := make(chan error, contents*copies)
+	for i := 0; i < contents; i++ {
+		sums[i] = make([]string, copies)
+		for j := 0; j < copies; j++ {
+			wg.Add(1)
+			go func(i, j int) {
+				defer wg.Done()
+				data := fmt.Sprintf("content %d", i)
+				sum, size, err := storeBlob(ctx, strings.NewReader(data), int64(len(data)), "text/plain")
+				if err == nil && size != int64(len(data)) {
+					err = fmt.Errorf("blob size %d, want %d", size, len(data))
+				}
+				sums[i][j] = sum
+				errs <- err
+			}(i, j)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	index := readTestBlobIndex(t, fake)
+	if len(index) != contents {
+		t.Fatalf("index has %d blobs, want %d", len(index), contents)
+	}
+	for i := range sums {
+		entry := index[sums[i][0]]
+		if entry == nil || entry.Refs != copies {
+			t.Errorf("content %d: index entry %+v, want %d refs", i, entry, copies)
+		}
+		if data, ok := fake.get(bucketName, blobKey(sums[i][0])); !ok || string(data) != fmt.Sprintf("content %d", i) {
+			t.Errorf("content %d: blob not stored", i)
+		}
+	}
+}
+
+// 其他副本在读取和写入之间修改了索引：条件写入失败后重新读取，两边的修改都保留
+func TestBlobIndexConflictingReplica(t *testing.T) {
+	fake := newTestDedup(t)
+	ctx := context.Background()
+
+	sum, _, err := storeBlob(ctx, strings.NewReader("shared"), 6, "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var once sync.Once
+	fake.afterRead = func(method, bucket, key string, found bool) {
+		if key != blobIndexKey {
+			return
+		}
+		once.Do(func() {
+			index := readTestBlobIndex(t, fake)
+			index["other"] = &blobEntry{Size: 1, Refs: 1}
+			data, _ := json.Marshal(index)
+			fake.put(bucket, key, data)
+		})
+	}
+	if err := addBlobRef(ctx, sum); err != nil {
+		t.Fatal(err)
+	}
+
+	index := readTestBlobIndex(t, fake)
+	if index["other"] == nil || index[sum] == nil || index[sum].Refs != 2 {
+		t.Fatalf("index after conflicting update: %+v", index)
+	}
+
+	// 释放全部引用后 blob 从索引和存储中删除
+	for i := 0; i < 2; i++ {
+		if err := releaseBlobRef(ctx, sum); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if index := readTestBlobIndex(t, fake); index[sum] != nil {
+		t.Errorf("released blob still in index: %+v", index[sum])
+	}
+	if _, ok := fake.get(bucketName, blobKey(sum)); ok {
+		t.Error("released blob not removed")
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 二进制差分格式：
+//
+//	magic "NXTDLT01" | uvarint 源长度 | uvarint 目标长度 | 操作序列 | 目标 SHA-256（32 字节）
+//
+// 操作：0 结束；1 复制（uvarint 源偏移、uvarint 长度）；2 插入（uvarint 长度、数据）
+const deltaMagic = "NXTDLT01"
+
+// 源文件按固定大小分块建立索引，目标文件上用滚动哈希查找匹配块
+const deltaBlockSize = 64
+
+// 同一哈希值最多记录的源块数量，避免重复内容（例如全零填充）导致退化
+const deltaMaxCandidates = 8
+
+const (
+	deltaOpEnd    byte = 0
+	deltaOpCopy   byte = 1
+	deltaOpInsert byte = 2
+)
+
+// 差分与源文件不匹配或数据损坏
+var errInvalidDelta = errors.New("invalid delta")
+
+// 多项式滚动哈希的基数，运算按 2^32 取模
+const deltaHashBase = 16777619
+
+var deltaHashPow = func() uint32 {
+	pow := uint32(1)
+	for i := 0; i < deltaBlockSize-1; i++ {
+		pow *= deltaHashBase
+	}
+	return pow
+}()
+
+func deltaBlockHash(block []byte) uint32 {
+	var h uint32
+	for _, c := range block {
+		h = h*deltaHashBase + uint32(c)
+	}
+	return h
+}
+
+// 滑出 out、滑入 in 后的哈希值
+func deltaRollHash(h uint32, out, in byte) uint32 {
+	return (h-uint32(out)*deltaHashPow)*deltaHashBase + uint32(in)
+}
+
+type deltaWriter struct {
+	buf bytes.Buffer
+	tmp [binary.MaxVarintLen64]byte
+}
+
+func (w *deltaWriter) uvarint(v uint64) {
+	n := binary.PutUvarint(w.tmp[:], v)
+	w.buf.Write(w.tmp[:n])
+}
+
+func (w *deltaWriter) insert(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	w.buf.WriteByte(deltaOpInsert)
+	w.uvarint(uint64(len(data)))
+	w.buf.Write(data)
+}
+
+func (w *deltaWriter) copy(offset, length int) {
+	w.buf.WriteByte(deltaOpCopy)
+	w.uvarint(uint64(offset))
+	w.uvarint(uint64(length))
+}
+
+// computeDelta 生成从 source 到 target 的差分
+func computeDelta(source, target []byte) []byte {
+	w := &deltaWriter{}
+	w.buf.WriteString(deltaMagic)
+	w.uvarint(uint64(len(source)))
+	w.uvarint(uint64(len(target)))
+
+	index := make(map[uint32][]int)
+	for off := 0; off+deltaBlockSize <= len(source); off += deltaBlockSize {
+		h := deltaBlockHash(source[off : off+deltaBlockSize])
+		if len(index[h]) < deltaMaxCandidates {
+			index[h] = append(index[h], off)
+		}
+	}
+
+	insertStart := 0
+	i := 0
+	var h uint32
+	hashValid := false
+	for i+deltaBlockSize <= len(target) {
+		if !hashValid {
+			h = deltaBlockHash(target[i : i+deltaBlockSize])
+			hashValid = true
+		}
+
+		matchOffset, matchLength := -1, 0
+		for _, off := range index[h] {
+			if !bytes.Equal(source[off:off+deltaBlockSize], target[i:i+deltaBlockSize]) {
+				continue
+			}
+			// 向后尽量延长匹配
+			length := deltaBlockSize
+			for off+length < len(source) && i+length < len(target) && source[off+length] == target[i+length] {
+				length++
+			}
+			if length > matchLength {
+				matchOffset, matchLength = off, length
+			}
+		}
+
+		if matchOffset >= 0 {
+			// 向前延长匹配，吃掉待插入数据的尾部
+			back := 0
+			for back < i-insertStart && matchOffset-back > 0 && source[matchOffset-back-1] == target[i-back-1] {
+				back++
+			}
+			w.insert(target[insertStart : i-back])
+			w.copy(matchOffset-back, matchLength+back)
+			i += matchLength
+			insertStart = i
+			hashValid = false
+			continue
+		}
+
+		if i+deltaBlockSize < len(target) {
+			h = deltaRollHash(h, target[i], target[i+deltaBlockSize])
+		}
+		i++
+	}
+	w.insert(target[insertStart:])
+	w.buf.WriteByte(deltaOpEnd)
+
+	sum := sha256.Sum256(target)
+	w.buf.Write(sum[:])
+	return w.buf.Bytes()
+}
+
+// applyDelta 将差分应用到 source，并校验结果的长度和 SHA-256；
+// 差分声明的目标大小超过 maxTargetSize 时直接拒绝，避免按损坏的大小预先分配内存
+func applyDelta(source []byte, delta io.Reader, maxTargetSize int64) ([]byte, error) {
+	r := bufio.NewReader(delta)
+
+	magic := make([]byte, len(deltaMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != deltaMagic {
+		return nil, fmt.Errorf("%w: bad magic", errInvalidDelta)
+	}
+	sourceSize, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidDelta, err)
+	}
+	if sourceSize != uint64(len(source)) {
+		return nil, fmt.Errorf("%w: source size %d, expected %d", errInvalidDelta, len(source), sourceSize)
+	}
+	targetSize, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidDelta, err)
+	}
+	if maxTargetSize < 0 || targetSize > uint64(maxTargetSize) {
+		return nil, fmt.Errorf("%w: target size %d exceeds limit %d", errInvalidDelta, targetSize, maxTargetSize)
+	}
+
+	target := make([]byte, 0, targetSize)
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidDelta, err)
+		}
+		if op == deltaOpEnd {
+			break
+		}
+
+		switch op {
+		case deltaOpCopy:
+			offset, err1 := binary.ReadUvarint(r)
+			length, err2 := binary.ReadUvarint(r)
+			if err1 != nil || err2 != nil || offset > sourceSize || length > sourceSize-offset {
+				return nil, fmt.Errorf("%w: bad copy", errInvalidDelta)
+			}
+			target = append(target, source[offset:offset+length]...)
+		case deltaOpInsert:
+			length, err := binary.ReadUvarint(r)
+			if err != nil || length > targetSize-uint64(len(target)) {
+				return nil, fmt.Errorf("%w: bad insert", errInvalidDelta)
+			}
+			start := len(target)
+			target = append(target, make([]byte, length)...)
+			if _, err := io.ReadFull(r, target[start:]); err != nil {
+				return nil, fmt.Errorf("%w: %v", errInvalidDelta, err)
+			}
+		default:
+			return nil, fmt.Errorf("%w: unknown op %d", errInvalidDelta, op)
+		}
+		if uint64(len(target)) > targetSize {
+			return nil, fmt.Errorf("%w: target too large", errInvalidDelta)
+		}
+	}
+
+	var expected [sha256.Size]byte
+	if _, err := io.ReadFull(r, expected[:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidDelta, err)
+	}
+	if uint64(len(target)) != targetSize || sha256.Sum256(target) != expected {
+		return nil, fmt.Errorf("%w: target checksum mismatch", errInvalidDelta)
+	}
+	return target, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func randomTestBytes(rng *rand.Rand, n int) []byte {
+	data := make([]byte, n)
+	rng.Read(data)
+	return data
+}
+
+// 从 source 生成差分再应用，结果应与 target 完全一致
+func TestDeltaRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	base := randomTestBytes(rng, 64*1024)
+
+	modified := append([]byte(nil), base...)
+	for i := 0; i < 20; i++ {
+		modified[rng.Intn(len(modified))] ^= 0xff
+	}
+	inserted := append(append(append([]byte(nil), base[:10000]...), randomTestBytes(rng, 333)...), base[10000:]...)
+	removed := append(append([]byte(nil), base[:20000]...), base[20123:]...)
+	reordered := append(append([]byte(nil), base[32768:]...), base[:32768]...)
+	shifted := append([]byte("header"), base...)
+
+	tests := []struct {
+		name           string
+		source, target []byte
+	}{
+		{"identical", base, base},
+		{"empty source", nil, base[:1000]},
+		{"empty target", base, nil},
+		{"both empty", nil, nil},
+		{"shorter than a block", []byte("abc"), []byte("abd")},
+		{"scattered byte changes", base, modified},
+		{"insertion", base, inserted},
+		{"deletion", base, removed},
+		{"reordered halves", base, reordered},
+		{"unaligned shift", base, shifted},
+		{"truncated", base, base[:len(base)-1]},
+		{"appended", base, append(append([]byte(nil), base...), 1, 2, 3)},
+		{"zero padding", make([]byte, 8192), append(make([]byte, 8192), 1)},
+		{"unrelated", base, randomTestBytes(rng, 4096)},
+	}
+	for _, tt := range tests {
+		delta := computeDelta(tt.source, tt.target)
+		got, err := applyDelta(tt.source, bytes.NewReader(delta), int64(len(tt.target)))
+		if err != nil {
+			t.Errorf("%s: applyDelta: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.target) {
+			t.Errorf("%s: round trip produced %d bytes, want %d", tt.name, len(got), len(tt.target))
+		}
+	}
+}
+
+// 与源文件差异很小时，差分应远小于目标文件
+func TestDeltaSize(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	source := randomTestBytes(rng, 256*1024)
+	target := append([]byte(nil), source...)
+	copy(target[100000:], "patched")
+
+	delta := computeDelta(source, target)
+	if len(delta) > len(target)/100 {
+		t.Errorf("delta is %d bytes for a %d byte target with one small change", len(delta), len(target))
+	}
+}
+
+// 源文件不匹配或差分损坏时返回 errInvalidDelta
+func TestApplyDeltaInvalid(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	source := randomTestBytes(rng, 4096)
+	target := append(append([]byte(nil), source[:2000]...), randomTestBytes(rng, 100)...)
+	delta := computeDelta(source, target)
+
+	otherSource := append([]byte(nil), source...)
+	otherSource[0] ^= 0xff
+	corrupted := append([]byte(nil), delta...)
+	corrupted[len(corrupted)-1] ^= 0xff
+
+	tests := []struct {
+		name   string
+		source []byte
+		delta  []byte
+	}{
+		{"source with different size", source[:4000], delta},
+		{"source with different content", otherSource, delta},
+		{"bad magic", source, append([]byte("XXXXXXXX"), delta[len(deltaMagic):]...)},
+		{"truncated", source, delta[:len(delta)/2]},
+		{"bad checksum", source, corrupted},
+		{"empty", source, nil},
+	}
+	for _, tt := range tests {
+		if _, err := applyDelta(tt.source, bytes.NewReader(tt.delta), 1<<20); !errors.Is(err, errInvalidDelta) {
+			t.Errorf("%s: applyDelta returned %v, want errInvalidDelta", tt.name, err)
+		}
+	}
+}
+
+// 目标大小超过上限的差分在分配内存之前被拒绝
+func TestApplyDeltaTargetSizeLimit(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	source := randomTestBytes(rng, 4096)
+	target := append(append([]byte(nil), source...), randomTestBytes(rng, 100)...)
+	delta := computeDelta(source, target)
+
+	if _, err := applyDelta(source, bytes.NewReader(delta), int64(len(target))-1); !errors.Is(err, errInvalidDelta) {
+		t.Errorf("target over the limit: applyDelta returned %v, want errInvalidDelta", err)
+	}
+
+	huge := []byte(deltaMagic)
+	huge = binary.AppendUvarint(huge, uint64(len(source)))
+	huge = binary.AppendUvarint(huge, 1<<62)
+	huge = append(huge, deltaOpInsert)
+	huge = binary.AppendUvarint(huge, 1<<62)
+	if _, err := applyDelta(source, bytes.NewReader(huge), 1<<20); !errors.Is(err, errInvalidDelta) {
+		t.Errorf("huge declared target: applyDelta returned %v, want errInvalidDelta", err)
+	}
+}
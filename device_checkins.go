@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 设备检查更新记录的本地存储，按 仓库/产品/设备 ID 保存最近一次记录
+var (
+	firmwareDataDir    string
+	deviceCheckins     = make(map[string]*DeviceCheckin)
+	deviceCheckinsMu   sync.Mutex
+	deviceCheckinDirty bool
+)
+
+// 记录定期写入磁盘的间隔
+const deviceCheckinFlushInterval = 5 * time.Second
+
+func deviceCheckinsFile() string {
+	return filepath.Join(firmwareDataDir, "device_checkins.json")
+}
+
+func deviceCheckinKey(repoName, productName, deviceID string) string {
+	return repoName + "/" + productName + "/" + deviceID
+}
+
+// 初始化设备记录：数据目录可通过 FIRMWARE_DATA_DIR 配置
+func initDeviceCheckins() {
+	firmwareDataDir = os.Getenv("FIRMWARE_DATA_DIR")
+	if firmwareDataDir == "" {
+		firmwareDataDir = "/data/minio-bridge/firmware"
+	}
+	if err := os.MkdirAll(firmwareDataDir, 0755); err != nil {
+		log.Printf("无法创建固件数据目录 %s: %v", firmwareDataDir, err)
+		return
+	}
+
+	data, err := os.ReadFile(deviceCheckinsFile())
+	if err == nil {
+		var checkins []*DeviceCheckin
+		if err := json.Unmarshal(data, &checkins); err != nil {
+			log.Printf("解析设备记录失败: %v", err)
+		}
+		deviceCheckinsMu.Lock()
+		for _, checkin := range checkins {
+			// 支持多仓库之前的记录属于默认仓库
+			if checkin.Repository == "" {
+				checkin.Repository = defaultFirmwareRepoName
+			}
+			deviceCheckins[deviceCheckinKey(checkin.Repository, checkin.ProductName, checkin.DeviceID)] = checkin
+		}
+		deviceCheckinsMu.Unlock()
+	} else if !os.IsNotExist(err) {
+		log.Printf("读取设备记录失败: %v", err)
+	}
+
+	go func() {
+		for range time.Tick(deviceCheckinFlushInterval) {
+			if err := flushDeviceCheckins(); err != nil {
+				log.Printf("保存设备记录失败: %v", err)
+			}
+		}
+	}()
+}
+
+// flushDeviceCheckins 有变化时把设备记录写入磁盘，先写临时文件再替换
+func flushDeviceCheckins() error {
+	deviceCheckinsMu.Lock()
+	if !deviceCheckinDirty {
+		deviceCheckinsMu.Unlock()
+		return nil
+	}
+	checkins := make([]DeviceCheckin, 0, len(deviceCheckins))
+	for _, checkin := range deviceCheckins {
+		checkins = append(checkins, *checkin)
+	}
+	deviceCheckinDirty = false
+	deviceCheckinsMu.Unlock()
+
+	data, err := json.Marshal(checkins)
+	if err == nil {
+		tmpFile := deviceCheckinsFile() + ".tmp"
+		if err = os.WriteFile(tmpFile, data, 0644); err == nil {
+			err = os.Rename(tmpFile, deviceCheckinsFile())
+		}
+	}
+	if err != nil {
+		// 写入失败时下次重试
+		deviceCheckinsMu.Lock()
+		deviceCheckinDirty = true
+		deviceCheckinsMu.Unlock()
+	}
+	return err
+}
+
+// recordDeviceCheckin 更新设备的最近一次记录
+func recordDeviceCheckin(checkin DeviceCheckin) {
+	deviceCheckinsMu.Lock()
+	defer deviceCheckinsMu.Unlock()
+
+	key := deviceCheckinKey(checkin.Repository, checkin.ProductName, checkin.DeviceID)
+	if previous, ok := deviceCheckins[key]; ok {
+		checkin.FirstSeen = previous.FirstSeen
+		checkin.CheckinCount = previous.CheckinCount
+	} else {
+		checkin.FirstSeen = checkin.LastCheckin
+	}
+	checkin.CheckinCount++
+	deviceCheckins[key] = &checkin
+	deviceCheckinDirty = true
+}
+
+// renameDeviceCheckinProduct 产品改名后把设备记录转到新名称下
+func renameDeviceCheckinProduct(repo *firmwareRepository, oldName, newName string) {
+	deviceCheckinsMu.Lock()
+	defer deviceCheckinsMu.Unlock()
+
+	for key, checkin := range deviceCheckins {
+		if checkin.Repository != repo.Name || checkin.ProductName != oldName {
+			continue
+		}
+		delete(deviceCheckins, key)
+		checkin.ProductName = newName
+		deviceCheckins[deviceCheckinKey(repo.Name, newName, checkin.DeviceID)] = checkin
+		deviceCheckinDirty = true
+	}
+}
+
+// listDeviceCheckins 返回仓库中设备记录的副本，productName 为空时返回全部产品
+func listDeviceCheckins(repo *firmwareRepository, productName string) []DeviceCheckin {
+	deviceCheckinsMu.Lock()
+	defer deviceCheckinsMu.Unlock()
+
+	checkins := []DeviceCheckin{}
+	for _, checkin := range deviceCheckins {
+		if checkin.Repository != repo.Name {
+			continue
+		}
+		if productName == "" || checkin.ProductName == productName {
+			checkins = append(checkins, *checkin)
+		}
+	}
+	return checkins
+}
+
+// deviceCheckinHandler 设备检查更新：返回是否有更新、目标版本、大小、哈希、发布说明、是否强制更新和下载链接，并记录本次检查
+func deviceCheckinHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	type DeviceCheckinRequest struct {
+		ProductName      string `json:"product_name"`
+		DeviceID         string `json:"device_id"`
+		CurrentVersion   string `json:"current_version"`
+		HardwareRevision string `json:"hardware_revision"`
+		Edition          string `json:"edition"`
+		Channel          string `json:"channel"`  // 默认 stable
+		Language         string `json:"language"` // 发布说明的语言，为空时返回全部语言
+	}
+
+	var request DeviceCheckinRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.ProductName == "" || request.DeviceID == "" || request.CurrentVersion == "" {
+		http.Error(w, "product_name, device_id and current_version are required", http.StatusBadRequest)
+		return
+	}
+
+	request.ProductName, err = resolveFirmwareProductName(r.Context(), repo, request.ProductName)
+	if err != nil {
+		http.Error(w, err.Error(), firmwareProductErrorStatus(err))
+		return
+	}
+	if request.Channel == "" {
+		request.Channel = firmwareChannelStable
+	}
+	channel, err := normalizeFirmwareChannel(request.Channel)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	device := firmwareDevice{
+		ProductName:      request.ProductName,
+		DeviceID:         request.DeviceID,
+		Channel:          channel,
+		Edition:          request.Edition,
+		CurrentVersion:   request.CurrentVersion,
+		HardwareRevision: request.HardwareRevision,
+	}
+	plan, err := planFirmwareUpdate(r.Context(), repo, device)
+	if errors.Is(err, errInvalidCurrentVersion) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("设备 %s 检查更新失败: %v", request.DeviceID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordFirmwareUpdateCheck(r, repo, device, plan)
+
+	response := DeviceCheckinResponse{
+		UpdateAvailable: plan.UpdateAvailable,
+		CurrentVersion:  request.CurrentVersion,
+		Method:          plan.Method,
+		Deltas:          plan.Deltas,
+		URL:             plan.FullURL,
+	}
+	if target := plan.Target; target != nil {
+		response.TargetVersion = plan.TargetVersion
+		response.Version = target.Version
+		response.FirmwareID = target.ID
+		response.Size = target.Size
+		response.SHA256 = target.SHA256
+		response.FirmwareReleaseInfo = target.FirmwareReleaseInfo
+		response.ReleaseNotes = target.ReleaseNotes.Select(request.Language)
+		response.UpgradePath = plan.UpgradePath
+
+		// 差分应用失败时设备可以回退到完整镜像
+		if response.URL == "" {
+			response.URL, err = presignFirmwareObject(r.Context(), repo, firmwareObjectKey(repo, *target))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	recordDeviceCheckin(DeviceCheckin{
+		Repository:       repo.Name,
+		DeviceID:         request.DeviceID,
+		ProductName:      request.ProductName,
+		CurrentVersion:   request.CurrentVersion,
+		HardwareRevision: request.HardwareRevision,
+		Edition:          request.Edition,
+		Channel:          channel,
+		TargetVersion:    response.Version,
+		UpdateAvailable:  response.UpdateAvailable,
+		LastCheckin:      time.Now(),
+	})
+
+	jsonResponse, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,610 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+// 加密配置在租户桶中的存放位置
+const encryptionConfigKey = ".bridge/encryption.json"
+
+// 记录对象加密方式的元数据键，格式为 sse-c:<密钥版本>、sse-s3 或 sse-kms:<KMS 密钥 ID>
+const sseMetadataKey = "Sse-Key"
+
+// 租户加密方式
+const (
+	sseModeNone = "none"
+	sseModeS3   = "sse-s3"
+	sseModeKMS  = "sse-kms"
+	sseModeC    = "sse-c"
+)
+
+// 当前及历史密钥都无法读取对象，例如 SSE-C 对象使用的密钥已不可用
+var errObjectKeyUnavailable = errors.New("no encryption key can read the object")
+
+// 租户加密配置
+type TenantEncryption struct {
+	Mode       string `json:"mode"`                 // none、sse-s3、sse-kms、sse-c
+	KMSKeyID   string `json:"kmsKeyId,omitempty"`   // SSE-KMS 使用的密钥 ID
+	KeyVersion int    `json:"keyVersion,omitempty"` // SSE-C 当前密钥版本，从 1 开始
+}
+
+// 密钥轮换任务进度
+type KeyRotationJob struct {
+	ComID      string   `json:"comID"`
+	Status     string   `json:"status"` // running、completed、failed
+	Target     string   `json:"target"` // 目标加密方式
+	Total      int      `json:"total"`  // 需要检查的对象数
+	Processed  int      `json:"processed"`
+	Rewritten  int      `json:"rewritten"` // 已重新加密的对象数
+	Failed     int      `json:"failed"`
+	Errors     []string `json:"errors,omitempty"`
+	StartedAt  string   `json:"startedAt"`
+	FinishedAt string   `json:"finishedAt,omitempty"`
+}
+
+var (
+	masterKey           []byte
+	encryptionMu        sync.Mutex
+	tenantEncryptions   = make(map[string]TenantEncryption)
+	keyRotationJobs     = make(map[string]*KeyRotationJob)
+	keyRotationJobsMu   sync.Mutex
+	encryptionConfigMux sync.Mutex // 串行化加密配置文件的读写
+)
+
+// 初始化加密：加载本地主密钥文件和租户加密配置
+func initEncryption() {
+	reservedMetadataKeys[sseMetadataKey] = true
+
+	keyFile := os.Getenv("MINIO_MASTER_KEY_FILE")
+	if keyFile == "" {
+		keyFile = "/data/minio-bridge/master.key"
+	}
+	key, err := loadMasterKey(keyFile)
+	if err != nil {
+		log.Printf("未加载主密钥 %s，SSE-C 不可用: %v", keyFile, err)
+	} else {
+		masterKey = key
+	}
+
+	configs, err := loadTenantEncryptions(context.Background())
+	if err != nil {
+		log.Printf("加载租户加密配置失败: %v", err)
+		return
+	}
+	encryptionMu.Lock()
+	tenantEncryptions = configs
+	encryptionMu.Unlock()
+}
+
+// 读取主密钥文件，支持 32 字节原始数据、hex 或 base64 编码
+func loadMasterKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 32 {
+		return data, nil
+	}
+	text := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, fmt.Errorf("主密钥必须为 256 位")
+}
+
+// 由主密钥派生租户指定版本的 SSE-C 密钥
+func deriveTenantKey(tenant string, version int) ([]byte, error) {
+	if masterKey == nil {
+		return nil, fmt.Errorf("master key is not loaded")
+	}
+	mac := hmac.New(sha256.New, masterKey)
+	mac.Write([]byte("sse-c/" + tenant + "/" + strconv.Itoa(version)))
+	return mac.Sum(nil), nil
+}
+
+// 获取租户加密配置
+func encryptionForTenant(tenant string) TenantEncryption {
+	encryptionMu.Lock()
+	defer encryptionMu.Unlock()
+	if config, ok := tenantEncryptions[tenant]; ok && config.Mode != "" {
+		return config
+	}
+	return TenantEncryption{Mode: sseModeNone}
+}
+
+// 根据加密配置构造 ServerSide 和对应的元数据描述
+func serverSideFor(tenant string, config TenantEncryption) (encrypt.ServerSide, string, error) {
+	switch config.Mode {
+	case sseModeS3:
+		return encrypt.NewSSE(), sseModeS3, nil
+	case sseModeKMS:
+		sse, err := encrypt.NewSSEKMS(config.KMSKeyID, nil)
+		return sse, sseModeKMS + ":" + config.KMSKeyID, err
+	case sseModeC:
+		key, err := deriveTenantKey(tenant, config.KeyVersion)
+		if err != nil {
+			return nil, "", err
+		}
+		sse, err := encrypt.NewSSEC(key)
+		return sse, sseModeC + ":" + strconv.Itoa(config.KeyVersion), err
+	}
+	return nil, "", nil
+}
+
+// 获取写入租户对象时使用的加密参数
+func writeSSE(key string) (encrypt.ServerSide, map[string]string, error) {
+	tenant := tenantFromKey(key)
+	sse, desc, err := serverSideFor(tenant, encryptionForTenant(tenant))
+	if err != nil || sse == nil {
+		return nil, nil, err
+	}
+	return sse, map[string]string{sseMetadataKey: desc}, nil
+}
+
+// 获取读取对象时使用的加密参数。SSE-S3/KMS 由服务端透明解密，
+// SSE-C 需要依次尝试当前及历史密钥版本，最后尝试未加密
+func readSSE(ctx context.Context, key, versionID string) (encrypt.ServerSide, minio.ObjectInfo, error) {
+	tenant := tenantFromKey(key)
+	config := encryptionForTenant(tenant)
+
+	var candidates []encrypt.ServerSide
+	if masterKey != nil {
+		// 切换为其他加密方式后保留 KeyVersion，仍可读取旧的 SSE-C 对象
+		for v := config.KeyVersion; v >= 1; v-- {
+			sse, _, err := serverSideFor(tenant, TenantEncryption{Mode: sseModeC, KeyVersion: v})
+			if err == nil {
+				candidates = append(candidates, sse)
+			}
+		}
+	}
+	// 未使用 SSE-C 的租户优先尝试不带密钥读取
+	if config.Mode != sseModeC {
+		candidates = append([]encrypt.ServerSide{nil}, candidates...)
+	} else {
+		candidates = append(candidates, nil)
+	}
+
+	var lastErr error
+	for _, sse := range candidates {
+		stat, err := minioClient.StatObject(ctx, bucketName, key, minio.StatObjectOptions{
+			ServerSideEncryption: sse,
+			VersionID:            versionID,
+		})
+		if err == nil {
+			return sse, stat, nil
+		}
+		code := minio.ToErrorResponse(err).Code
+		if code == "NoSuchKey" || code == "NoSuchVersion" {
+			return nil, minio.ObjectInfo{}, err
+		}
+		lastErr = err
+	}
+	// S3 以 400 或 403 拒绝读取说明密钥不匹配，其他错误（如网络错误）原样返回
+	if status := minio.ToErrorResponse(lastErr).StatusCode; status == http.StatusBadRequest || status == http.StatusForbidden {
+		return nil, minio.ObjectInfo{}, fmt.Errorf("%w: %s: %v", errObjectKeyUnavailable, key, lastErr)
+	}
+	return nil, minio.ObjectInfo{}, lastErr
+}
+
+// 读取租户对象，自动选择解密参数
+func getObjectSSE(ctx context.Context, key, versionID string) (*minio.Object, minio.ObjectInfo, error) {
+	sse, stat, err := readSSE(ctx, key, versionID)
+	if err != nil {
+		return nil, minio.ObjectInfo{}, err
+	}
+	// 去重存储的引用对象从 blob 读取实际内容
+	if sum, size, ok := blobRef(stat.UserMetadata); ok {
+		stat.Size = size
+		object, err := minioClient.GetObject(ctx, bucketName, blobKey(sum), minio.GetObjectOptions{})
+		return object, stat, err
+	}
+	object, err := minioClient.GetObject(ctx, bucketName, key, minio.GetObjectOptions{
+		ServerSideEncryption: sse,
+		VersionID:            versionID,
+	})
+	return object, stat, err
+}
+
+// 服务端复制租户对象，源对象按其实际加密方式解密，目标对象按租户当前配置加密；
+// metadata 为 nil 时保留源对象的用户元数据。
+// 源对象为去重引用时：目标仍使用去重存储则复制引用并增加引用计数，否则从 blob 复制实际内容
+func copyObjectSSE(ctx context.Context, srcKey, versionID, dstKey string, metadata map[string]string) error {
+	srcSSE, stat, err := readSSE(ctx, srcKey, versionID)
+	if err != nil {
+		return err
+	}
+
+	copyKey, copyVersionID := srcKey, versionID
+	sum, _, isRef := blobRef(stat.UserMetadata)
+	materialize := isRef && !dedupForKey(dstKey)
+	// 覆盖同一 key 且未开启版本控制时，旧引用随之消失
+	overwritesRef := isRef && srcKey == dstKey && !bucketVersioning
+	if materialize {
+		copyKey, copyVersionID, srcSSE = blobKey(sum), "", nil
+	} else if isRef && !overwritesRef {
+		if err := addBlobRef(ctx, sum); err != nil {
+			return err
+		}
+	}
+	dstSSE, sseMeta, err := writeSSE(dstKey)
+	if err != nil {
+		return err
+	}
+
+	if metadata == nil {
+		metadata = make(map[string]string)
+		for k, v := range stat.UserMetadata {
+			metadata[k] = v
+		}
+	}
+	delete(metadata, sseMetadataKey)
+	for k, v := range sseMeta {
+		metadata[k] = v
+	}
+	if stat.ContentType != "" {
+		metadata["Content-Type"] = stat.ContentType
+	}
+	if materialize {
+		delete(metadata, blobSha256MetadataKey)
+		delete(metadata, blobSizeMetadataKey)
+	}
+
+	_, err = minioClient.CopyObject(ctx, minio.CopyDestOptions{
+		Bucket:          bucketName,
+		Object:          dstKey,
+		Encryption:      dstSSE,
+		UserMetadata:    metadata,
+		ReplaceMetadata: true,
+	}, minio.CopySrcOptions{
+		Bucket:     bucketName,
+		Object:     copyKey,
+		VersionID:  copyVersionID,
+		Encryption: srcSSE,
+	})
+
+	switch {
+	case err != nil && isRef && !materialize && !overwritesRef:
+		// 复制失败，撤销预先增加的引用
+		if releaseErr := releaseBlobRef(ctx, sum); releaseErr != nil {
+			log.Printf("释放 blob 引用失败: %v", releaseErr)
+		}
+	case err == nil && materialize && overwritesRef:
+		if releaseErr := releaseBlobRef(ctx, sum); releaseErr != nil {
+			log.Printf("释放 blob 引用失败: %v", releaseErr)
+		}
+	}
+	return err
+}
+
+// 从租户桶读取加密配置
+func loadTenantEncryptions(ctx context.Context) (map[string]TenantEncryption, error) {
+	configs := make(map[string]TenantEncryption)
+	object, err := minioClient.GetObject(ctx, bucketName, encryptionConfigKey, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	err = json.NewDecoder(object).Decode(&configs)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return configs, nil
+		}
+		return nil, fmt.Errorf("解析加密配置失败: %v", err)
+	}
+	return configs, nil
+}
+
+// 更新租户加密配置并持久化
+func updateTenantEncryption(ctx context.Context, tenant string, update func(*TenantEncryption) error) (TenantEncryption, error) {
+	encryptionConfigMux.Lock()
+	defer encryptionConfigMux.Unlock()
+
+	configs, err := loadTenantEncryptions(ctx)
+	if err != nil {
+		return TenantEncryption{}, err
+	}
+	config := configs[tenant]
+	if config.Mode == "" {
+		config.Mode = sseModeNone
+	}
+	if err := update(&config); err != nil {
+		return TenantEncryption{}, err
+	}
+	configs[tenant] = config
+
+	data, err := json.MarshalIndent(configs, "", "  ")
+	if err != nil {
+		return TenantEncryption{}, fmt.Errorf("JSON 编码失败: %v", err)
+	}
+	_, err = minioClient.PutObject(ctx, bucketName, encryptionConfigKey, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/json",
+	})
+	if err != nil {
+		return TenantEncryption{}, fmt.Errorf("保存加密配置失败: %v", err)
+	}
+
+	encryptionMu.Lock()
+	tenantEncryptions[tenant] = config
+	encryptionMu.Unlock()
+	return config, nil
+}
+
+// 启动密钥轮换任务：将租户下所有对象重新加密为当前配置
+func startKeyRotation(tenant string) (*KeyRotationJob, error) {
+	keyRotationJobsMu.Lock()
+	defer keyRotationJobsMu.Unlock()
+
+	if job, ok := keyRotationJobs[tenant]; ok && job.Status == "running" {
+		return nil, fmt.Errorf("key rotation for tenant %s is already running", tenant)
+	}
+
+	config := encryptionForTenant(tenant)
+	_, target, err := serverSideFor(tenant, config)
+	if err != nil {
+		return nil, err
+	}
+	if target == "" {
+		target = sseModeNone
+	}
+
+	job := &KeyRotationJob{
+		ComID:     tenant,
+		Status:    "running",
+		Target:    target,
+		StartedAt: time.Now().In(shanghaiLocation).Format("2006-01-02 15:04:05"),
+	}
+	keyRotationJobs[tenant] = job
+	go runKeyRotation(job)
+	return job, nil
+}
+
+// 逐个检查租户对象，加密方式与目标不一致时通过服务端复制重新加密
+func runKeyRotation(job *KeyRotationJob) {
+	ctx := context.Background()
+
+	var keys []string
+	opts := minio.ListObjectsOptions{Prefix: job.ComID + "/", Recursive: true}
+	for object := range minioClient.ListObjects(ctx, bucketName, opts) {
+		if object.Err != nil {
+			finishKeyRotation(job, "failed", object.Err.Error())
+			return
+		}
+		if !strings.HasSuffix(object.Key, "/") {
+			keys = append(keys, object.Key)
+		}
+	}
+
+	keyRotationJobsMu.Lock()
+	job.Total = len(keys)
+	keyRotationJobsMu.Unlock()
+
+	for _, key := range keys {
+		rewritten, err := rotateObjectKey(ctx, key, job.Target)
+
+		keyRotationJobsMu.Lock()
+		job.Processed++
+		if err != nil {
+			job.Failed++
+			if len(job.Errors) < 100 {
+				job.Errors = append(job.Errors, fmt.Sprintf("%s: %v", key, err))
+			}
+		} else if rewritten {
+			job.Rewritten++
+		}
+		keyRotationJobsMu.Unlock()
+	}
+
+	if job.Failed > 0 {
+		finishKeyRotation(job, "failed", "")
+	} else {
+		finishKeyRotation(job, "completed", "")
+	}
+}
+
+func finishKeyRotation(job *KeyRotationJob, status, errMsg string) {
+	keyRotationJobsMu.Lock()
+	defer keyRotationJobsMu.Unlock()
+	job.Status = status
+	if errMsg != "" {
+		job.Errors = append(job.Errors, errMsg)
+	}
+	job.FinishedAt = time.Now().In(shanghaiLocation).Format("2006-01-02 15:04:05")
+	log.Printf("租户 %s 密钥轮换结束: %s，重新加密 %d 个对象，失败 %d 个", job.ComID, status, job.Rewritten, job.Failed)
+}
+
+// 重新加密单个对象，对象已是目标加密方式时跳过
+func rotateObjectKey(ctx context.Context, key, target string) (bool, error) {
+	_, stat, err := readSSE(ctx, key, "")
+	if err != nil {
+		return false, err
+	}
+	current := stat.UserMetadata[sseMetadataKey]
+	if current == "" {
+		current = sseModeNone
+	}
+	if current == target {
+		return false, nil
+	}
+
+	// 开启版本控制时原对象作为历史版本保留，重新加密的副本占用新的空间
+	var added int64
+	if bucketVersioning {
+		added = logicalSize(stat)
+	}
+	return true, replaceObjectWithQuota(ctx, key, &stat, &stat, added, func() error {
+		return copyObjectSSE(ctx, key, "", key, nil)
+	})
+}
+
+// 获取密钥轮换任务进度
+func getKeyRotationJob(tenant string) *KeyRotationJob {
+	keyRotationJobsMu.Lock()
+	defer keyRotationJobsMu.Unlock()
+	job, ok := keyRotationJobs[tenant]
+	if !ok {
+		return nil
+	}
+	jobCopy := *job
+	jobCopy.Errors = append([]string(nil), job.Errors...)
+	return &jobCopy
+}
+
+// 加密接口共用的请求体
+type tenantEncryptionRequest struct {
+	ComID    string `json:"comID"`
+	Mode     string `json:"mode"`
+	KMSKeyID string `json:"kmsKeyId"`
+}
+
+// 解析加密接口请求，并处理预检和 CORS
+func decodeTenantEncryptionRequest(w http.ResponseWriter, r *http.Request) (*tenantEncryptionRequest, bool) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return nil, false
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+
+	var request tenantEncryptionRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || request.ComID == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+	return &request, true
+}
+
+// 以 JSON 格式返回结果
+func writeEncryptionResponse(w http.ResponseWriter, v interface{}) {
+	jsonResponse, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// 获取租户加密配置
+func getTenantEncryptionHandler(w http.ResponseWriter, r *http.Request) {
+	request, ok := decodeTenantEncryptionRequest(w, r)
+	if !ok {
+		return
+	}
+	writeEncryptionResponse(w, encryptionForTenant(request.ComID))
+}
+
+// 设置租户加密方式，已有对象需通过密钥轮换重新加密
+func setTenantEncryptionHandler(w http.ResponseWriter, r *http.Request) {
+	request, ok := decodeTenantEncryptionRequest(w, r)
+	if !ok {
+		return
+	}
+
+	switch request.Mode {
+	case sseModeNone, sseModeS3:
+	case sseModeKMS:
+		if request.KMSKeyID == "" {
+			http.Error(w, "kmsKeyId is required for sse-kms", http.StatusBadRequest)
+			return
+		}
+	case sseModeC:
+		if masterKey == nil {
+			http.Error(w, "Master key is not loaded, sse-c is unavailable", http.StatusConflict)
+			return
+		}
+	default:
+		http.Error(w, "Invalid encryption mode", http.StatusBadRequest)
+		return
+	}
+
+	config, err := updateTenantEncryption(r.Context(), request.ComID, func(config *TenantEncryption) error {
+		config.Mode = request.Mode
+		config.KMSKeyID = request.KMSKeyID
+		if request.Mode == sseModeC && config.KeyVersion == 0 {
+			config.KeyVersion = 1
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeEncryptionResponse(w, config)
+}
+
+// 轮换租户密钥：SSE-C 租户生成新的密钥版本，然后在后台重新加密全部对象
+func rotateTenantKeyHandler(w http.ResponseWriter, r *http.Request) {
+	request, ok := decodeTenantEncryptionRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if job := getKeyRotationJob(request.ComID); job != nil && job.Status == "running" {
+		http.Error(w, "Key rotation is already running", http.StatusConflict)
+		return
+	}
+
+	_, err := updateTenantEncryption(r.Context(), request.ComID, func(config *TenantEncryption) error {
+		if config.Mode == sseModeC {
+			config.KeyVersion++
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	job, err := startKeyRotation(request.ComID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeEncryptionResponse(w, job)
+}
+
+// 查询密钥轮换任务进度
+func getKeyRotationStatusHandler(w http.ResponseWriter, r *http.Request) {
+	request, ok := decodeTenantEncryptionRequest(w, r)
+	if !ok {
+		return
+	}
+
+	job := getKeyRotationJob(request.ComID)
+	if job == nil {
+		http.Error(w, "No key rotation job found", http.StatusNotFound)
+		return
+	}
+	writeEncryptionResponse(w, job)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -34,8 +35,6 @@ func uploadFileHandler(w http.ResponseWriter, r *http.Request) {
 	// 	return
 	// }
 
-	w.WriteHeader(http.StatusOK)
-
 	if r.Method != http.MethodPost {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 		return
@@ -49,6 +48,8 @@ func uploadFileHandler(w http.ResponseWriter, r *http.Request) {
 
 	files := r.MultipartForm.File["files"]
 	filePaths := r.MultipartForm.Value["filePath"]
+	uploadUsers := r.MultipartForm.Value["upload_user"]
+	tenants := make(map[string]bool) // 本次上传涉及的租户
 
 	for i, fileHeader := range files {
 		if !isValidFileType(fileHeader.Filename) {
@@ -78,28 +79,106 @@ func uploadFileHandler(w http.ResponseWriter, r *http.Request) {
 			filePath = strings.TrimSuffix(filePaths[i], "/") + "/"
 		}
 
-		// 检查文件是否已存在
-		_, err = minioClient.StatObject(context.Background(), bucketName, filePath+fileHeader.Filename, minio.StatObjectOptions{})
+		// 检查文件是否已存在，按租户的加密方式读取，SSE-C 对象不带密钥无法 Stat
+		_, _, err = readSSE(context.Background(), filePath+fileHeader.Filename, "")
 		if err == nil {
 			// 文件已存在，跳过上传
 			log.Printf("File %s already exists, skipping upload", filePath+fileHeader.Filename)
 			continue
-		} else if err.(minio.ErrorResponse).Code != "NoSuchKey" {
+		} else if minio.ToErrorResponse(err).Code != "NoSuchKey" {
 			// 其他错误，返回错误信息
 			http.Error(w, "Error checking file existence", http.StatusInternalServerError)
 			return
 		}
 
+		// 预留租户配额，超出配额时拒绝上传
+		reservation, ok, err := reserveQuota(context.Background(), filePath+fileHeader.Filename, fileHeader.Size)
+		if err != nil {
+			if errors.Is(err, errQuotaExceeded) {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			} else {
+				http.Error(w, "Error checking tenant quota", http.StatusInternalServerError)
+			}
+			return
+		}
+		if !ok {
+			// 同名文件正在上传，跳过上传
+			log.Printf("File %s is being uploaded, skipping upload", filePath+fileHeader.Filename)
+			continue
+		}
+		tenants[reservation.tenant] = true
+
 		fmt.Println("mime 123: ", mime.String())
 		// 文件不存在，上传文件
-		_, err = minioClient.PutObject(context.Background(), bucketName, filePath+fileHeader.Filename, file, fileHeader.Size, minio.PutObjectOptions{ContentType: mime.String()})
+		// 按租户配置加密，并记录上传人用于版本历史展示
+		sse, metadata, err := writeSSE(filePath + fileHeader.Filename)
+		if err != nil {
+			reservation.release()
+			http.Error(w, "Error preparing encryption", http.StatusInternalServerError)
+			return
+		}
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		if len(uploadUsers) > 0 && uploadUsers[0] != "" {
+			metadata[uploaderMetadataKey] = uploadUsers[0]
+		}
+		var size int64
+		if dedupForKey(filePath + fileHeader.Filename) {
+			// 相同内容只存储一份，租户 key 保存对 blob 的引用
+			size, err = putDedupObject(context.Background(), filePath+fileHeader.Filename, newQuotaReader(file, reservation), fileHeader.Size, mime.String(), metadata)
+		} else {
+			putOptions := minio.PutObjectOptions{
+				ContentType:          mime.String(),
+				UserMetadata:         metadata,
+				ServerSideEncryption: sse,
+			}
+			var info minio.UploadInfo
+			info, err = minioClient.PutObject(context.Background(), bucketName, filePath+fileHeader.Filename, newQuotaReader(file, reservation), fileHeader.Size, putOptions)
+			size = info.Size
+		}
 		if err != nil {
+			reservation.release()
+			if errors.Is(err, errQuotaExceeded) {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
 			http.Error(w, "Error uploading file", http.StatusInternalServerError)
 			return
 		}
+		reservation.commit(size)
 	}
 
+	// 全部文件处理完后再写状态码，配额不足等错误才能返回对应的状态码
+	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, "Files uploaded successfully\n")
+
+	// 超过软限制时附带警告信息
+	for tenant := range tenants {
+		usage, err := getTenantUsage(context.Background(), tenant)
+		if err != nil {
+			continue
+		}
+		for _, warning := range usage.Warnings {
+			log.Println("Quota warning:", warning)
+			fmt.Fprintf(w, "Quota warning: %s\n", warning)
+		}
+	}
+}
+
+// 读取租户对象失败时返回的状态码：对象或版本不存在返回 404，版本 ID 不合法返回 400，
+// 没有可以解密对象的密钥时与 S3 一样返回 403
+func objectErrorStatus(err error) int {
+	if errors.Is(err, errObjectKeyUnavailable) {
+		return http.StatusForbidden
+	}
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NoSuchVersion":
+		return http.StatusNotFound
+	case "InvalidArgument":
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
 }
 
 // 下载文件
@@ -122,15 +201,14 @@ func downloadFileHandler(w http.ResponseWriter, r *http.Request) {
 	// 	return
 	// }
 
-	w.WriteHeader(http.StatusOK)
-
 	if r.Method != http.MethodPost {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 		return
 	}
 
 	type downloadFileRequest struct {
-		Key string `json:"key"`
+		Key       string `json:"key"`
+		VersionID string `json:"versionId"` // 可选，下载指定历史版本
 	}
 
 	var request downloadFileRequest
@@ -140,12 +218,16 @@ func downloadFileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	object, err := minioClient.GetObject(context.Background(), bucketName, request.Key, minio.GetObjectOptions{})
+	object, _, err := getObjectSSE(context.Background(), request.Key, request.VersionID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), objectErrorStatus(err))
 		return
 	}
 	defer object.Close()
+	if _, err := object.Stat(); err != nil {
+		http.Error(w, err.Error(), objectErrorStatus(err))
+		return
+	}
 
 	// 这里可以根据文件类型决定 Content-Type
 	w.Header().Set("Content-Type", "application/octet-stream")                 // 或者根据具体的文件类型设置
@@ -216,8 +298,9 @@ func DeleteDirectory(ctx context.Context, bucketName, prefix string) error {
 		defer close(objectsCh)
 		// 列出所有对象
 		opts := minio.ListObjectsOptions{
-			Prefix:    prefix,
-			Recursive: true,
+			Prefix:       prefix,
+			Recursive:    true,
+			WithMetadata: true,
 		}
 		for object := range minioClient.ListObjects(ctx, bucketName, opts) {
 			if object.Err != nil {
@@ -230,7 +313,7 @@ func DeleteDirectory(ctx context.Context, bucketName, prefix string) error {
 
 	// 删除所有对象
 	for object := range objectsCh {
-		err := minioClient.RemoveObject(ctx, bucketName, object.Key, minio.RemoveObjectOptions{})
+		err := removeObjectWithQuota(ctx, bucketName, object)
 		if err != nil {
 			return fmt.Errorf("failed to remove object %s: %w", object.Key, err)
 		}
@@ -240,11 +323,18 @@ func DeleteDirectory(ctx context.Context, bucketName, prefix string) error {
 	return nil
 }
 
+// 资源列表的可选项
+type resourceListOptions struct {
+	WithMeta  bool              // 是否在列表中返回标签和用户元数据
+	TagFilter map[string]string // 按标签过滤文件，目录不受影响
+}
+
 // 生成文件资源列表
-func buildResourceList(client *minio.Client, bucket, prefix string) []ObjectInfo {
+func buildResourceList(client *minio.Client, bucket, prefix string, listOpts resourceListOptions) []ObjectInfo {
 	opts := minio.ListObjectsOptions{
-		Recursive: false,
-		Prefix:    prefix,
+		Recursive:    false,
+		Prefix:       prefix,
+		WithMetadata: true, // 去重存储的对象需要从元数据中读取实际大小和校验和
 	}
 
 	objectCh := client.ListObjects(context.Background(), bucket, opts)
@@ -272,14 +362,24 @@ func buildResourceList(client *minio.Client, bucket, prefix string) []ObjectInfo
 			continue
 		}
 
+		// 按标签过滤文件
+		if !isDir && len(listOpts.TagFilter) > 0 && !matchTags(object.UserTags, listOpts.TagFilter) {
+			continue
+		}
+
 		// 添加文件或目录到相应的切片
-		sizeMB := float64(object.Size) / (1024 * 1024) // 转换为MB
+		sizeMB := float64(logicalSize(object)) / (1024 * 1024) // 转换为MB
 		info := ObjectInfo{
 			FileName:     name,
 			Key:          object.Key,
 			IsDir:        isDir,
 			Size:         math.Round(sizeMB*100) / 100, // 保留两位小数
 			LastModified: object.LastModified.Format("2006-01-02 15:04:05"),
+			Sha256:       objectSha256(object),
+		}
+		if listOpts.WithMeta && !isDir {
+			info.Tags = object.UserTags
+			info.Metadata = visibleUserMetadata(userMetadataFromList(object.UserMetadata))
 		}
 
 		if isDir {
@@ -323,8 +423,11 @@ func getResourceListHanlder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	type GetResourceListRequest struct {
-		Path  string `json:"path"`
-		ComID string `json:"comID"`
+		Path           string            `json:"path"`
+		ComID          string            `json:"comID"`
+		WithMeta       bool              `json:"withMeta"`       // 是否返回标签和元数据
+		TagFilter      map[string]string `json:"tagFilter"`      // 按标签过滤
+		IncludeDeleted bool              `json:"includeDeleted"` // 是否返回已删除（可撤销）的文件
 	}
 
 	var request GetResourceListRequest
@@ -342,8 +445,22 @@ func getResourceListHanlder(w http.ResponseWriter, r *http.Request) {
 		prefix = request.Path
 	}
 
+	// 校验标签过滤条件
+	if len(request.TagFilter) > 0 {
+		if _, err := normalizeObjectTags(request.TagFilter); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	// 构建资源列表
-	resourceList := buildResourceList(minioClient, bucketName, prefix)
+	resourceList := buildResourceList(minioClient, bucketName, prefix, resourceListOptions{
+		WithMeta:  request.WithMeta,
+		TagFilter: request.TagFilter,
+	})
+	if request.IncludeDeleted && bucketVersioning && len(request.TagFilter) == 0 {
+		resourceList = append(resourceList, listDeletedObjects(r.Context(), prefix)...)
+	}
 
 	// 将树形结构转换为JSON格式
 	jsonTree, err := json.MarshalIndent(resourceList, "", "  ")
@@ -376,8 +493,6 @@ func previewFileHandler(w http.ResponseWriter, r *http.Request) {
 	// 	return
 	// }
 
-	w.WriteHeader(http.StatusOK)
-
 	if r.Method != http.MethodGet {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 		return
@@ -389,12 +504,19 @@ func previewFileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	obj, err := minioClient.GetObject(context.Background(), bucketName, key, minio.GetObjectOptions{})
+	// 可选的版本 ID，用于预览历史版本
+	versionID := r.URL.Query().Get("versionId")
+
+	obj, _, err := getObjectSSE(context.Background(), key, versionID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), objectErrorStatus(err))
 		return
 	}
 	defer obj.Close()
+	if _, err := obj.Stat(); err != nil {
+		http.Error(w, err.Error(), objectErrorStatus(err))
+		return
+	}
 
 	// 读取部分文件数据用于 MIME 类型检测
 	buffer := make([]byte, 512) // 512 bytes is generally enough to detect the MIME type
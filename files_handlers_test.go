@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// 对象不存在时下载和预览返回 404，而不是已经写出的 200
+func TestReadFileHandlersStatus(t *testing.T) {
+	fake := newFakeMinio(t)
+	prevBucket := bucketName
+	bucketName = "nxt-tenant"
+	t.Cleanup(func() { bucketName = prevBucket })
+	fake.put(bucketName, "tenant-a/hello.txt", []byte("hello"))
+
+	tests := []struct {
+		name    string
+		request *http.Request
+		handler http.HandlerFunc
+		status  int
+	}{
+		{"download", httptest.NewRequest(http.MethodPost, "/download", strings.NewReader(`{"key":"tenant-a/hello.txt"}`)), downloadFileHandler, http.StatusOK},
+		{"download missing", httptest.NewRequest(http.MethodPost, "/download", strings.NewReader(`{"key":"tenant-a/missing.txt"}`)), downloadFileHandler, http.StatusNotFound},
+		{"preview", httptest.NewRequest(http.MethodGet, "/preview?key=tenant-a/hello.txt", nil), previewFileHandler, http.StatusOK},
+		{"preview missing", httptest.NewRequest(http.MethodGet, "/preview?key=tenant-a/missing.txt", nil), previewFileHandler, http.StatusNotFound},
+		{"preview without key", httptest.NewRequest(http.MethodGet, "/preview", nil), previewFileHandler, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		tt.handler(w, tt.request)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.status, w.Body.String())
+		}
+		if tt.status == http.StatusOK && w.Body.String() != "hello" {
+			t.Errorf("%s: body %q", tt.name, w.Body.String())
+		}
+	}
+}
+
+func TestObjectErrorStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{minio.ErrorResponse{Code: "NoSuchKey", StatusCode: http.StatusNotFound}, http.StatusNotFound},
+		{minio.ErrorResponse{Code: "NoSuchVersion", StatusCode: http.StatusNotFound}, http.StatusNotFound},
+		{minio.ErrorResponse{Code: "InvalidArgument", StatusCode: http.StatusBadRequest}, http.StatusBadRequest},
+		{fmt.Errorf("%w: tenant-a/hello.txt: Access Denied", errObjectKeyUnavailable), http.StatusForbidden},
+		{errors.New("connection refused"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := objectErrorStatus(tt.err); got != tt.status {
+			t.Errorf("objectErrorStatus(%v) = %d, want %d", tt.err, got, tt.status)
+		}
+	}
+}
@@ -0,0 +1,557 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// 离线固件包格式：tar 包，第一个文件是签名的清单 bundle.json（DSSE 信封），
+// 其后是各固件镜像，路径为 {product}/{标准文件名}
+const (
+	firmwareBundlePayloadType   = "application/vnd.nxt.firmware-bundle+json"
+	firmwareBundleManifestName  = "bundle.json"
+	firmwareBundleFormatVersion = 1
+)
+
+// 清单文件的大小上限
+const firmwareBundleManifestMaxSize = 16 << 20
+
+// 导入时登记的上传人和自动注册产品的创建人
+const firmwareBundleImportUser = "bundle-import"
+
+// 固件包格式不正确时返回
+var errInvalidFirmwareBundle = errors.New("invalid firmware bundle")
+
+// 导入对比结果
+const (
+	bundleStatusNew      = "new"
+	bundleStatusExists   = "exists"
+	bundleStatusConflict = "conflict"
+	bundleStatusInvalid  = "invalid"
+	bundleStatusImported = "imported"
+	bundleStatusFailed   = "failed"
+)
+
+// newFirmwareBundleManifest 生成固件包清单，镜像大小以存储中的对象为准，目录中没有校验和的固件不能导出
+func newFirmwareBundleManifest(ctx context.Context, repo *firmwareRepository, entries []firmwareEntry, createdBy string) (*FirmwareBundleManifest, error) {
+	manifest := &FirmwareBundleManifest{
+		FormatVersion: firmwareBundleFormatVersion,
+		CreatedBy:     createdBy,
+		CreatedAt:     firmwareTimestamp(),
+		Firmware:      []FirmwareBundleEntry{},
+	}
+	for _, entry := range entries {
+		if entry.Info.SHA256 == "" {
+			return nil, fmt.Errorf("%w: firmware %s has no recorded checksum, verify it first", errFirmwareIntegrity, entry.Info.ID)
+		}
+		stat, err := minioClient.StatObject(ctx, repo.Bucket, firmwareObjectKey(repo, entry.Info), minio.StatObjectOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("%w: firmware %s: %v", errFirmwareIntegrity, entry.Info.ID, err)
+		}
+		if entry.Info.Size > 0 && stat.Size != entry.Info.Size {
+			return nil, fmt.Errorf("%w: firmware %s size is %d, recorded %d", errFirmwareIntegrity, entry.Info.ID, stat.Size, entry.Info.Size)
+		}
+
+		bundleEntry := FirmwareBundleEntry{
+			ProductName:         entry.Desc.ProductName,
+			Version:             entry.Desc.Version,
+			Edition:             entry.Desc.Edition,
+			BuildDate:           entry.Desc.BuildDate,
+			FileType:            entry.Desc.FileType,
+			Channel:             firmwareChannelOf(entry.Info),
+			Path:                path.Join(entry.Desc.ProductName, entry.Desc.FileName()),
+			Size:                stat.Size,
+			SHA256:              entry.Info.SHA256,
+			FirmwareReleaseInfo: entry.Info.FirmwareReleaseInfo,
+		}
+		if _, imageManifest, err := loadFirmwareManifest(ctx, repo, entry.Info); err == nil {
+			bundleEntry.ImageSignatures = imageManifest.ImageSignatures
+		}
+		manifest.Firmware = append(manifest.Firmware, bundleEntry)
+	}
+	return manifest, nil
+}
+
+// writeFirmwareBundle 把签名清单和镜像写成 tar 包；镜像内容与清单中的校验和不一致时中止
+func writeFirmwareBundle(ctx context.Context, repo *firmwareRepository, w io.Writer, manifest *FirmwareBundleManifest, entries []firmwareEntry) error {
+	payload, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("JSON 编码失败: %v", err)
+	}
+	envelope, err := newSignedEnvelope(firmwareBundlePayloadType, payload)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(envelope, "", "  ")
+	if err != nil {
+		return fmt.Errorf("JSON 编码失败: %v", err)
+	}
+
+	tw := tar.NewWriter(w)
+	modTime := time.Now()
+	if err := tw.WriteHeader(&tar.Header{Name: firmwareBundleManifestName, Mode: 0644, Size: int64(len(data)), ModTime: modTime}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+
+	for i, entry := range entries {
+		bundleEntry := manifest.Firmware[i]
+		if err := tw.WriteHeader(&tar.Header{Name: bundleEntry.Path, Mode: 0644, Size: bundleEntry.Size, ModTime: modTime}); err != nil {
+			return err
+		}
+		object, err := minioClient.GetObject(ctx, repo.Bucket, firmwareObjectKey(repo, entry.Info), minio.GetObjectOptions{})
+		if err != nil {
+			return err
+		}
+		hasher := newFirmwareHasher()
+		_, err = io.Copy(io.MultiWriter(tw, hasher), object)
+		object.Close()
+		if err != nil {
+			return err
+		}
+		if checksums := hasher.Checksums(); checksums.SHA256 != bundleEntry.SHA256 {
+			return fmt.Errorf("%w: firmware %s checksum is %s, recorded %s", errFirmwareIntegrity, entry.Info.ID, checksums.SHA256, bundleEntry.SHA256)
+		}
+	}
+	return tw.Close()
+}
+
+// readFirmwareBundleManifest 读取并校验 tar 包中的清单，返回清单和签名有效的密钥 ID；
+// 清单未签名时只有 allowUnsigned 才接受
+func readFirmwareBundleManifest(r io.Reader, allowUnsigned bool) (*FirmwareBundleManifest, string, error) {
+	tr := tar.NewReader(r)
+	header, err := tr.Next()
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", errInvalidFirmwareBundle, err)
+	}
+	if header.Name != firmwareBundleManifestName {
+		return nil, "", fmt.Errorf("%w: first file must be %s, got %s", errInvalidFirmwareBundle, firmwareBundleManifestName, header.Name)
+	}
+	data, err := io.ReadAll(io.LimitReader(tr, firmwareBundleManifestMaxSize))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", errInvalidFirmwareBundle, err)
+	}
+
+	var envelope FirmwareManifestEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, "", fmt.Errorf("%w: %v", errInvalidFirmwareBundle, err)
+	}
+	keyID, payload, err := verifyEnvelope(envelope, firmwareBundlePayloadType)
+	if errors.Is(err, errFirmwareSignature) {
+		return nil, "", err
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", errInvalidFirmwareBundle, err)
+	}
+	if keyID == "" && !allowUnsigned {
+		return nil, "", fmt.Errorf("%w: bundle manifest is not signed", errFirmwareSignature)
+	}
+
+	var manifest FirmwareBundleManifest
+	if err := json.Unmarshal(payload, &manifest); err != nil {
+		return nil, "", fmt.Errorf("%w: %v", errInvalidFirmwareBundle, err)
+	}
+	if manifest.FormatVersion != firmwareBundleFormatVersion {
+		return nil, "", fmt.Errorf("%w: unsupported format version %d", errInvalidFirmwareBundle, manifest.FormatVersion)
+	}
+	return &manifest, keyID, nil
+}
+
+// 导入计划中的单个固件
+type firmwareBundlePlan struct {
+	Entry FirmwareBundleEntry
+	Desc  FirmwareDescriptor // 产品名称已解析为当前名称
+	Item  FirmwareBundleItem
+}
+
+// planFirmwareBundleImport 把清单中的固件与目录对比：
+// 目录中已有相同镜像时为 exists，有同一版本、构建日期但校验和不同的固件时为 conflict
+func planFirmwareBundleImport(ctx context.Context, repo *firmwareRepository, manifest *FirmwareBundleManifest) []*firmwareBundlePlan {
+	catalogs := make(map[string][]FirmwareInfo)
+	var plans []*firmwareBundlePlan
+	seenPaths := make(map[string]bool)
+
+	for _, entry := range manifest.Firmware {
+		desc := FirmwareDescriptor{
+			ProductName: entry.ProductName,
+			Edition:     entry.Edition,
+			Version:     entry.Version,
+			BuildDate:   entry.BuildDate,
+			FileType:    entry.FileType,
+		}
+		plan := &firmwareBundlePlan{
+			Entry: entry,
+			Desc:  desc,
+			Item: FirmwareBundleItem{
+				ProductName: entry.ProductName,
+				Version:     desc.DisplayVersion(),
+				Channel:     entry.Channel,
+				SHA256:      entry.SHA256,
+				Status:      bundleStatusNew,
+			},
+		}
+		plans = append(plans, plan)
+		invalid := func(err error) {
+			plan.Item.Status = bundleStatusInvalid
+			plan.Item.Message = err.Error()
+		}
+
+		if err := desc.Validate(); err != nil {
+			invalid(err)
+			continue
+		}
+		if entry.Path != path.Join(entry.ProductName, desc.FileName()) || seenPaths[entry.Path] {
+			invalid(fmt.Errorf("%w: unexpected image path %q", errInvalidFirmwareBundle, entry.Path))
+			continue
+		}
+		seenPaths[entry.Path] = true
+		if len(entry.SHA256) != 64 || entry.Size <= 0 {
+			invalid(fmt.Errorf("%w: missing size or sha256", errInvalidFirmwareBundle))
+			continue
+		}
+		channel, err := normalizeFirmwareChannel(entry.Channel)
+		if err != nil {
+			invalid(err)
+			continue
+		}
+		plan.Entry.Channel = channel
+
+		product, err := importTargetProduct(ctx, repo, entry.ProductName, desc.FileType, firmwareBundleImportUser, true)
+		if err != nil {
+			invalid(err)
+			continue
+		}
+		if err := product.checkRelease(desc.Edition, entry.HardwareRevisions); err != nil {
+			invalid(err)
+			continue
+		}
+		if err := product.checkFileType(desc.FileType); err != nil {
+			invalid(err)
+			continue
+		}
+		plan.Desc.ProductName = product.Name
+		plan.Item.ProductName = product.Name
+
+		firmwareList, ok := catalogs[product.Name]
+		if !ok {
+			firmwareList, err = getFirmwareList(repo, product.Name)
+			if err != nil {
+				plan.Item.Status = bundleStatusFailed
+				plan.Item.Message = err.Error()
+				continue
+			}
+			catalogs[product.Name] = firmwareList
+		}
+
+		for _, info := range firmwareList {
+			existing, err := firmwareDescriptorFromInfo(info)
+			if err != nil || existing.Edition != desc.Edition || existing.BuildDate != desc.BuildDate || existing.Version != desc.Version {
+				continue
+			}
+			plan.Item.ID = info.ID
+			if info.SHA256 == entry.SHA256 {
+				plan.Item.Status = bundleStatusExists
+			} else {
+				plan.Item.Status = bundleStatusConflict
+				plan.Item.Message = fmt.Sprintf("catalog has %s with sha256 %s", desc.DisplayVersion(), info.SHA256)
+			}
+			break
+		}
+		if plan.Item.Status != bundleStatusNew {
+			continue
+		}
+
+		// 存储中有镜像但目录中没有条目，需要先做一致性检查
+		exists, err := firmwareImageExists(ctx, repo, plan.Desc.ObjectKey(repo))
+		if err != nil {
+			plan.Item.Status = bundleStatusFailed
+			plan.Item.Message = err.Error()
+		} else if exists {
+			plan.Item.Status = bundleStatusConflict
+			plan.Item.Message = "image exists in storage without catalog entry"
+		} else if check := evaluateFirmwareVersion(firmwareList, product.Name, desc.Edition, desc.Version, false); !check.Valid {
+			// 旧版本也会导入，只做提示
+			plan.Item.Message = check.Message
+		}
+	}
+	return plans
+}
+
+// spoolBundleImage 把包中的镜像写入临时文件并校验大小和 SHA-256
+func spoolBundleImage(r io.Reader, entry FirmwareBundleEntry) (*os.File, error) {
+	file, err := os.CreateTemp("", "firmware-bundle-*")
+	if err != nil {
+		return nil, err
+	}
+	hasher := newFirmwareHasher()
+	_, err = io.Copy(io.MultiWriter(file, hasher), r)
+	if err == nil {
+		checksums := hasher.Checksums()
+		if checksums.Size != entry.Size || checksums.SHA256 != entry.SHA256 {
+			err = fmt.Errorf("%w: checksum mismatch: manifest lists %s, actual %s", errFirmwareIntegrity, entry.SHA256, checksums.SHA256)
+		}
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
+
+// importFirmwareBundleImage 校验并发布一个镜像，与上传接口使用相同的发布流程
+func importFirmwareBundleImage(ctx context.Context, repo *firmwareRepository, r io.Reader, plan *firmwareBundlePlan, uploadUser string) {
+	fail := func(err error) {
+		plan.Item.Status = bundleStatusFailed
+		plan.Item.Message = err.Error()
+	}
+
+	file, err := spoolBundleImage(r, plan.Entry)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	product, err := importTargetProduct(ctx, repo, plan.Desc.ProductName, plan.Desc.FileType, firmwareBundleImportUser, false)
+	if err != nil {
+		fail(err)
+		return
+	}
+	desc := plan.Desc
+	desc.ProductName = product.Name
+
+	// 包中的固件可能比目录中的版本旧，不检查版本号
+	info, err := publishFirmware(ctx, repo, desc, file, plan.Entry.Size, firmwarePublishOptions{
+		ContentType: "application/octet-stream",
+		UploadUser:  uploadUser,
+		Channel:     plan.Entry.Channel,
+		Force:       true,
+		Signature:   trustedImageSignature(plan.Entry.ImageSignatures),
+		Release:     plan.Entry.FirmwareReleaseInfo,
+	})
+	if errors.Is(err, errFirmwareExists) {
+		plan.Item.Status = bundleStatusExists
+		plan.Item.Message = err.Error()
+		return
+	}
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	plan.Item.ID = info.ID
+	plan.Item.Status = bundleStatusImported
+	plan.Item.Message = ""
+	if warnings := logUpgradePathWarnings(repo, product.Name, *info); len(warnings) > 0 {
+		plan.Item.Message = strings.Join(warnings, "; ")
+	}
+}
+
+// importFirmwareBundle 导入固件包：先校验清单签名并与目录对比，dryRun 时只返回对比结果；
+// 否则导入对比结果为 new 的固件，已存在和冲突的固件不导入。清单未签名时只有 allowUnsigned 才导入
+func importFirmwareBundle(ctx context.Context, repo *firmwareRepository, fileHeader *multipart.FileHeader, dryRun, allowUnsigned bool, importedBy string) (*FirmwareBundleReport, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	manifest, keyID, err := readFirmwareBundleManifest(file, allowUnsigned)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &FirmwareBundleReport{
+		CreatedBy: manifest.CreatedBy,
+		CreatedAt: manifest.CreatedAt,
+		SignedBy:  keyID,
+		DryRun:    dryRun,
+		Items:     []FirmwareBundleItem{},
+	}
+	if keyID == "" {
+		report.Warnings = append(report.Warnings, "bundle manifest is not signed")
+	}
+
+	plans := planFirmwareBundleImport(ctx, repo, manifest)
+	if !dryRun {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		pending := make(map[string]*firmwareBundlePlan)
+		for _, plan := range plans {
+			if plan.Item.Status == bundleStatusNew {
+				pending[plan.Entry.Path] = plan
+			}
+		}
+		if importedBy == "" {
+			importedBy = firmwareBundleImportUser
+		}
+
+		tr := tar.NewReader(file)
+		for len(pending) > 0 {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				report.Warnings = append(report.Warnings, fmt.Sprintf("bundle is truncated: %v", err))
+				break
+			}
+			plan, ok := pending[header.Name]
+			if !ok {
+				continue
+			}
+			delete(pending, header.Name)
+			importFirmwareBundleImage(ctx, repo, tr, plan, importedBy)
+		}
+		for _, plan := range pending {
+			plan.Item.Status = bundleStatusFailed
+			plan.Item.Message = fmt.Sprintf("image %s is missing from bundle", plan.Entry.Path)
+		}
+	}
+
+	for _, plan := range plans {
+		report.Items = append(report.Items, plan.Item)
+	}
+	return report, nil
+}
+
+// exportFirmwareBundleHandler 把选择的固件导出为签名的离线固件包（tar）
+func exportFirmwareBundleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	type ExportFirmwareBundleRequest struct {
+		Products  []firmwareExportSelection `json:"products"`
+		CreatedBy string                    `json:"created_by"`
+	}
+
+	var request ExportFirmwareBundleRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(request.Products) == 0 {
+		http.Error(w, "products is required", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := selectFirmwareForExportAll(r.Context(), repo, request.Products)
+	if err != nil {
+		http.Error(w, err.Error(), exportSelectionErrorStatus(err))
+		return
+	}
+	manifest, err := newFirmwareBundleManifest(r.Context(), repo, entries, request.CreatedBy)
+	if errors.Is(err, errFirmwareIntegrity) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fileName := fmt.Sprintf("firmware-bundle-%s.tar", time.Now().Format("20060102150405"))
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(fileName))
+
+	// 响应已开始发送，出错时只能中断连接，导入方会发现包不完整
+	if err := writeFirmwareBundle(r.Context(), repo, w, manifest, entries); err != nil {
+		log.Printf("导出固件包失败: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// importFirmwareBundleHandler 导入离线固件包，dry_run 时只返回与目录的对比结果
+func importFirmwareBundleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseMultipartForm(100 << 20) // 超出部分写入临时文件
+	if err != nil {
+		http.Error(w, "Error parsing form data", http.StatusBadRequest)
+		return
+	}
+	files := r.MultipartForm.File["bundle"]
+	if len(files) != 1 {
+		http.Error(w, "exactly one bundle file is required", http.StatusBadRequest)
+		return
+	}
+	dryRun, _ := strconv.ParseBool(firstFormValue(r.MultipartForm, "dry_run"))
+	importedBy := firstFormValue(r.MultipartForm, "imported_by")
+	// 未签名的清单需要显式允许，FIRMWARE_REQUIRE_SIGNATURE 开启时不能通过表单字段放开
+	allowUnsigned, _ := strconv.ParseBool(firstFormValue(r.MultipartForm, "allow_unsigned"))
+	allowUnsigned = (allowUnsigned || firmwareBundleAllowUnsigned) && !firmwareRequireSigning
+
+	report, err := importFirmwareBundle(r.Context(), repo, files[0], dryRun, allowUnsigned, importedBy)
+	if errors.Is(err, errInvalidFirmwareBundle) || errors.Is(err, errFirmwareSignature) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func newTestUnsignedBundle(t *testing.T) []byte {
+	t.Helper()
+	payload, err := json.Marshal(FirmwareBundleManifest{FormatVersion: firmwareBundleFormatVersion, CreatedAt: "2024-01-01T00:00:00Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 没有本地签名私钥时信封不带签名
+	envelope, err := newSignedEnvelope(firmwareBundlePayloadType, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: firmwareBundleManifestName, Mode: 0644, Size: int64(len(data))}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write(data)
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// 未签名的清单默认拒绝，只有显式允许时才接受
+func TestReadFirmwareBundleManifestUnsigned(t *testing.T) {
+	prevKey := firmwareSigningKey
+	firmwareSigningKey = nil
+	t.Cleanup(func() { firmwareSigningKey = prevKey })
+	bundle := newTestUnsignedBundle(t)
+
+	if _, _, err := readFirmwareBundleManifest(bytes.NewReader(bundle), false); !errors.Is(err, errFirmwareSignature) {
+		t.Errorf("unsigned bundle: got %v, want errFirmwareSignature", err)
+	}
+	manifest, keyID, err := readFirmwareBundleManifest(bytes.NewReader(bundle), true)
+	if err != nil {
+		t.Fatalf("unsigned bundle with allowUnsigned: %v", err)
+	}
+	if keyID != "" || manifest.FormatVersion != firmwareBundleFormatVersion {
+		t.Errorf("got manifest %+v signed by %q", manifest, keyID)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// 固件目录等 JSON 对象并发写入冲突时的最大重试次数
+const firmwareCatalogMaxRetries = 8
+
+// 目录等 JSON 对象无需变更时由更新函数返回，跳过写入
+var errCatalogUnchanged = errors.New("firmware catalog unchanged")
+
+// 读取 JSON 对象期间对象被其他副本修改
+var errFirmwareObjectChanged = errors.New("firmware object changed while reading")
+
+// 每个对象一把锁，串行化同一进程内对固件目录等 JSON 对象的读-改-写；
+// 锁按存储桶和 key 区分，不同仓库中同名的对象互不影响
+var (
+	objectLocks   = make(map[string]*sync.Mutex)
+	objectLocksMu sync.Mutex
+)
+
+func lockObject(bucket, objectKey string) func() {
+	lockKey := bucket + "/" + objectKey
+	objectLocksMu.Lock()
+	mu, ok := objectLocks[lockKey]
+	if !ok {
+		mu = &sync.Mutex{}
+		objectLocks[lockKey] = mu
+	}
+	objectLocksMu.Unlock()
+
+	mu.Lock()
+	return mu.Unlock
+}
+
+// loadFirmwareObject 读取固件仓库中的 JSON 对象，同时返回其 ETag
+func loadFirmwareObject[T any](ctx context.Context, repo *firmwareRepository, objectKey string) (T, string, error) {
+	return loadJSONObject[T](ctx, repo.Bucket, objectKey)
+}
+
+// loadJSONObject 读取存储桶中的 JSON 对象，同时返回其 ETag；对象不存在时返回零值和空 ETag。
+// 读取期间对象被修改时返回 errFirmwareObjectChanged
+func loadJSONObject[T any](ctx context.Context, bucket, objectKey string) (T, string, error) {
+	var value T
+
+	object, err := minioClient.GetObject(ctx, bucket, objectKey, minio.GetObjectOptions{})
+	if err != nil {
+		return value, "", fmt.Errorf("下载对象失败: %v", err)
+	}
+	defer object.Close()
+
+	// Stat 先取得 ETag，随后的读取带 If-Match，保证 ETag 与内容一致
+	stat, err := object.Stat()
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return value, "", nil
+		}
+		return value, "", fmt.Errorf("获取对象信息失败: %v", err)
+	}
+
+	err = json.NewDecoder(object).Decode(&value)
+	if isPreconditionFailed(err) {
+		return value, "", fmt.Errorf("%w: %s", errFirmwareObjectChanged, objectKey)
+	}
+	if err != nil {
+		return value, "", fmt.Errorf("解析 JSON 文件失败: %v", err)
+	}
+	return value, stat.ETag, nil
+}
+
+// saveFirmwareObject 条件写入固件仓库中的 JSON 对象
+func saveFirmwareObject(ctx context.Context, repo *firmwareRepository, objectKey string, value any, etag string) error {
+	return saveJSONObject(ctx, repo.Bucket, objectKey, value, etag)
+}
+
+// saveJSONObject 条件写入 JSON 对象：etag 非空时要求对象未被修改，为空时要求对象不存在
+func saveJSONObject(ctx context.Context, bucket, objectKey string, value any, etag string) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return fmt.Errorf("JSON 编码失败: %v", err)
+	}
+
+	opts := minio.PutObjectOptions{ContentType: "application/json"}
+	if etag != "" {
+		opts.SetMatchETag(etag)
+	} else {
+		ctx = withCreateOnly(ctx)
+	}
+
+	_, err = minioClient.PutObject(ctx, bucket, objectKey, bytes.NewReader(data), int64(len(data)), opts)
+	return err
+}
+
+type createOnlyContextKey struct{}
+
+// withCreateOnly 标记 ctx 中的 PUT 请求只在对象不存在时写入。
+// minio-go 的 SetMatchETagExcept 会给 ETag 加引号，而 S3 和 MinIO 只把不带引号的 * 视为通配符，
+// 因此由 createOnlyTransport 在签名之后添加 If-None-Match: *（该请求头不要求签名）
+func withCreateOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, createOnlyContextKey{}, true)
+}
+
+type createOnlyTransport struct {
+	base http.RoundTripper
+}
+
+func (t createOnlyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodPut && req.Context().Value(createOnlyContextKey{}) != nil {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", "*")
+	}
+	return t.base.RoundTrip(req)
+}
+
+// newMinioTransport 返回 MinIO 客户端使用的 HTTP 传输，支持 withCreateOnly 条件写入
+func newMinioTransport(secure bool) (http.RoundTripper, error) {
+	base, err := minio.DefaultTransport(secure)
+	if err != nil {
+		return nil, err
+	}
+	return createOnlyTransport{base: base}, nil
+}
+
+// 判断是否为条件写入冲突
+func isPreconditionFailed(err error) bool {
+	resp := minio.ToErrorResponse(err)
+	return resp.Code == "PreconditionFailed" || resp.StatusCode == 412
+}
+
+// updateFirmwareObject 以乐观锁方式修改固件仓库中的 JSON 对象
+func updateFirmwareObject[T any](ctx context.Context, repo *firmwareRepository, objectKey string, update func(T) (T, error)) error {
+	return updateJSONObject(ctx, repo.Bucket, objectKey, update)
+}
+
+// updateJSONObject 以乐观锁方式修改 JSON 对象：
+// 进程内按对象加锁，跨副本依赖 ETag 条件写入，冲突时重新读取并重试。
+// update 返回 errCatalogUnchanged 时跳过写入
+func updateJSONObject[T any](ctx context.Context, bucket, objectKey string, update func(T) (T, error)) error {
+	unlock := lockObject(bucket, objectKey)
+	defer unlock()
+
+	for attempt := 0; attempt < firmwareCatalogMaxRetries; attempt++ {
+		value, etag, err := loadJSONObject[T](ctx, bucket, objectKey)
+		if err == nil {
+			var updated T
+			updated, err = update(value)
+			if errors.Is(err, errCatalogUnchanged) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			err = saveJSONObject(ctx, bucket, objectKey, updated, etag)
+			if err == nil {
+				return nil
+			}
+			if !isPreconditionFailed(err) {
+				return fmt.Errorf("上传更新后的 JSON 文件失败: %v", err)
+			}
+		} else if !errors.Is(err, errFirmwareObjectChanged) {
+			return err
+		}
+
+		// 其他副本同时修改了对象，退避后重试
+		backoff := time.Duration(10<<attempt)*time.Millisecond + time.Duration(rand.Intn(20))*time.Millisecond
+		log.Printf("%s 写入冲突，%v 后重试 (%d/%d)", objectKey, backoff, attempt+1, firmwareCatalogMaxRetries)
+		time.Sleep(backoff)
+	}
+	return fmt.Errorf("%s 写入冲突，重试 %d 次后仍失败", objectKey, firmwareCatalogMaxRetries)
+}
+
+// loadFirmwareCatalog 读取产品的固件目录，同时返回其 ETag；目录不存在时返回空列表和空 ETag
+func loadFirmwareCatalog(ctx context.Context, repo *firmwareRepository, productName string) ([]FirmwareInfo, string, error) {
+	firmwareList, etag, err := loadFirmwareObject[[]FirmwareInfo](ctx, repo, getObjectKey(repo, productName))
+	if err != nil {
+		return nil, "", err
+	}
+
+	// 确保返回的切片不为 nil
+	if firmwareList == nil {
+		firmwareList = []FirmwareInfo{}
+	}
+	return firmwareList, etag, nil
+}
+
+// updateFirmwareCatalog 以乐观锁方式修改产品的固件目录
+func updateFirmwareCatalog(ctx context.Context, repo *firmwareRepository, productName string, update func([]FirmwareInfo) ([]FirmwareInfo, error)) error {
+	return updateFirmwareObject(ctx, repo, getObjectKey(repo, productName), func(firmwareList []FirmwareInfo) ([]FirmwareInfo, error) {
+		if firmwareList == nil {
+			firmwareList = []FirmwareInfo{}
+		}
+		return update(firmwareList)
+	})
+}
+
+// 固件下载链接的有效期，可通过 FIRMWARE_URL_EXPIRY 配置（例如 30m）
+func firmwareURLExpiry() time.Duration {
+	if expiry, err := time.ParseDuration(os.Getenv("FIRMWARE_URL_EXPIRY")); err == nil && expiry > 0 {
+		return expiry
+	}
+	return time.Hour
+}
+
+// presignFirmwareObject 生成固件存储桶中对象的预签名下载链接
+func presignFirmwareObject(ctx context.Context, repo *firmwareRepository, objectKey string) (string, error) {
+	presignedURL, err := minioClient.PresignedGetObject(ctx, repo.Bucket, objectKey, firmwareURLExpiry(), url.Values{})
+	if err != nil {
+		return "", fmt.Errorf("生成下载链接失败: %v", err)
+	}
+	return presignedURL.String(), nil
+}
+
+// listFirmwareProducts 列出固件仓库中已有固件目录的产品
+func listFirmwareProducts(ctx context.Context, repo *firmwareRepository) ([]string, error) {
+	var products []string
+	opts := minio.ListObjectsOptions{Prefix: repo.Prefix, Recursive: false}
+	for object := range minioClient.ListObjects(ctx, repo.Bucket, opts) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		if !strings.HasSuffix(object.Key, "/") {
+			continue
+		}
+		product := strings.TrimSuffix(strings.TrimPrefix(object.Key, repo.Prefix), "/")
+		if product == "" || strings.HasPrefix(product, ".") {
+			continue
+		}
+		products = append(products, product)
+	}
+	sort.Strings(products)
+	return products, nil
+}
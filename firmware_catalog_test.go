@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newTestFirmwareInfo(repo *firmwareRepository, productName string, i int) FirmwareInfo {
+	desc := FirmwareDescriptor{ProductName: productName, Edition: "Std", Version: fmt.Sprintf("1.0.%d", i), BuildDate: "20240101"}
+	return FirmwareInfo{
+		ProductName: desc.ProductName,
+		Version:     desc.Version,
+		Edition:     desc.Edition,
+		BuildDate:   desc.BuildDate,
+		ObjectKey:   desc.ObjectKey(repo),
+	}
+}
+
+func readTestCatalog(t *testing.T, fake *fakeS3, repo *firmwareRepository, productName string) []FirmwareInfo {
+	t.Helper()
+	data, ok := fake.get(repo.Bucket, getObjectKey(repo, productName))
+	if !ok {
+		t.Fatalf("catalog of %s not written", productName)
+	}
+	var firmwareList []FirmwareInfo
+	if err := json.Unmarshal(data, &firmwareList); err != nil {
+		t.Fatal(err)
+	}
+	return firmwareList
+}
+
+func checkTestCatalog(t *testing.T, firmwareList []FirmwareInfo, want int) {
+	t.Helper()
+	if len(firmwareList) != want {
+		t.Fatalf("catalog has %d entries, want %d", len(firmwareList), want)
+	}
+	ids := make(map[string]bool)
+	for _, info := range firmwareList {
+		if info.ID == "" || ids[info.ID] {
+			t.Fatalf("duplicate or empty firmware ID %q", info.ID)
+		}
+		ids[info.ID] = true
+	}
+}
+
+// 并发发布不同版本，目录中不能丢失任何条目
+func TestAppendFirmwareInfoConcurrent(t *testing.T) {
+	fake := newFakeMinio(t)
+	repo := defaultFirmwareRepo
+	const n = 32
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := appendFirmwareInfo(repo, newTestFirmwareInfo(repo, "NXT2204", i), true)
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkTestCatalog(t, readTestCatalog(t, fake, repo, "NXT2204"), n)
+}
+
+// 其他副本在读取和写入之间修改了目录：条件读取或写入失败后重新读取，两边的条目都保留
+func TestAppendFirmwareInfoConflictingReplica(t *testing.T) {
+	fake := newFakeMinio(t)
+	repo := defaultFirmwareRepo
+	catalogKey := getObjectKey(repo, "NXT2204")
+
+	// 另一个副本依次在以下时机写入：本副本发现目录不存在之后（创建冲突）、
+	// HEAD 取得 ETag 之后（读取冲突）、GET 读完内容之后（写入冲突）
+	conflicts := []struct {
+		method string
+		found  bool
+	}{
+		{"HEAD", false},
+		{"HEAD", true},
+		{"GET", true},
+	}
+	var mu sync.Mutex
+	written := 0
+	fake.afterRead = func(method, bucket, key string, found bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if key != catalogKey || written >= len(conflicts) {
+			return
+		}
+		if conflicts[written].method != method || conflicts[written].found != found {
+			return
+		}
+		// 模拟另一个副本：在本副本读取之后写入新条目，使本副本持有的 ETag 失效
+		data, _ := fake.get(bucket, key)
+		var firmwareList []FirmwareInfo
+		json.Unmarshal(data, &firmwareList)
+		info := newTestFirmwareInfo(repo, "NXT2204", 100+written)
+		info.ID = fmt.Sprintf("replica-%d", written)
+		firmwareList = append(firmwareList, info)
+		data, _ = json.Marshal(firmwareList)
+		fake.put(bucket, key, data)
+		written++
+	}
+
+	if _, err := appendFirmwareInfo(repo, newTestFirmwareInfo(repo, "NXT2204", 1), true); err != nil {
+		t.Fatal(err)
+	}
+
+	firmwareList := readTestCatalog(t, fake, repo, "NXT2204")
+	checkTestCatalog(t, firmwareList, len(conflicts)+1)
+	if written != len(conflicts) {
+		t.Fatalf("replica wrote %d times, want %d", written, len(conflicts))
+	}
+}
+
+// etag 为空时要求对象不存在，避免两个副本同时创建目录时互相覆盖
+func TestSaveFirmwareObjectCreateOnly(t *testing.T) {
+	newFakeMinio(t)
+	repo := defaultFirmwareRepo
+	ctx := context.Background()
+	key := getObjectKey(repo, "NXT2204")
+
+	if err := saveFirmwareObject(ctx, repo, key, []FirmwareInfo{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	err := saveFirmwareObject(ctx, repo, key, []FirmwareInfo{}, "")
+	if !isPreconditionFailed(err) {
+		t.Fatalf("second create returned %v, want precondition failed", err)
+	}
+
+	_, etag, err := loadFirmwareObject[[]FirmwareInfo](ctx, repo, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveFirmwareObject(ctx, repo, key, []FirmwareInfo{newTestFirmwareInfo(repo, "NXT2204", 1)}, etag); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveFirmwareObject(ctx, repo, key, []FirmwareInfo{newTestFirmwareInfo(repo, "NXT2204", 2)}, etag); !isPreconditionFailed(err) {
+		t.Fatalf("write with stale etag returned %v, want precondition failed", err)
+	}
+}
+
+// 同一版本不同构建日期是不同的条目，完全相同的条目返回 errFirmwareExists
+func TestAppendFirmwareInfoDuplicate(t *testing.T) {
+	fake := newFakeMinio(t)
+	repo := defaultFirmwareRepo
+
+	first := newTestFirmwareInfo(repo, "NXT2204", 1)
+	if _, err := appendFirmwareInfo(repo, first, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := appendFirmwareInfo(repo, first, true); !errors.Is(err, errFirmwareExists) {
+		t.Fatalf("duplicate append returned %v, want errFirmwareExists", err)
+	}
+
+	rebuilt := first
+	rebuilt.BuildDate = "20240102"
+	if _, err := appendFirmwareInfo(repo, rebuilt, true); err != nil {
+		t.Fatal(err)
+	}
+
+	checkTestCatalog(t, readTestCatalog(t, fake, repo, "NXT2204"), 2)
+}
+
+// 不同仓库中的同名产品各有独立的目录，并发发布互不覆盖
+func TestAppendFirmwareInfoRepositoriesIsolated(t *testing.T) {
+	repos := []*firmwareRepository{
+		{Name: defaultFirmwareRepoName, Bucket: "nxt-device", Prefix: "firmware/", URLScheme: "oss"},
+		{Name: "oem-a", Bucket: "nxt-device", Prefix: "oem-a/", URLScheme: "oss"},
+		{Name: "oem-b", Bucket: "oem-b-firmware", Prefix: "firmware/", URLScheme: "oss"},
+	}
+	fake := newFakeMinio(t, repos...)
+	const n = 8
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n*len(repos))
+	for _, repo := range repos {
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(repo *firmwareRepository, i int) {
+				defer wg.Done()
+				_, err := appendFirmwareInfo(repo, newTestFirmwareInfo(repo, "NXT2204", i), true)
+				errs <- err
+			}(repo, i)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, repo := range repos {
+		firmwareList := readTestCatalog(t, fake, repo, "NXT2204")
+		checkTestCatalog(t, firmwareList, n)
+		for _, info := range firmwareList {
+			if !strings.HasPrefix(info.ObjectKey, repo.productPrefix("NXT2204")) || info.URL != repo.objectURL(info.ObjectKey) {
+				t.Fatalf("entry %s of repository %s points to %s", info.ID, repo.Name, info.URL)
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// 固件发布通道
+const (
+	firmwareChannelStable  = "stable"
+	firmwareChannelBeta    = "beta"
+	firmwareChannelNightly = "nightly"
+)
+
+// 通道的稳定程度，数值越小越稳定
+var firmwareChannelRank = map[string]int{
+	firmwareChannelStable:  0,
+	firmwareChannelBeta:    1,
+	firmwareChannelNightly: 2,
+}
+
+// 目标通道不比当前通道更稳定时由 promoteFirmware 返回
+var errInvalidPromotion = errors.New("invalid firmware promotion")
+
+// normalizeFirmwareChannel 校验通道名称并统一为小写
+func normalizeFirmwareChannel(channel string) (string, error) {
+	channel = strings.ToLower(strings.TrimSpace(channel))
+	if _, ok := firmwareChannelRank[channel]; !ok {
+		return "", fmt.Errorf("invalid firmware channel %q, must be one of stable, beta, nightly", channel)
+	}
+	return channel, nil
+}
+
+// firmwareChannelOf 返回固件所在的通道，早期没有通道的条目视为 stable
+func firmwareChannelOf(info FirmwareInfo) string {
+	if info.Channel == "" {
+		return firmwareChannelStable
+	}
+	return info.Channel
+}
+
+// firmwareChannelVisible 判断某通道的固件对订阅 subscribed 通道的设备是否可见：
+// stable 只看到 stable，beta 看到 beta 和 stable，nightly 看到全部
+func firmwareChannelVisible(channel, subscribed string) bool {
+	rank, ok := firmwareChannelRank[channel]
+	if !ok {
+		return false
+	}
+	return rank <= firmwareChannelRank[subscribed]
+}
+
+// promoteFirmware 将固件提升到更稳定的通道，无需重新上传，并记录提升人和时间
+func promoteFirmware(ctx context.Context, repo *firmwareRepository, productName, id, channel, promotedBy string) (*FirmwareInfo, error) {
+	var promoted *FirmwareInfo
+	err := updateFirmwareCatalog(ctx, repo, productName, func(firmwareList []FirmwareInfo) ([]FirmwareInfo, error) {
+		promoted = nil
+		for i := range firmwareList {
+			if firmwareList[i].ID != id {
+				continue
+			}
+
+			current := firmwareChannelOf(firmwareList[i])
+			if firmwareChannelRank[channel] >= firmwareChannelRank[current] {
+				return nil, fmt.Errorf("%w: firmware %s is already in channel %s", errInvalidPromotion, id, current)
+			}
+
+			firmwareList[i].Channel = channel
+			firmwareList[i].PromotedFrom = current
+			firmwareList[i].PromotedBy = promotedBy
+			firmwareList[i].PromotedAt = firmwareTimestamp()
+
+			firmwareCopy := firmwareList[i]
+			promoted = &firmwareCopy
+			return firmwareList, nil
+		}
+		return nil, errFirmwareNotFound
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 清单中记录了通道，提升后重新签名
+	if err := resignFirmwareManifest(ctx, repo, *promoted); err != nil {
+		log.Printf("重新签名固件 %s 的清单失败: %v", promoted.ID, err)
+	}
+	return promoted, nil
+}
+
+func promoteFirmwareHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	type PromoteFirmwareRequest struct {
+		Id          string `json:"id"`
+		ProductName string `json:"product_name"`
+		Channel     string `json:"channel"` // 目标通道，默认 stable
+		PromotedBy  string `json:"promoted_by"`
+	}
+
+	var request PromoteFirmwareRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.Id == "" || request.ProductName == "" {
+		http.Error(w, "id and product_name are required", http.StatusBadRequest)
+		return
+	}
+
+	// 归档的产品不能再修改固件
+	product, err := writableFirmwareProduct(r.Context(), repo, request.ProductName)
+	if err != nil {
+		http.Error(w, err.Error(), firmwareProductErrorStatus(err))
+		return
+	}
+	request.ProductName = product.Name
+	if request.Channel == "" {
+		request.Channel = firmwareChannelStable
+	}
+	channel, err := normalizeFirmwareChannel(request.Channel)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	promoted, err := promoteFirmware(r.Context(), repo, request.ProductName, request.Id, channel, request.PromotedBy)
+	if errors.Is(err, errFirmwareNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errInvalidPromotion) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.MarshalIndent(promoted, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResponse)
+}
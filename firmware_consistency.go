@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+)
+
+// 修复时登记孤立镜像使用的上传人
+const firmwareConsistencyUser = "consistency-check"
+
+// 上传时间在宽限期内的孤立镜像可能属于正在进行的发布（镜像已上传、尚未写入目录），
+// 只报告不修复；可通过 FIRMWARE_ORPHAN_GRACE 配置（例如 30m）
+func firmwareOrphanGracePeriod() time.Duration {
+	if grace, err := time.ParseDuration(os.Getenv("FIRMWARE_ORPHAN_GRACE")); err == nil && grace >= 0 {
+		return grace
+	}
+	return 15 * time.Minute
+}
+
+// listFirmwareImages 列出产品目录下的固件镜像及其修改时间，不包括差分目录
+func listFirmwareImages(ctx context.Context, repo *firmwareRepository, productName string) (map[string]time.Time, error) {
+	prefix := repo.productPrefix(productName)
+	images := make(map[string]time.Time)
+	for object := range minioClient.ListObjects(ctx, repo.Bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		if strings.HasPrefix(object.Key, prefix+"delta/") || !isValidFirmwareType(object.Key) {
+			continue
+		}
+		images[object.Key] = object.LastModified
+	}
+	return images, nil
+}
+
+// checkFirmwareConsistency 比较固件目录和存储：报告没有目录条目的镜像和镜像已不存在的目录条目；
+// repair 时把能解析文件名的孤立镜像登记到 beta 通道，并删除镜像不存在的目录条目。
+// 先读目录再列存储：发布先上传镜像再写目录，这样目录中的条目一定能在存储中找到镜像，
+// 正在发布的镜像则表现为孤立镜像，由宽限期排除
+func checkFirmwareConsistency(ctx context.Context, repo *firmwareRepository, productName string, repair bool) (*FirmwareConsistencyReport, error) {
+	report := &FirmwareConsistencyReport{
+		ProductName:   productName,
+		OrphanImages:  []string{},
+		PendingImages: []string{},
+		MissingImages: []FirmwareInfo{},
+		Repaired:      repair,
+	}
+
+	firmwareList, err := getFirmwareList(repo, productName)
+	if err != nil {
+		return nil, err
+	}
+	images, err := listFirmwareImages(ctx, repo, productName)
+	if err != nil {
+		return nil, err
+	}
+
+	referenced := make(map[string]bool)
+	for _, info := range firmwareList {
+		objectKey := firmwareObjectKey(repo, info)
+		referenced[objectKey] = true
+		if _, ok := images[objectKey]; !ok {
+			report.MissingImages = append(report.MissingImages, info)
+		}
+	}
+	graceStart := time.Now().Add(-firmwareOrphanGracePeriod())
+	for image, modified := range images {
+		if referenced[image] {
+			continue
+		}
+		if modified.After(graceStart) {
+			report.PendingImages = append(report.PendingImages, image)
+			continue
+		}
+		report.OrphanImages = append(report.OrphanImages, image)
+	}
+	sort.Strings(report.OrphanImages)
+	sort.Strings(report.PendingImages)
+
+	if !repair {
+		return report, nil
+	}
+
+	for _, info := range report.MissingImages {
+		if err := deleteFirmwareInfo(repo, info.ID, productName); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("drop %s: %v", info.ID, err))
+			continue
+		}
+		report.Dropped = append(report.Dropped, info.ID)
+	}
+	for _, image := range report.OrphanImages {
+		info, err := adoptFirmwareImage(ctx, repo, productName, image)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("adopt %s: %v", image, err))
+			continue
+		}
+		report.Adopted = append(report.Adopted, *info)
+	}
+	if len(report.Adopted) > 0 {
+		triggerFirmwareDeltas(repo, productName)
+	}
+	return report, nil
+}
+
+// adoptFirmwareImage 把存储中已有的镜像登记到固件目录：重新计算校验和并生成签名清单；
+// 登记到 beta 通道，确认后再提升到 stable
+func adoptFirmwareImage(ctx context.Context, repo *firmwareRepository, productName, objectKey string) (*FirmwareInfo, error) {
+	desc, err := parseFirmwareFileName(productName, path.Base(objectKey))
+	if err != nil {
+		return nil, err
+	}
+
+	checksums, err := hashFirmwareImage(ctx, repo, objectKey)
+	if err != nil {
+		return nil, err
+	}
+	imageSignatures, err := signFirmwareImage(checksums, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	newInfo := FirmwareInfo{
+		ID:          uuid.NewString(),
+		ProductName: desc.ProductName,
+		Version:     desc.Version,
+		Edition:     desc.Edition,
+		BuildDate:   desc.BuildDate,
+		FileType:    desc.FileType,
+		UploadUser:  firmwareConsistencyUser,
+		ObjectKey:   objectKey,
+		Channel:     firmwareChannelBeta,
+
+		FirmwareChecksums: checksums,
+		IntegrityStatus:   integrityStatusOK,
+		LastVerifiedAt:    firmwareTimestamp(),
+	}
+	for _, sig := range imageSignatures {
+		newInfo.SignatureKeyIDs = append(newInfo.SignatureKeyIDs, sig.KeyID)
+	}
+
+	newInfo.ManifestKey, err = writeFirmwareManifest(ctx, repo, objectKey, newFirmwareManifest(repo, newInfo, imageSignatures))
+	if err != nil {
+		return nil, err
+	}
+
+	// 孤立镜像可能比目录中的版本旧，不检查版本号
+	info, err := appendFirmwareInfo(repo, newInfo, true)
+	if err != nil {
+		removeFirmwareObjects(ctx, repo, newInfo.ManifestKey)
+		return nil, err
+	}
+	log.Printf("已登记孤立的固件镜像 %s", objectKey)
+	return info, nil
+}
+
+// checkFirmwareConsistencyHandler 检查固件目录与存储是否一致，product_name 为空时检查所有产品，repair 时修复
+func checkFirmwareConsistencyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	type CheckFirmwareConsistencyRequest struct {
+		ProductName string `json:"product_name"`
+		Repair      bool   `json:"repair"`
+	}
+
+	var request CheckFirmwareConsistencyRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if request.ProductName != "" {
+		request.ProductName, err = resolveFirmwareProductName(r.Context(), repo, request.ProductName)
+		if err != nil {
+			http.Error(w, err.Error(), firmwareProductErrorStatus(err))
+			return
+		}
+	}
+
+	products := []string{request.ProductName}
+	if request.ProductName == "" {
+		products, err = listFirmwareProducts(r.Context(), repo)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	reports := []FirmwareConsistencyReport{}
+	for _, productName := range products {
+		report, err := checkFirmwareConsistency(r.Context(), repo, productName, request.Repair)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		reports = append(reports, *report)
+	}
+
+	jsonResponse, err := json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// 升级方式
+const (
+	updateMethodDelta = "delta"
+	updateMethodFull  = "full"
+	updateMethodNone  = "none"
+)
+
+// 设备上报的当前版本无法解析
+var errInvalidCurrentVersion = errors.New("invalid current_version")
+
+// 差分链最多包含的差分数量
+const firmwareDeltaMaxChain = 32
+
+var (
+	firmwareDeltaEnabled bool
+	firmwareDeltaMaxSize int64
+	firmwareDeltaQueue   = make(chan firmwareDeltaJob, 64)
+)
+
+// 差分生成队列中的任务：为仓库中的某个产品生成差分
+type firmwareDeltaJob struct {
+	repo        *firmwareRepository
+	productName string
+}
+
+// 差分索引存放在产品目录下
+func getDeltaIndexKey(repo *firmwareRepository, productName string) string {
+	return repo.key(productName, "deltas.json")
+}
+
+func firmwareDeltaKey(repo *firmwareRepository, productName string, from, to FirmwareDescriptor) string {
+	return repo.key(productName, "delta", fmt.Sprintf("%s_%s.patch", from.DisplayVersion(), to.DisplayVersion()))
+}
+
+// 初始化差分生成：后台任务定期为相邻版本生成差分，发布新固件时立即触发。
+// 可通过 FIRMWARE_DELTA=false 关闭，FIRMWARE_DELTA_INTERVAL 配置扫描间隔，FIRMWARE_DELTA_MAX_SIZE 限制参与差分的镜像大小
+func initFirmwareDeltas() {
+	if os.Getenv("FIRMWARE_DELTA") == "false" {
+		log.Println("未开启固件差分生成")
+		return
+	}
+	firmwareDeltaEnabled = true
+	firmwareDeltaMaxSize = envInt64("FIRMWARE_DELTA_MAX_SIZE", 256<<20)
+
+	interval, err := time.ParseDuration(os.Getenv("FIRMWARE_DELTA_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = time.Hour
+	}
+	go runFirmwareDeltaWorker(interval)
+}
+
+// triggerFirmwareDeltas 通知后台任务为产品生成差分，队列已满时等待下一次定期扫描
+func triggerFirmwareDeltas(repo *firmwareRepository, productName string) {
+	if !firmwareDeltaEnabled {
+		return
+	}
+	select {
+	case firmwareDeltaQueue <- firmwareDeltaJob{repo: repo, productName: productName}:
+	default:
+	}
+}
+
+func runFirmwareDeltaWorker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	scanAll := func() {
+		for _, repo := range firmwareRepositories() {
+			products, err := listFirmwareProducts(context.Background(), repo)
+			if err != nil {
+				log.Printf("列出仓库 %s 的固件产品失败: %v", repo.Name, err)
+				continue
+			}
+			for _, productName := range products {
+				if err := generateFirmwareDeltas(context.Background(), repo, productName); err != nil {
+					log.Printf("生成产品 %s 的固件差分失败: %v", productName, err)
+				}
+			}
+		}
+	}
+
+	scanAll()
+	for {
+		select {
+		case job := <-firmwareDeltaQueue:
+			if err := generateFirmwareDeltas(context.Background(), job.repo, job.productName); err != nil {
+				log.Printf("生成产品 %s 的固件差分失败: %v", job.productName, err)
+			}
+		case <-ticker.C:
+			scanAll()
+		}
+	}
+}
+
+func loadFirmwareDeltas(ctx context.Context, repo *firmwareRepository, productName string) ([]FirmwareDelta, error) {
+	deltas, _, err := loadFirmwareObject[[]FirmwareDelta](ctx, repo, getDeltaIndexKey(repo, productName))
+	return deltas, err
+}
+
+func updateFirmwareDeltas(ctx context.Context, repo *firmwareRepository, productName string, update func([]FirmwareDelta) ([]FirmwareDelta, error)) error {
+	return updateFirmwareObject(ctx, repo, getDeltaIndexKey(repo, productName), func(deltas []FirmwareDelta) ([]FirmwareDelta, error) {
+		if deltas == nil {
+			deltas = []FirmwareDelta{}
+		}
+		return update(deltas)
+	})
+}
+
+// 读取固件镜像，超过差分大小上限时返回错误
+func readFirmwareImage(ctx context.Context, repo *firmwareRepository, info FirmwareInfo) ([]byte, error) {
+	object, err := minioClient.GetObject(ctx, repo.Bucket, firmwareObjectKey(repo, info), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	data, err := io.ReadAll(io.LimitReader(object, firmwareDeltaMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > firmwareDeltaMaxSize {
+		return nil, fmt.Errorf("镜像超过差分大小上限 %d", firmwareDeltaMaxSize)
+	}
+	// 目录中记录了校验和时确认镜像未损坏
+	if info.SHA256 != "" {
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != info.SHA256 {
+			return nil, fmt.Errorf("镜像 %s 与记录的 SHA-256 不一致", firmwareObjectKey(repo, info))
+		}
+	}
+	return data, nil
+}
+
+// generateFirmwareDelta 生成 from 到 to 的差分并写入存储
+func generateFirmwareDelta(ctx context.Context, repo *firmwareRepository, from, to firmwareEntry) (*FirmwareDelta, error) {
+	source, err := readFirmwareImage(ctx, repo, from.Info)
+	if err != nil {
+		return nil, err
+	}
+	target, err := readFirmwareImage(ctx, repo, to.Info)
+	if err != nil {
+		return nil, err
+	}
+
+	patch := computeDelta(source, target)
+	// 上传前确认差分可以还原出目标镜像，不把错误的差分下发给设备
+	restored, err := applyDelta(source, bytes.NewReader(patch), firmwareDeltaMaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("校验差分失败: %v", err)
+	}
+	if !bytes.Equal(restored, target) {
+		return nil, fmt.Errorf("校验差分失败: %s 到 %s 的差分无法还原目标镜像", from.Desc.DisplayVersion(), to.Desc.DisplayVersion())
+	}
+	objectKey := firmwareDeltaKey(repo, to.Info.ProductName, from.Desc, to.Desc)
+	_, err = minioClient.PutObject(ctx, repo.Bucket, objectKey, bytes.NewReader(patch), int64(len(patch)), minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("保存差分失败: %v", err)
+	}
+
+	patchSum := sha256.Sum256(patch)
+	sourceSum := sha256.Sum256(source)
+	targetSum := sha256.Sum256(target)
+	return &FirmwareDelta{
+		FromID:       from.Info.ID,
+		ToID:         to.Info.ID,
+		FromVersion:  from.Desc.DisplayVersion(),
+		ToVersion:    to.Desc.DisplayVersion(),
+		ObjectKey:    objectKey,
+		Size:         int64(len(patch)),
+		SHA256:       hex.EncodeToString(patchSum[:]),
+		SourceSHA256: hex.EncodeToString(sourceSum[:]),
+		TargetSHA256: hex.EncodeToString(targetSum[:]),
+		CreatedAt:    firmwareTimestamp(),
+	}, nil
+}
+
+// generateFirmwareDeltas 为产品中同一版本类型的相邻版本生成缺失的差分，并清理已删除固件的差分
+func generateFirmwareDeltas(ctx context.Context, repo *firmwareRepository, productName string) error {
+	entries, err := listFirmwareEntries(repo, productName, firmwareQuery{})
+	if err != nil {
+		return err
+	}
+	deltas, err := loadFirmwareDeltas(ctx, repo, productName)
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]bool)
+	for _, delta := range deltas {
+		existing[delta.FromID+"/"+delta.ToID] = true
+	}
+
+	// 按版本类型找出相邻版本
+	previous := make(map[string]firmwareEntry)
+	var generated []FirmwareDelta
+	for _, entry := range entries {
+		if status := entry.Info.IntegrityStatus; status == integrityStatusMismatch || status == integrityStatusMissing {
+			continue
+		}
+		from, ok := previous[entry.Desc.Edition]
+		previous[entry.Desc.Edition] = entry
+		if !ok || existing[from.Info.ID+"/"+entry.Info.ID] {
+			continue
+		}
+		if from.Info.Size > firmwareDeltaMaxSize || entry.Info.Size > firmwareDeltaMaxSize {
+			continue
+		}
+
+		delta, err := generateFirmwareDelta(ctx, repo, from, entry)
+		if err != nil {
+			log.Printf("生成差分 %s -> %s 失败: %v", from.Desc.DisplayVersion(), entry.Desc.DisplayVersion(), err)
+			continue
+		}
+		log.Printf("已生成差分 %s (%d 字节)", delta.ObjectKey, delta.Size)
+		generated = append(generated, *delta)
+	}
+
+	// 目录中已不存在的固件对应的差分
+	ids := make(map[string]bool)
+	for _, entry := range entries {
+		ids[entry.Info.ID] = true
+	}
+	var stale []FirmwareDelta
+	for _, delta := range deltas {
+		if !ids[delta.FromID] || !ids[delta.ToID] {
+			stale = append(stale, delta)
+		}
+	}
+	if len(generated) == 0 && len(stale) == 0 {
+		return nil
+	}
+
+	err = updateFirmwareDeltas(ctx, repo, productName, func(deltas []FirmwareDelta) ([]FirmwareDelta, error) {
+		updated := []FirmwareDelta{}
+		seen := make(map[string]bool)
+		for _, delta := range deltas {
+			if ids[delta.FromID] && ids[delta.ToID] {
+				updated = append(updated, delta)
+				seen[delta.FromID+"/"+delta.ToID] = true
+			}
+		}
+		for _, delta := range generated {
+			if !seen[delta.FromID+"/"+delta.ToID] {
+				updated = append(updated, delta)
+			}
+		}
+		return updated, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, delta := range stale {
+		if err := minioClient.RemoveObject(ctx, repo.Bucket, delta.ObjectKey, minio.RemoveObjectOptions{}); err != nil {
+			log.Printf("删除差分 %s 失败: %v", delta.ObjectKey, err)
+		}
+	}
+	return nil
+}
+
+// planFirmwareUpdate 计算设备从当前版本升级的方案：
+// 有 DeviceID 时按发布策略确定目标版本，否则取通道中设备可以升级到达的最新版本；
+// 需要经过中间版本时本次只升级到路径上的第一个固件；
+// 存在从当前版本到目标版本的差分链且总大小小于完整镜像时返回差分，否则返回完整镜像
+func planFirmwareUpdate(ctx context.Context, repo *firmwareRepository, device firmwareDevice) (*FirmwareUpdatePlan, error) {
+	productName := device.ProductName
+	plan := &FirmwareUpdatePlan{
+		ProductName:    productName,
+		CurrentVersion: device.CurrentVersion,
+		Method:         updateMethodNone,
+	}
+
+	current, err := parseSemVer(device.CurrentVersion)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidCurrentVersion, err)
+	}
+
+	// 设备当前运行的固件，同一版本号有多个构建时取最新的
+	allEntries, err := listFirmwareEntries(repo, productName, firmwareQuery{Edition: device.Edition})
+	if err != nil {
+		return nil, err
+	}
+	var currentEntry *firmwareEntry
+	for i := range allEntries {
+		if allEntries[i].Version.Compare(current) == 0 {
+			currentEntry = &allEntries[i]
+		}
+	}
+	if device.Edition == "" && currentEntry != nil {
+		device.Edition = currentEntry.Desc.Edition
+	}
+
+	// 需要经过中间版本时目标为路径上的第一个固件
+	var target *FirmwareInfo
+	var upgradePath []string
+	if device.DeviceID != "" {
+		decision, err := resolveDeviceFirmware(ctx, repo, device)
+		if err != nil {
+			return nil, err
+		}
+		target = decision.Firmware
+		upgradePath = decision.UpgradePath
+	} else {
+		latest, err := getLatestFirmware(repo, productName, firmwareQuery{
+			Edition:          device.Edition,
+			Channel:          device.Channel,
+			HardwareRevision: device.HardwareRevision,
+		}, &current)
+		if err == nil {
+			target = latest.NextFirmware
+			if len(latest.UpgradePath) > 1 {
+				upgradePath = latest.UpgradePath
+			}
+		}
+	}
+	if target == nil {
+		return plan, nil
+	}
+
+	targetDesc, err := firmwareDescriptorFromInfo(*target)
+	if err != nil {
+		return nil, err
+	}
+	targetVersion, _ := targetDesc.SemVer()
+	if targetVersion.Compare(current) == 0 || (currentEntry != nil && currentEntry.Info.ID == target.ID) {
+		return plan, nil
+	}
+
+	plan.UpdateAvailable = true
+	plan.Target = target
+	plan.TargetVersion = targetDesc.DisplayVersion()
+	plan.UpgradePath = upgradePath
+
+	// 只有升级才有差分，回退到旧版本时下载完整镜像
+	if currentEntry != nil && targetVersion.Compare(current) > 0 {
+		deltas, err := loadFirmwareDeltas(ctx, repo, productName)
+		if err != nil {
+			log.Printf("加载产品 %s 的差分索引失败: %v", productName, err)
+		}
+		next := make(map[string]FirmwareDelta)
+		for _, delta := range deltas {
+			next[delta.FromID] = delta
+		}
+
+		var chain []FirmwareDelta
+		var chainSize int64
+		for id := currentEntry.Info.ID; id != target.ID && len(chain) < firmwareDeltaMaxChain; {
+			delta, ok := next[id]
+			if !ok {
+				chain = nil
+				break
+			}
+			chain = append(chain, delta)
+			chainSize += delta.Size
+			id = delta.ToID
+		}
+
+		if len(chain) > 0 && chain[len(chain)-1].ToID == target.ID && (target.Size == 0 || chainSize < target.Size) {
+			for _, delta := range chain {
+				deltaURL, err := presignFirmwareObject(ctx, repo, delta.ObjectKey)
+				if err != nil {
+					return nil, err
+				}
+				plan.Deltas = append(plan.Deltas, FirmwareDeltaLink{FirmwareDelta: delta, URL: deltaURL})
+			}
+			plan.Method = updateMethodDelta
+			plan.DownloadSize = chainSize
+			return plan, nil
+		}
+	}
+
+	fullURL, err := presignFirmwareObject(ctx, repo, firmwareObjectKey(repo, *target))
+	if err != nil {
+		return nil, err
+	}
+	plan.Method = updateMethodFull
+	plan.FullURL = fullURL
+	plan.DownloadSize = target.Size
+	return plan, nil
+}
+
+// getFirmwareUpdateHandler 设备上报当前版本，返回差分或完整镜像的下载方案
+func getFirmwareUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	type GetFirmwareUpdateRequest struct {
+		ProductName      string `json:"product_name"`
+		CurrentVersion   string `json:"current_version"`
+		Edition          string `json:"edition"`
+		Channel          string `json:"channel"`   // 默认 stable
+		DeviceID         string `json:"device_id"` // 可选，提供时按发布策略确定目标版本
+		HardwareRevision string `json:"hardware_revision"`
+	}
+
+	var request GetFirmwareUpdateRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.ProductName == "" || request.CurrentVersion == "" {
+		http.Error(w, "product_name and current_version are required", http.StatusBadRequest)
+		return
+	}
+
+	request.ProductName, err = resolveFirmwareProductName(r.Context(), repo, request.ProductName)
+	if err != nil {
+		http.Error(w, err.Error(), firmwareProductErrorStatus(err))
+		return
+	}
+	if request.Channel == "" {
+		request.Channel = firmwareChannelStable
+	}
+	channel, err := normalizeFirmwareChannel(request.Channel)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	device := firmwareDevice{
+		ProductName:      request.ProductName,
+		DeviceID:         request.DeviceID,
+		Channel:          channel,
+		Edition:          request.Edition,
+		CurrentVersion:   request.CurrentVersion,
+		HardwareRevision: request.HardwareRevision,
+	}
+	plan, err := planFirmwareUpdate(r.Context(), repo, device)
+	if err != nil {
+		if errors.Is(err, errInvalidCurrentVersion) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordFirmwareUpdateCheck(r, repo, device, plan)
+
+	jsonResponse, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// 固件描述：由产品、版本类型、版本号和构建日期唯一确定一个固件镜像
+// 标准文件名：{product}_[{edition}]_V{version}_{build_date}{file_type}，例如 NXT2204_[Std]_V1.0.5_20211011.img
+type FirmwareDescriptor struct {
+	ProductName string
+	Edition     string // 版本类型，例如 Std
+	Version     string // 版本号，例如 1.0.5
+	BuildDate   string // 构建日期，格式 YYYYMMDD
+	FileType    string // 文件类型，例如 .img、.swu，为空视为 .img
+}
+
+// 版本类型只允许字母和数字
+var firmwareEditionRegex = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
+// 固件文件名中产品名之后的部分
+var firmwareFileNameRegex = regexp.MustCompile(`^_\[([^\]]+)\]_[Vv]([^_]+)_(\d{8})(\..+)$`)
+
+// parseFirmwareFileName 按标准文件名解析固件描述
+func parseFirmwareFileName(productName, fileName string) (FirmwareDescriptor, error) {
+	if !strings.HasPrefix(fileName, productName) {
+		return FirmwareDescriptor{}, fmt.Errorf("firmware file name %q must start with product name %q", fileName, productName)
+	}
+	matches := firmwareFileNameRegex.FindStringSubmatch(strings.TrimPrefix(fileName, productName))
+	if matches == nil {
+		return FirmwareDescriptor{}, fmt.Errorf("firmware file name %q must match %s_[Edition]_V<version>_<YYYYMMDD>.<type>", fileName, productName)
+	}
+	if !containsString(firmwareFileTypes, matches[4]) {
+		return FirmwareDescriptor{}, fmt.Errorf("firmware file name %q has unsupported file type %q, must be one of %s", fileName, matches[4], strings.Join(firmwareFileTypes, ", "))
+	}
+
+	desc := FirmwareDescriptor{
+		ProductName: productName,
+		Edition:     matches[1],
+		Version:     matches[2],
+		BuildDate:   matches[3],
+		FileType:    matches[4],
+	}
+	if err := desc.Validate(); err != nil {
+		return FirmwareDescriptor{}, err
+	}
+	return desc, nil
+}
+
+// Validate 校验固件描述的各个字段
+func (d FirmwareDescriptor) Validate() error {
+	if d.ProductName == "" {
+		return fmt.Errorf("product name is required")
+	}
+	if !firmwareEditionRegex.MatchString(d.Edition) {
+		return fmt.Errorf("invalid firmware edition %q", d.Edition)
+	}
+	if _, err := parseSemVer(d.Version); err != nil {
+		return fmt.Errorf("invalid firmware version %q: %v", d.Version, err)
+	}
+	if _, err := time.Parse("20060102", d.BuildDate); err != nil {
+		return fmt.Errorf("invalid firmware build date %q", d.BuildDate)
+	}
+	if d.FileType != "" && !containsString(firmwareFileTypes, d.FileType) {
+		return fmt.Errorf("unsupported firmware file type %q", d.FileType)
+	}
+	return nil
+}
+
+// SemVer 解析固件的语义化版本号
+func (d FirmwareDescriptor) SemVer() (SemVer, error) {
+	return parseSemVer(d.Version)
+}
+
+// FileName 生成标准固件文件名
+func (d FirmwareDescriptor) FileName() string {
+	fileType := d.FileType
+	if fileType == "" {
+		fileType = firmwareDefaultFileType
+	}
+	return fmt.Sprintf("%s_[%s]_V%s_%s%s", d.ProductName, d.Edition, d.Version, d.BuildDate, fileType)
+}
+
+// ObjectKey 生成固件镜像在存储桶中的 key
+func (d FirmwareDescriptor) ObjectKey(repo *firmwareRepository) string {
+	return repo.key(d.ProductName, d.FileName())
+}
+
+// DisplayVersion 生成最新版本接口使用的版本描述，例如 [Std]_V1.0.5_20211011
+func (d FirmwareDescriptor) DisplayVersion() string {
+	return fmt.Sprintf("[%s]_V%s_%s", d.Edition, d.Version, d.BuildDate)
+}
+
+// checkFormFields 校验上传表单中填写的字段与文件名解析结果一致，未填写的字段不校验
+func (d FirmwareDescriptor) checkFormFields(version, edition, buildDate string) error {
+	if version != "" {
+		formVersion, err := parseSemVer(version)
+		if err != nil {
+			return fmt.Errorf("invalid version %q: %v", version, err)
+		}
+		fileVersion, _ := d.SemVer() // 文件名中的版本号已在解析时校验
+		if formVersion.String() != fileVersion.String() {
+			return fmt.Errorf("version %q does not match version %q in file name %s", version, d.Version, d.FileName())
+		}
+	}
+	if edition != "" && edition != d.Edition {
+		return fmt.Errorf("edition %q does not match edition %q in file name %s", edition, d.Edition, d.FileName())
+	}
+	if buildDate != "" && buildDate != d.BuildDate {
+		return fmt.Errorf("build date %q does not match build date %q in file name %s", buildDate, d.BuildDate, d.FileName())
+	}
+	return nil
+}
+
+// firmwareDescriptorFromInfo 从目录条目得到固件描述。
+// 早期条目没有 edition 和 build_date，尝试从 URL 中的文件名解析
+func firmwareDescriptorFromInfo(info FirmwareInfo) (FirmwareDescriptor, error) {
+	if info.Edition != "" {
+		desc := FirmwareDescriptor{
+			ProductName: info.ProductName,
+			Edition:     info.Edition,
+			Version:     info.Version,
+			BuildDate:   info.BuildDate,
+			FileType:    info.FileType,
+		}
+		return desc, desc.Validate()
+	}
+	return parseFirmwareFileName(info.ProductName, path.Base(info.URL))
+}
+
+// firmwareObjectKey 返回目录条目对应的固件镜像 key
+func firmwareObjectKey(repo *firmwareRepository, info FirmwareInfo) string {
+	if info.ObjectKey != "" {
+		return info.ObjectKey
+	}
+	if desc, err := firmwareDescriptorFromInfo(info); err == nil {
+		return desc.ObjectKey(repo)
+	}
+	// 早期条目记录的文件名
+	return repo.key(info.ProductName, fmt.Sprintf("%s_%s.img", info.ProductName, info.Version))
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minio/minio-go/v7"
+)
+
+// 固件下载接口的路径前缀，不经过压缩中间件
+const firmwareDownloadPathPrefix = "/firmware/download/"
+
+var (
+	// 路径中的版本号无法解析
+	errInvalidFirmwareVersion = errors.New("invalid firmware version")
+	// 同一版本号有多个版本类型而请求未指定时返回
+	errAmbiguousFirmware = errors.New("firmware version is ambiguous")
+)
+
+// 下载次数存放在产品目录下，按固件 ID 索引
+func getDownloadsObjectKey(repo *firmwareRepository, productName string) string {
+	return repo.key(productName, "downloads.json")
+}
+
+// 每个连接的下载限速（字节/秒），可通过 FIRMWARE_DOWNLOAD_RATE 配置，0 表示不限速
+func firmwareDownloadRate() int64 {
+	return envInt64("FIRMWARE_DOWNLOAD_RATE", 0)
+}
+
+// findFirmwareForDownload 按版本号查找固件：edition 为空时版本号必须只属于一个版本类型，
+// buildDate 为空时取最新的构建
+func findFirmwareForDownload(repo *firmwareRepository, productName, version, edition, buildDate string) (*firmwareEntry, error) {
+	target, err := parseSemVer(version)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidFirmwareVersion, err)
+	}
+	entries, err := listFirmwareEntries(repo, productName, firmwareQuery{Edition: edition})
+	if err != nil {
+		return nil, err
+	}
+
+	var found *firmwareEntry
+	for i := range entries {
+		entry := entries[i]
+		if entry.Version.Compare(target) != 0 || (buildDate != "" && entry.Desc.BuildDate != buildDate) {
+			continue
+		}
+		if found != nil && found.Desc.Edition != entry.Desc.Edition {
+			return nil, fmt.Errorf("%w: %s %s exists in editions %s and %s, edition is required", errAmbiguousFirmware, productName, version, found.Desc.Edition, entry.Desc.Edition)
+		}
+		// entries 按构建日期升序排列，后出现的是最新构建
+		found = &entry
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w: %s %s", errFirmwareNotFound, productName, version)
+	}
+	return found, nil
+}
+
+// firmwareDownloadWriter 统计发送的字节数，并按 rate 限制每个连接的发送速度
+type firmwareDownloadWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	rate    int64 // 字节/秒，0 表示不限速
+	start   time.Time
+	status  int
+	written int64
+}
+
+func (d *firmwareDownloadWriter) WriteHeader(statusCode int) {
+	d.status = statusCode
+	d.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (d *firmwareDownloadWriter) Write(p []byte) (int, error) {
+	if d.status == 0 {
+		d.status = http.StatusOK
+	}
+	if d.rate <= 0 {
+		n, err := d.ResponseWriter.Write(p)
+		d.written += int64(n)
+		return n, err
+	}
+
+	total := 0
+	for len(p) > 0 {
+		// 每次最多发送 0.1 秒的数据，使发送速度平稳
+		chunk := p
+		if limit := d.rate/10 + 1; int64(len(chunk)) > limit {
+			chunk = chunk[:limit]
+		}
+		n, err := d.ResponseWriter.Write(chunk)
+		total += n
+		d.written += int64(n)
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+
+		// 按已发送的字节数计算应该经过的时间，发送超前时等待
+		expected := time.Duration(float64(d.written) / float64(d.rate) * float64(time.Second))
+		if wait := expected - time.Since(d.start); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-d.ctx.Done():
+				timer.Stop()
+				return total, d.ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+	return total, nil
+}
+
+// completed 判断镜像是否已经发送到最后一个字节：完整下载，或续传的范围一直到文件末尾
+func (d *firmwareDownloadWriter) completed(size int64) bool {
+	switch d.status {
+	case http.StatusOK:
+		return d.written == size
+	case http.StatusPartialContent:
+		// 单个范围的格式为 bytes start-end/size，多个范围时不计数
+		var start, end, total int64
+		if _, err := fmt.Sscanf(d.Header().Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &total); err != nil {
+			return false
+		}
+		return end == size-1 && d.written == end-start+1
+	}
+	return false
+}
+
+// recordFirmwareDownload 记录一次完成的下载
+func recordFirmwareDownload(ctx context.Context, repo *firmwareRepository, productName string, info FirmwareInfo) error {
+	return updateFirmwareObject(ctx, repo, getDownloadsObjectKey(repo, productName), func(counts map[string]FirmwareDownloadCount) (map[string]FirmwareDownloadCount, error) {
+		if counts == nil {
+			counts = make(map[string]FirmwareDownloadCount)
+		}
+		count := counts[info.ID]
+		count.ID = info.ID
+		count.Version = info.Version
+		count.Edition = info.Edition
+		count.BuildDate = info.BuildDate
+		count.Downloads++
+		count.LastDownloadAt = firmwareTimestamp()
+		counts[info.ID] = count
+		return counts, nil
+	})
+}
+
+// listFirmwareDownloads 返回产品各固件的下载次数，按版本从新到旧排列；已删除的固件也会保留计数
+func listFirmwareDownloads(ctx context.Context, repo *firmwareRepository, productName string) ([]FirmwareDownloadCount, error) {
+	counts, _, err := loadFirmwareObject[map[string]FirmwareDownloadCount](ctx, repo, getDownloadsObjectKey(repo, productName))
+	if err != nil {
+		return nil, err
+	}
+	result := []FirmwareDownloadCount{}
+	for _, count := range counts {
+		result = append(result, count)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, errA := parseSemVer(result[i].Version)
+		b, errB := parseSemVer(result[j].Version)
+		if errA == nil && errB == nil && a.Compare(b) != 0 {
+			return a.Compare(b) > 0
+		}
+		if result[i].BuildDate != result[j].BuildDate {
+			return result[i].BuildDate > result[j].BuildDate
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result, nil
+}
+
+// downloadFirmwareHandler 按产品和版本号下载固件镜像：GET /firmware/download/{product}/{version}?edition=&build_date=
+// 支持 Range 断点续传，响应头带有镜像的 SHA-256，每个连接按 FIRMWARE_DOWNLOAD_RATE 限速；
+// 每次下载都写入访问记录，镜像完整发送后计入下载次数；device_id（或 X-Device-ID 请求头）和 user 参数用于审计
+func downloadFirmwareHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Range, If-Range")
+	w.Header().Set("Access-Control-Expose-Headers", "Content-Range, Content-Length, Digest, ETag, X-Checksum-SHA256")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	productName, err := resolveFirmwareProductName(r.Context(), repo, vars["product"])
+	if err != nil {
+		http.Error(w, err.Error(), firmwareProductErrorStatus(err))
+		return
+	}
+
+	query := r.URL.Query()
+	entry, err := findFirmwareForDownload(repo, productName, vars["version"], query.Get("edition"), query.Get("build_date"))
+	switch {
+	case errors.Is(err, errInvalidFirmwareVersion), errors.Is(err, errAmbiguousFirmware):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, errFirmwareNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// 校验失败的镜像不下发
+	if status := entry.Info.IntegrityStatus; status == integrityStatusMismatch || status == integrityStatusMissing {
+		http.Error(w, fmt.Sprintf("%v: firmware %s is %s", errFirmwareIntegrity, entry.Info.ID, status), http.StatusConflict)
+		return
+	}
+
+	object, err := minioClient.GetObject(r.Context(), repo.Bucket, firmwareObjectKey(repo, entry.Info), minio.GetObjectOptions{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer object.Close()
+	stat, err := object.Stat()
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			http.Error(w, fmt.Sprintf("%v: firmware image %s is missing", errFirmwareIntegrity, entry.Info.ID), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(entry.Desc.FileName()))
+	if digest, err := hex.DecodeString(entry.Info.SHA256); err == nil && len(digest) > 0 {
+		w.Header().Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(digest))
+		w.Header().Set("X-Checksum-SHA256", entry.Info.SHA256)
+		// If-Range 续传时用校验和判断镜像是否变化
+		w.Header().Set("ETag", strconv.Quote(entry.Info.SHA256))
+	}
+
+	writer := &firmwareDownloadWriter{
+		ResponseWriter: w,
+		ctx:            r.Context(),
+		rate:           firmwareDownloadRate(),
+		start:          time.Now(),
+	}
+	http.ServeContent(writer, r, entry.Desc.FileName(), stat.LastModified, object)
+
+	// 只记录实际发送了镜像内容的请求，不包括 HEAD、304 和 416
+	if r.Method != http.MethodGet || (writer.status != http.StatusOK && writer.status != http.StatusPartialContent) {
+		return
+	}
+	completed := writer.completed(stat.Size)
+	deviceID, user := firmwareAccessIdentity(r)
+	event := FirmwareAccessEvent{
+		Type:        firmwareAccessDownload,
+		Repository:  repo.Name,
+		ProductName: productName,
+		FirmwareID:  entry.Info.ID,
+		Version:     entry.Desc.Version,
+		Edition:     entry.Desc.Edition,
+		DeviceID:    deviceID,
+		User:        user,
+		RemoteAddr:  r.RemoteAddr,
+		BytesServed: writer.written,
+		Resumed:     r.Header.Get("Range") != "",
+		Result:      firmwareAccessAborted,
+	}
+	if completed {
+		event.Result = firmwareAccessComplete
+	}
+	recordFirmwareAccess(event)
+
+	if completed {
+		// 计数不影响下载结果，请求结束后再写入
+		go func(info FirmwareInfo) {
+			if err := recordFirmwareDownload(context.Background(), repo, productName, info); err != nil {
+				log.Printf("记录固件 %s 的下载次数失败: %v", info.ID, err)
+			}
+		}(entry.Info)
+	}
+}
+
+// getFirmwareDownloadsHandler 查询产品各固件的下载次数
+func getFirmwareDownloadsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	type GetFirmwareDownloadsRequest struct {
+		ProductName string `json:"product_name"`
+	}
+
+	var request GetFirmwareDownloadsRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	request.ProductName, err = resolveFirmwareProductName(r.Context(), repo, request.ProductName)
+	if err != nil {
+		http.Error(w, err.Error(), firmwareProductErrorStatus(err))
+		return
+	}
+
+	counts, err := listFirmwareDownloads(r.Context(), repo, request.ProductName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.MarshalIndent(counts, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResponse)
+}
+
+// 压缩中间件跳过固件下载，Range 响应不能再压缩
+func isFirmwareDownloadPath(path string) bool {
+	return strings.HasPrefix(path, firmwareDownloadPathPrefix)
+}
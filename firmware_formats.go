@@ -0,0 +1,187 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 支持的固件文件类型，产品未配置时只允许 .img
+const firmwareDefaultFileType = ".img"
+
+var firmwareFileTypes = []string{".img", ".bin", ".swu", ".tar.gz"}
+
+// 固件镜像内容与文件类型不符时返回
+var errInvalidFirmwareImage = errors.New("invalid firmware image")
+
+// 各文件类型的格式校验，上传时在写入存储桶之前执行
+var firmwareFormatValidators = map[string]func(r io.Reader) error{
+	".img":    validateImgFirmware,
+	".swu":    validateSWUpdateFirmware,
+	".tar.gz": validateTarGzFirmware,
+}
+
+// .img 镜像开头的魔数（十六进制），为空表示不检查
+var firmwareImgMagic []byte
+
+// 初始化固件格式校验：FIRMWARE_IMG_MAGIC 配置 .img 镜像开头的魔数（十六进制），未配置时不检查
+func initFirmwareFormats() {
+	if value := strings.TrimSpace(os.Getenv("FIRMWARE_IMG_MAGIC")); value != "" {
+		magic, err := hex.DecodeString(value)
+		if err != nil {
+			log.Printf("环境变量 FIRMWARE_IMG_MAGIC 格式错误，不检查 .img 魔数: %v", err)
+			return
+		}
+		firmwareImgMagic = magic
+	}
+}
+
+// firmwareFileTypeOf 返回文件名对应的固件文件类型，不支持的类型返回空字符串
+func firmwareFileTypeOf(fileName string) string {
+	lower := strings.ToLower(fileName)
+	for _, fileType := range firmwareFileTypes {
+		if strings.HasSuffix(lower, fileType) {
+			return fileType
+		}
+	}
+	return ""
+}
+
+// normalizeFirmwareFileTypes 统一为小写并补上开头的点，拒绝不支持的类型
+func normalizeFirmwareFileTypes(fileTypes []string) ([]string, error) {
+	var result []string
+	for _, fileType := range normalizeStringList(fileTypes) {
+		fileType = strings.ToLower(fileType)
+		if !strings.HasPrefix(fileType, ".") {
+			fileType = "." + fileType
+		}
+		if !containsString(firmwareFileTypes, fileType) {
+			return nil, fmt.Errorf("%w: unsupported firmware file type %q, must be one of %s", errInvalidProduct, fileType, strings.Join(firmwareFileTypes, ", "))
+		}
+		if !containsString(result, fileType) {
+			result = append(result, fileType)
+		}
+	}
+	return result, nil
+}
+
+// allowedFileTypes 返回产品允许上传的固件文件类型
+func (p FirmwareProduct) allowedFileTypes() []string {
+	if len(p.FileTypes) == 0 {
+		return []string{firmwareDefaultFileType}
+	}
+	return p.FileTypes
+}
+
+// checkFileType 检查产品是否允许该文件类型
+func (p FirmwareProduct) checkFileType(fileType string) error {
+	if fileType == "" {
+		fileType = firmwareDefaultFileType
+	}
+	allowed := p.allowedFileTypes()
+	if !containsString(allowed, fileType) {
+		return fmt.Errorf("%w: file type %s is not allowed for product %s, allowed: %s", errInvalidProduct, fileType, p.Name, strings.Join(allowed, ", "))
+	}
+	return nil
+}
+
+// validateFirmwareImage 按文件类型校验镜像内容，校验后把读取位置重置到开头
+func validateFirmwareImage(fileType string, r io.ReadSeeker, size int64) error {
+	if fileType == "" {
+		fileType = firmwareDefaultFileType
+	}
+	if size == 0 {
+		return fmt.Errorf("%w: image is empty", errInvalidFirmwareImage)
+	}
+	if validate := firmwareFormatValidators[fileType]; validate != nil {
+		if err := validate(r); err != nil {
+			return fmt.Errorf("%w: %s: %v", errInvalidFirmwareImage, fileType, err)
+		}
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("重置固件读取位置失败: %v", err)
+	}
+	return nil
+}
+
+// validateImgFirmware 配置了 FIRMWARE_IMG_MAGIC 时检查镜像开头的魔数
+func validateImgFirmware(r io.Reader) error {
+	if len(firmwareImgMagic) == 0 {
+		return nil
+	}
+	header := make([]byte, len(firmwareImgMagic))
+	if _, err := io.ReadFull(r, header); err != nil {
+		return fmt.Errorf("image is shorter than the %d byte header", len(firmwareImgMagic))
+	}
+	if !bytes.Equal(header, firmwareImgMagic) {
+		return fmt.Errorf("bad magic %x, expected %x", header, firmwareImgMagic)
+	}
+	return nil
+}
+
+// SWUpdate 镜像是 cpio newc 格式（070701，或带校验和的 070702），第一个文件必须是 sw-description
+const cpioNewcHeaderSize = 110
+
+func validateSWUpdateFirmware(r io.Reader) error {
+	header := make([]byte, cpioNewcHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return fmt.Errorf("image is shorter than a cpio header")
+	}
+	magic := string(header[:6])
+	if magic != "070701" && magic != "070702" {
+		return fmt.Errorf("bad cpio magic %q, expected 070701 or 070702 (newc)", magic)
+	}
+	nameSize, err := strconv.ParseUint(string(header[94:102]), 16, 32)
+	if err != nil || nameSize == 0 || nameSize > 4096 {
+		return fmt.Errorf("bad cpio file name size %q", header[94:102])
+	}
+	name := make([]byte, nameSize)
+	if _, err := io.ReadFull(r, name); err != nil {
+		return fmt.Errorf("truncated cpio header")
+	}
+	if first := string(bytes.TrimRight(name, "\x00")); first != "sw-description" {
+		return fmt.Errorf("first file in SWUpdate image is %q, expected sw-description", first)
+	}
+	return nil
+}
+
+// validateTarGzFirmware 完整读取 gzip 和 tar 流，检查压缩校验和与归档结构
+func validateTarGzFirmware(r io.Reader) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("not a gzip stream: %v", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	entries := 0
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("corrupt tar archive after %d entries: %v", entries, err)
+		}
+		if _, err := io.Copy(io.Discard, tr); err != nil {
+			return fmt.Errorf("corrupt tar entry %s: %v", header.Name, err)
+		}
+		entries++
+	}
+	if entries == 0 {
+		return fmt.Errorf("tar archive is empty")
+	}
+	// 读完 tar 结尾后的填充，gzip 在流结束时校验 CRC
+	if _, err := io.Copy(io.Discard, gz); err != nil {
+		return fmt.Errorf("corrupt gzip stream: %v", err)
+	}
+	return nil
+}
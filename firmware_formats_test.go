@@ -0,0 +1,115 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// newTestCpio 生成 cpio newc 格式的开头：文件头和以 NUL 结尾的文件名
+func newTestCpio(magic, name string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(magic)
+	for i := 0; i < 13; i++ {
+		value := 0
+		if i == 11 {
+			value = len(name) + 1 // namesize 包含结尾的 NUL
+		}
+		fmt.Fprintf(&buf, "%08X", value)
+	}
+	buf.WriteString(name)
+	buf.WriteByte(0)
+	buf.WriteString("software = { version = \"1.0.0\"; };")
+	return buf.Bytes()
+}
+
+func TestValidateSWUpdateFirmware(t *testing.T) {
+	valid := newTestCpio("070701", "sw-description")
+	tests := []struct {
+		name  string
+		image []byte
+		ok    bool
+	}{
+		{"newc", valid, true},
+		{"newc with checksum", newTestCpio("070702", "sw-description"), true},
+		{"truncated header", valid[:cpioNewcHeaderSize-1], false},
+		{"truncated file name", valid[:cpioNewcHeaderSize+5], false},
+		{"empty", nil, false},
+		{"odc magic", newTestCpio("070707", "sw-description"), false},
+		{"wrong magic", append([]byte("PK\x03\x04"), valid[4:]...), false},
+		{"first file is not sw-description", newTestCpio("070701", "rootfs.ext4"), false},
+		{"bad file name size", append(append(append([]byte(nil), valid[:94]...), "ZZZZZZZZ"...), valid[102:]...), false},
+	}
+	for _, tt := range tests {
+		err := validateSWUpdateFirmware(bytes.NewReader(tt.image))
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: accepted an invalid image", tt.name)
+		}
+	}
+}
+
+// newTestTarGz 生成包含 files 的 tar.gz，同时返回未压缩的 tar
+func newTestTarGz(t *testing.T, files map[string]string) (tarGz, plain []byte) {
+	t.Helper()
+	var tarBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var gzBuf bytes.Buffer
+	gz := gzip.NewWriter(&gzBuf)
+	gz.Write(tarBuf.Bytes())
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return gzBuf.Bytes(), tarBuf.Bytes()
+}
+
+func TestValidateTarGzFirmware(t *testing.T) {
+	valid, plain := newTestTarGz(t, map[string]string{"rootfs.img": strings.Repeat("firmware", 1000), "version": "1.0.0"})
+	empty, _ := newTestTarGz(t, nil)
+	badCRC := append([]byte(nil), valid...)
+	badCRC[len(badCRC)-8] ^= 0xff // gzip 结尾的 CRC32
+	var notTar bytes.Buffer
+	gz := gzip.NewWriter(&notTar)
+	gz.Write([]byte(strings.Repeat("not a tar archive ", 64)))
+	gz.Close()
+
+	tests := []struct {
+		name  string
+		image []byte
+		ok    bool
+	}{
+		{"valid", valid, true},
+		{"truncated", valid[:len(valid)/2], false},
+		{"missing trailer", valid[:len(valid)-4], false},
+		{"empty", nil, false},
+		{"uncompressed tar", plain, false},
+		{"wrong magic", append([]byte{0x1f, 0x8c}, valid[2:]...), false},
+		{"bad checksum", badCRC, false},
+		{"empty archive", empty, false},
+		{"gzip but not tar", notTar.Bytes(), false},
+	}
+	for _, tt := range tests {
+		err := validateTarGzFirmware(bytes.NewReader(tt.image))
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: accepted an invalid image", tt.name)
+		}
+	}
+}
@@ -3,10 +3,12 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
-	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -14,29 +16,18 @@ import (
 	"time"
 
 	"github.com/gabriel-vasile/mimetype"
+	"github.com/google/uuid"
 	"github.com/minio/minio-go/v7"
 )
 
 // 处理固件上传、删除、列表的代码...// 上传固件
 // getObjectKey 根据 ProductName 动态生成 objectKey
-func getObjectKey(productName string) string {
-	return fmt.Sprintf("firmware/%s/firmwareInfo.json", productName)
+func getObjectKey(repo *firmwareRepository, productName string) string {
+	return repo.key(productName, "firmwareInfo.json")
 }
 
-// appendFirmwareInfo 负责将新的 FirmwareInfo 写入 firmwareInfo.json 文件
-func appendFirmwareInfo(newInfo FirmwareInfo) error {
-	bucketName := "nxt-device"
-	ctx := context.Background()
-
-	// 检查文件是否存在
-	exists, err := minioClient.BucketExists(ctx, bucketName)
-	if err != nil {
-		return fmt.Errorf("检查桶是否存在失败: %v", err)
-	}
-	if !exists {
-		return fmt.Errorf("桶 %s 不存在", bucketName)
-	}
-
+// firmwareTimestamp 返回固件目录中使用的本地时间字符串
+func firmwareTimestamp() string {
 	currentTime := time.Now()
 
 	// 使用预加载的时区
@@ -46,104 +37,202 @@ func appendFirmwareInfo(newInfo FirmwareInfo) error {
 	}
 
 	localizedTime := currentTime.In(shanghaiLocation)
-	newInfo.UploadTime = localizedTime.Format("2006-01-02 15:04:05")
-	newInfo.ID = strconv.Itoa(int(time.Now().Unix()))
-	newInfo.URL = fmt.Sprintf("oss://%s/firmware/%s/%s_%s.img", bucketName, newInfo.ProductName, newInfo.ProductName, newInfo.Version)
-
-	// 根据 ProductName 动态生成 objectKey
-	objectKey := getObjectKey(newInfo.ProductName)
+	return localizedTime.Format("2006-01-02 15:04:05")
+}
 
-	objectExists, err := minioClient.StatObject(ctx, bucketName, objectKey, minio.StatObjectOptions{})
-	if err != nil {
-		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
-			// 文件不存在，创建一个新的 JSON 数组
-			var firmwareList []FirmwareInfo
-			firmwareList = append(firmwareList, newInfo)
+// appendFirmwareInfo 负责将新的 FirmwareInfo 写入 firmwareInfo.json 文件
+// force 为 false 时要求版本号高于同一版本类型的当前最新版本
+func appendFirmwareInfo(repo *firmwareRepository, newInfo FirmwareInfo, force bool) (*FirmwareInfo, error) {
+	ctx := context.Background()
 
-			data, err := json.MarshalIndent(firmwareList, "", "  ")
-			if err != nil {
-				return fmt.Errorf("JSON 编码失败: %v", err)
-			}
+	newInfo.UploadTime = firmwareTimestamp()
+	if newInfo.ID == "" {
+		newInfo.ID = uuid.NewString()
+	}
+	newInfo.URL = repo.objectURL(newInfo.ObjectKey)
 
-			// 上传新文件
-			_, err = minioClient.PutObject(ctx, bucketName, objectKey, strings.NewReader(string(data)), int64(len(data)), minio.PutObjectOptions{
-				ContentType: "application/json",
-			})
-			if err != nil {
-				return fmt.Errorf("上传新 JSON 文件失败: %v", err)
+	err := updateFirmwareCatalog(ctx, repo, newInfo.ProductName, func(firmwareList []FirmwareInfo) ([]FirmwareInfo, error) {
+		// 检查是否已存在相同的 product_name、edition、version 和 build_date。
+		// 同一版本可以有不同构建日期的镜像，它们的文件名不同，是不同的条目
+		for _, firmware := range firmwareList {
+			if firmware.ProductName == newInfo.ProductName && firmware.Edition == newInfo.Edition &&
+				firmware.Version == newInfo.Version && firmware.BuildDate == newInfo.BuildDate {
+				log.Printf("固件 %s [%s]_V%s_%s 已存在，未执行任何操作", newInfo.ProductName, newInfo.Edition, newInfo.Version, newInfo.BuildDate)
+				return nil, fmt.Errorf("%w: %s [%s]_V%s_%s", errFirmwareExists, newInfo.ProductName, newInfo.Edition, newInfo.Version, newInfo.BuildDate)
 			}
+		}
 
-			fmt.Println("firmwareInfo.json 文件已创建并添加了新的条目。")
-			return nil
+		// 在目录写入前再次检查版本，避免并发发布绕过校验
+		check := evaluateFirmwareVersion(firmwareList, newInfo.ProductName, newInfo.Edition, newInfo.Version, force)
+		if !check.Valid {
+			return nil, fmt.Errorf("%w: %s", errFirmwareVersionNotNewer, check.Message)
 		}
-		return fmt.Errorf("获取对象信息失败: %v", err)
+
+		// 如果不存在，则添加新的条目
+		return append(firmwareList, newInfo), nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	if objectExists.Key == "" {
-		// 文件不存在，创建新的 JSON 数组
-		var firmwareList []FirmwareInfo
-		firmwareList = append(firmwareList, newInfo)
+	log.Printf("%s 已更新并添加了新的条目", getObjectKey(repo, newInfo.ProductName))
+	return &newInfo, nil
+}
 
-		data, err := json.MarshalIndent(firmwareList, "", "  ")
-		if err != nil {
-			return fmt.Errorf("JSON 编码失败: %v", err)
-		}
+// 固件镜像已存在时由 publishFirmware 返回
+var errFirmwareExists = errors.New("firmware already exists")
 
-		// 上传新文件
-		_, err = minioClient.PutObject(ctx, bucketName, objectKey, strings.NewReader(string(data)), int64(len(data)), minio.PutObjectOptions{
-			ContentType: "application/json",
-		})
-		if err != nil {
-			return fmt.Errorf("上传新 JSON 文件失败: %v", err)
-		}
+// 固件目录中不存在指定的条目
+var errFirmwareNotFound = errors.New("firmware not found")
 
-		fmt.Println("firmwareInfo.json 文件已创建并添加了新的条目。")
-		return nil
+// 发布固件的选项
+type firmwarePublishOptions struct {
+	ContentType string
+	UploadUser  string
+	Channel     string // 发布通道，为空时发布到 beta
+	Force       bool   // 允许发布不高于当前最新版本的固件
+	Signature   []byte // 发布者对镜像 SHA-256 摘要的分离签名，可选
+	Release     FirmwareReleaseInfo
+}
+
+// publishFirmware 将固件镜像上传到由描述生成的标准路径，并登记到固件目录
+func publishFirmware(ctx context.Context, repo *firmwareRepository, desc FirmwareDescriptor, r io.ReadSeeker, size int64, opts firmwarePublishOptions) (*FirmwareInfo, error) {
+	objectKey := desc.ObjectKey(repo)
+
+	channel := opts.Channel
+	if channel == "" {
+		channel = firmwareChannelBeta
+	}
+	channel, err := normalizeFirmwareChannel(channel)
+	if err != nil {
+		return nil, err
+	}
+
+	// 检查文件是否已存在
+	_, err = minioClient.StatObject(ctx, repo.Bucket, objectKey, minio.StatObjectOptions{})
+	if err == nil {
+		return nil, errFirmwareExists
+	} else if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		return nil, fmt.Errorf("检查固件文件失败: %v", err)
+	}
+
+	// 目录中已有该文件名的条目时不覆盖（镜像丢失由一致性检查处理）
+	if findFirmwareByObjectKey(repo, desc.ProductName, objectKey) != nil {
+		return nil, errFirmwareExists
+	}
+
+	release := opts.Release
+	if err := release.normalize(desc.Version); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidReleaseInfo, err)
 	}
 
-	// 文件存在，下载并解析内容
-	object, err := minioClient.GetObject(ctx, bucketName, objectKey, minio.GetObjectOptions{})
+	// 上传前先检查版本号，避免上传注定被拒绝的镜像
+	check, err := checkFirmwareVersion(repo, desc.ProductName, desc.Edition, desc.Version, opts.Force)
 	if err != nil {
-		return fmt.Errorf("下载对象失败: %v", err)
+		return nil, err
+	}
+	if !check.Valid {
+		return nil, fmt.Errorf("%w: %s", errFirmwareVersionNotNewer, check.Message)
 	}
-	defer object.Close()
 
-	var firmwareList []FirmwareInfo
-	err = json.NewDecoder(object).Decode(&firmwareList)
+	// 按文件类型校验镜像格式，格式不符的镜像不写入存储桶
+	if err := validateFirmwareImage(desc.FileType, r, size); err != nil {
+		return nil, err
+	}
+
+	// 文件不存在，上传文件并计算校验和
+	checksums, err := putFirmwareImage(ctx, repo, objectKey, r, size, opts.ContentType)
 	if err != nil {
-		return fmt.Errorf("解析 JSON 文件失败: %v", err)
+		return nil, fmt.Errorf("上传固件文件失败: %v", err)
 	}
 
-	// 检查是否已存在相同的 product_name 和 version
-	for _, firmware := range firmwareList {
-		if firmware.ProductName == newInfo.ProductName && firmware.Version == newInfo.Version {
-			fmt.Println("相同的 product_name 和 version 已存在，未执行任何操作。")
-			return nil
-		}
+	// 校验发布者签名或由本地私钥签名
+	imageSignatures, err := signFirmwareImage(checksums, opts.Signature)
+	if err != nil {
+		removeFirmwareObjects(ctx, repo, objectKey)
+		return nil, err
 	}
 
-	// 如果不存在，则添加新的条目
-	firmwareList = append(firmwareList, newInfo)
+	newInfo := FirmwareInfo{
+		ID:          uuid.NewString(),
+		ProductName: desc.ProductName,
+		Version:     desc.Version,
+		Edition:     desc.Edition,
+		BuildDate:   desc.BuildDate,
+		FileType:    desc.FileType,
+		UploadUser:  opts.UploadUser,
+		ObjectKey:   objectKey,
+		Channel:     channel,
 
-	data, err := json.MarshalIndent(firmwareList, "", "  ")
+		FirmwareReleaseInfo: release,
+		FirmwareChecksums:   checksums,
+		IntegrityStatus:     integrityStatusOK,
+		LastVerifiedAt:      firmwareTimestamp(),
+	}
+	for _, sig := range imageSignatures {
+		newInfo.SignatureKeyIDs = append(newInfo.SignatureKeyIDs, sig.KeyID)
+	}
+
+	// 生成签名清单
+	newInfo.ManifestKey, err = writeFirmwareManifest(ctx, repo, objectKey, newFirmwareManifest(repo, newInfo, imageSignatures))
 	if err != nil {
-		return fmt.Errorf("JSON 编码失败: %v", err)
+		removeFirmwareObjects(ctx, repo, objectKey)
+		return nil, err
 	}
 
-	// 上传更新后的文件
-	_, err = minioClient.PutObject(ctx, bucketName, objectKey, strings.NewReader(string(data)), int64(len(data)), minio.PutObjectOptions{
-		ContentType: "application/json",
-	})
+	info, err := appendFirmwareInfo(repo, newInfo, opts.Force)
+	if errors.Is(err, errFirmwareExists) {
+		// 并发发布了同一文件：镜像和清单路径与已登记的条目相同，不能删除，恢复已登记条目的清单
+		if existing := findFirmwareByObjectKey(repo, desc.ProductName, objectKey); existing != nil {
+			if err := resignFirmwareManifest(ctx, repo, *existing); err != nil {
+				log.Printf("恢复固件 %s 的清单失败: %v", existing.ID, err)
+			}
+		}
+		return nil, err
+	}
 	if err != nil {
-		return fmt.Errorf("上传更新后的 JSON 文件失败: %v", err)
+		// 登记失败时删除已上传的镜像和清单
+		removeFirmwareObjects(ctx, repo, objectKey, newInfo.ManifestKey)
+		return nil, fmt.Errorf("登记固件信息失败: %w", err)
 	}
 
-	fmt.Println("firmwareInfo.json 文件已更新并添加了新的条目。")
+	// 为新版本生成差分
+	triggerFirmwareDeltas(repo, desc.ProductName)
+	return info, nil
+}
+
+// findFirmwareByObjectKey 返回目录中镜像 key 为 objectKey 的条目，不存在或读取失败时返回 nil
+func findFirmwareByObjectKey(repo *firmwareRepository, productName, objectKey string) *FirmwareInfo {
+	firmwareList, err := getFirmwareList(repo, productName)
+	if err != nil {
+		return nil
+	}
+	for i := range firmwareList {
+		if firmwareObjectKey(repo, firmwareList[i]) == objectKey {
+			return &firmwareList[i]
+		}
+	}
 	return nil
 }
 
+// 删除发布失败时已写入的对象
+func removeFirmwareObjects(ctx context.Context, repo *firmwareRepository, objectKeys ...string) {
+	for _, objectKey := range objectKeys {
+		if err := minioClient.RemoveObject(ctx, repo.Bucket, objectKey, minio.RemoveObjectOptions{}); err != nil {
+			log.Printf("删除固件文件 %s 失败: %v", objectKey, err)
+		}
+	}
+}
+
+// 读取表单中的第一个值，字段不存在时返回空字符串
+func firstFormValue(form *multipart.Form, key string) string {
+	if values := form.Value[key]; len(values) > 0 {
+		return strings.TrimSpace(values[0])
+	}
+	return ""
+}
+
 func uploadFirmwareHandler(w http.ResponseWriter, r *http.Request) {
-	bucketName := "nxt-device"
 	if r.Method == http.MethodOptions {
 		handlePreflight(w, r)
 		return
@@ -162,13 +251,16 @@ func uploadFirmwareHandler(w http.ResponseWriter, r *http.Request) {
 	// 	return
 	// }
 
-	w.WriteHeader(http.StatusOK)
-
 	if r.Method != http.MethodPost {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 		return
 	}
 
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
 	err := r.ParseMultipartForm(100 << 20) // 限制上传文件的大小为100MB
 	if err != nil {
 		http.Error(w, "Error parsing form data", http.StatusBadRequest)
@@ -176,16 +268,107 @@ func uploadFirmwareHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	files := r.MultipartForm.File["files"]
-	productName := r.MultipartForm.Value["product_name"]
-	version := r.MultipartForm.Value["version"]
-	upload_user := r.MultipartForm.Value["upload_user"]
+	productName := firstFormValue(r.MultipartForm, "product_name")
+	version := firstFormValue(r.MultipartForm, "version")
+	edition := firstFormValue(r.MultipartForm, "edition")
+	buildDate := firstFormValue(r.MultipartForm, "build_date")
+	uploadUser := firstFormValue(r.MultipartForm, "upload_user")
+	force, _ := strconv.ParseBool(firstFormValue(r.MultipartForm, "force"))
+	channel := firstFormValue(r.MultipartForm, "channel")
+	if channel == "" {
+		// 新上传的固件默认进入 beta 通道，提升后才对 stable 设备可见
+		channel = firmwareChannelBeta
+	}
+	channel, err = normalizeFirmwareChannel(channel)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if productName == "" {
+		http.Error(w, "product_name is required", http.StatusBadRequest)
+		return
+	}
+
+	// 只能向已注册且未归档的产品发布固件
+	product, err := writableFirmwareProduct(r.Context(), repo, productName)
+	if err != nil {
+		http.Error(w, err.Error(), firmwareProductErrorStatus(err))
+		return
+	}
+	productName = product.Name
+
+	release, err := firmwareReleaseInfoFromForm(r.MultipartForm)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 分离签名以 {镜像文件名}.sig 命名；只上传一个镜像时签名文件名不限
+	signatures := make(map[string][]byte)
+	for _, sigHeader := range r.MultipartForm.File["signatures"] {
+		sigFile, err := sigHeader.Open()
+		if err != nil {
+			http.Error(w, "Error opening signature file", http.StatusBadRequest)
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(sigFile, 64<<10))
+		sigFile.Close()
+		if err != nil {
+			http.Error(w, "Error reading signature file", http.StatusBadRequest)
+			return
+		}
+		imageName := strings.TrimSuffix(sigHeader.Filename, ".sig")
+		if len(files) == 1 {
+			imageName = files[0].Filename
+		}
+		signatures[imageName] = data
+	}
 
-	for _, fileHeader := range files {
+	// 先校验全部文件，避免部分上传
+	descriptors := make([]FirmwareDescriptor, len(files))
+	for i, fileHeader := range files {
 		if !isValidFirmwareType(fileHeader.Filename) {
-			http.Error(w, "Invalid file type. Only img files are allowed", http.StatusBadRequest)
+			http.Error(w, "Invalid file type. Only "+strings.Join(firmwareFileTypes, ", ")+" files are allowed", http.StatusBadRequest)
 			return
 		}
 
+		desc, err := parseFirmwareFileName(productName, fileHeader.Filename)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := desc.checkFormFields(version, edition, buildDate); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fileRelease := release
+		if err := fileRelease.normalize(desc.Version); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := product.checkRelease(desc.Edition, release.HardwareRevisions); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := product.checkFileType(desc.FileType); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		check, err := checkFirmwareVersion(repo, productName, desc.Edition, desc.Version, force)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !check.Valid {
+			http.Error(w, check.Message, http.StatusConflict)
+			return
+		}
+		descriptors[i] = desc
+	}
+
+	var warnings []string
+	for i, fileHeader := range files {
 		file, err := fileHeader.Open()
 		if err != nil {
 			http.Error(w, "Error opening file", http.StatusInternalServerError)
@@ -202,148 +385,112 @@ func uploadFirmwareHandler(w http.ResponseWriter, r *http.Request) {
 		// 重置文件读取位置
 		file.Seek(0, 0)
 
-		// 使用传递的路径或默认路径
-		filePath := fmt.Sprintf("firmware/%s/", productName[0])
-
-		// 检查文件是否已存在
-		_, err = minioClient.StatObject(context.Background(), bucketName, filePath+fileHeader.Filename, minio.StatObjectOptions{})
-		if err == nil {
+		info, err := publishFirmware(context.Background(), repo, descriptors[i], file, fileHeader.Size, firmwarePublishOptions{
+			ContentType: mime.String(),
+			UploadUser:  uploadUser,
+			Channel:     channel,
+			Force:       force,
+			Signature:   signatures[fileHeader.Filename],
+			Release:     release,
+		})
+		if errors.Is(err, errFirmwareExists) {
 			// 文件已存在，跳过上传
-			log.Printf("File %s already exists, skipping upload", filePath+fileHeader.Filename)
+			log.Printf("File %s already exists, skipping upload", descriptors[i].ObjectKey(repo))
 			continue
-		} else if err.(minio.ErrorResponse).Code != "NoSuchKey" {
-			// 其他错误，返回错误信息
-			http.Error(w, "Error checking file existence", http.StatusInternalServerError)
+		}
+		if errors.Is(err, errFirmwareVersionNotNewer) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if errors.Is(err, errFirmwareSignature) || errors.Is(err, errInvalidReleaseInfo) || errors.Is(err, errInvalidFirmwareImage) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-
-		fmt.Println("mime 123: ", mime.String())
-		// 文件不存在，上传文件
-		_, err = minioClient.PutObject(context.Background(), bucketName, filePath+fileHeader.Filename, file, fileHeader.Size, minio.PutObjectOptions{ContentType: mime.String()})
 		if err != nil {
-			http.Error(w, "Error uploading file", http.StatusInternalServerError)
+			log.Println(err)
+			http.Error(w, "Error uploading firmware", http.StatusInternalServerError)
 			return
 		}
 
-		var newInfo FirmwareInfo
-		newInfo.ProductName = productName[0]
-		newInfo.Version = version[0]
-		newInfo.UploadUser = upload_user[0]
-		if err = appendFirmwareInfo(newInfo); err != nil {
-			http.Error(w, "Error adding firmwareInfo", http.StatusInternalServerError)
-		}
+		// 兼容性约束导致旧版本无法升级到新固件时提示，但不阻止发布
+		warnings = append(warnings, logUpgradePathWarnings(repo, productName, *info)...)
 	}
 
+	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, "Files uploaded successfully\n")
+	for _, warning := range warnings {
+		fmt.Fprintf(w, "Warning: %s\n", warning)
+	}
 }
 
 // deleteFirmwareInfo 负责根据 id 和 productName 删除对应的 FirmwareInfo 对象
-// 同时删除对应的固件文件：FirmwareInfo.ProductName + "_" + FirmwareInfo.Version + ".img"
-func deleteFirmwareInfo(id string, productName string) error {
-	bucketName := "nxt-device"
+// 同时删除对应的固件文件，文件路径与上传时由固件描述生成的路径一致
+func deleteFirmwareInfo(repo *firmwareRepository, id string, productName string) error {
+	bucketName := repo.Bucket
 	ctx := context.Background()
 
-	// 检查存储桶是否存在
-	exists, err := minioClient.BucketExists(ctx, bucketName)
-	if err != nil {
-		return fmt.Errorf("检查桶是否存在失败: %v", err)
-	}
-	if !exists {
-		return fmt.Errorf("桶 %s 不存在", bucketName)
-	}
-
-	// 根据 ProductName 动态生成 objectKey
-	objectKey := getObjectKey(productName)
-
-	// 检查 firmwareInfo.json 文件是否存在
-	_, err = minioClient.StatObject(ctx, bucketName, objectKey, minio.StatObjectOptions{})
-	if err != nil {
-		// 如果文件不存在，则不处理
-		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
-			fmt.Println("firmwareInfo.json 文件不存在，无需删除。")
-			return nil
+	var firmwareToDelete *FirmwareInfo // 用于存储要删除的 FirmwareInfo
+	err := updateFirmwareCatalog(ctx, repo, productName, func(firmwareList []FirmwareInfo) ([]FirmwareInfo, error) {
+		// 找到并删除对应的 FirmwareInfo 对象
+		firmwareToDelete = nil
+		updatedList := []FirmwareInfo{}
+		for _, firmware := range firmwareList {
+			if firmware.ID == id && firmware.ProductName == productName {
+				// 存储要删除的 FirmwareInfo
+				firmwareCopy := firmware // 创建副本以避免引用问题
+				firmwareToDelete = &firmwareCopy
+				continue // 跳过要删除的条目
+			}
+			updatedList = append(updatedList, firmware)
 		}
-		return fmt.Errorf("获取对象信息失败: %v", err)
-	}
 
-	// 文件存在，下载并解析内容
-	object, err := minioClient.GetObject(ctx, bucketName, objectKey, minio.GetObjectOptions{})
-	if err != nil {
-		return fmt.Errorf("下载对象失败: %v", err)
-	}
-	defer object.Close()
+		if firmwareToDelete == nil {
+			fmt.Printf("未找到 ID 为 %s 且 ProductName 为 %s 的 FirmwareInfo 对象。\n", id, productName)
+			return nil, errCatalogUnchanged
+		}
 
-	var firmwareList []FirmwareInfo
-	err = json.NewDecoder(object).Decode(&firmwareList)
+		// 删除后列表为空时保留空数组，避免删除后重建文件与并发写入冲突
+		return updatedList, nil
+	})
 	if err != nil {
-		return fmt.Errorf("解析 JSON 文件失败: %v", err)
+		return err
 	}
-
-	// 找到并删除对应的 FirmwareInfo 对象
-	updatedList := []FirmwareInfo{}
-	var firmwareToDelete *FirmwareInfo // 用于存储要删除的 FirmwareInfo
-	found := false
-	for _, firmware := range firmwareList {
-		if firmware.ID == id && firmware.ProductName == productName {
-			found = true
-			// 存储要删除的 FirmwareInfo
-			firmwareCopy := firmware // 创建副本以避免引用问题
-			firmwareToDelete = &firmwareCopy
-			continue // 跳过要删除的条目
-		}
-		updatedList = append(updatedList, firmware)
+	if firmwareToDelete == nil {
+		return nil
 	}
+	fmt.Println("firmwareInfo.json 文件已更新并删除了指定的条目。")
 
-	if !found {
-		fmt.Printf("未找到 ID 为 %s 且 ProductName 为 %s 的 FirmwareInfo 对象。\n", id, productName)
-		return nil
+	// 删除对应的发布策略
+	if err := removeRolloutPolicy(ctx, repo, productName, id); err != nil {
+		log.Printf("删除固件 %s 的发布策略失败: %v", id, err)
 	}
 
-	// 如果删除后列表为空，可以选择删除文件或保留空数组
-	// 这里选择更新文件为新的空数组
-	if len(updatedList) == 0 {
-		// 删除 firmwareInfo.json 文件
-		err = minioClient.RemoveObject(ctx, bucketName, objectKey, minio.RemoveObjectOptions{})
-		if err != nil {
-			return fmt.Errorf("删除 firmwareInfo.json 文件失败: %v", err)
-		}
-		fmt.Println("firmwareInfo.json 文件已删除，因为其中不再包含任何条目。")
-	} else {
-		// 否则，上传更新后的列表
-		data, err := json.MarshalIndent(updatedList, "", "  ")
-		if err != nil {
-			return fmt.Errorf("JSON 编码失败: %v", err)
-		}
+	// 清理相关差分，并为新的相邻版本生成差分
+	triggerFirmwareDeltas(repo, productName)
 
-		// 上传更新后的文件
-		_, err = minioClient.PutObject(ctx, bucketName, objectKey, strings.NewReader(string(data)), int64(len(data)), minio.PutObjectOptions{
-			ContentType: "application/json",
-		})
-		if err != nil {
-			return fmt.Errorf("上传更新后的 JSON 文件失败: %v", err)
-		}
+	// 删除对应的固件文件
+	imgObjectKey := firmwareObjectKey(repo, *firmwareToDelete)
 
-		fmt.Println("firmwareInfo.json 文件已更新并删除了指定的条目。")
+	// 删除签名清单
+	manifestKey := firmwareToDelete.ManifestKey
+	if manifestKey == "" {
+		manifestKey = firmwareManifestKey(imgObjectKey)
+	}
+	if err := minioClient.RemoveObject(ctx, bucketName, manifestKey, minio.RemoveObjectOptions{}); err != nil && minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		log.Printf("删除签名清单 %s 失败: %v", manifestKey, err)
 	}
 
-	// 删除对应的固件文件
-	if firmwareToDelete != nil {
-		// 构造固件文件的 objectKey
-		imgFileName := fmt.Sprintf("%s_%s.img", firmwareToDelete.ProductName, firmwareToDelete.Version)
-		imgObjectKey := fmt.Sprintf("firmware/%s/%s", firmwareToDelete.ProductName, imgFileName)
-
-		// 删除固件文件
-		err = minioClient.RemoveObject(ctx, bucketName, imgObjectKey, minio.RemoveObjectOptions{})
-		if err != nil {
-			// 如果固件文件不存在，则仅输出日志，不返回错误
-			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
-				fmt.Printf("对应的固件文件 %s 不存在，已删除 JSON 条目。\n", imgObjectKey)
-			} else {
-				return fmt.Errorf("删除固件文件 %s 失败: %v", imgObjectKey, err)
-			}
+	// 删除固件文件
+	err = minioClient.RemoveObject(ctx, bucketName, imgObjectKey, minio.RemoveObjectOptions{})
+	if err != nil {
+		// 如果固件文件不存在，则仅输出日志，不返回错误
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			fmt.Printf("对应的固件文件 %s 不存在，已删除 JSON 条目。\n", imgObjectKey)
 		} else {
-			fmt.Printf("已删除固件文件: %s\n", imgObjectKey)
+			return fmt.Errorf("删除固件文件 %s 失败: %v", imgObjectKey, err)
 		}
+	} else {
+		fmt.Printf("已删除固件文件: %s\n", imgObjectKey)
 	}
 
 	return nil
@@ -369,13 +516,16 @@ func deleteFirmwareHandler(w http.ResponseWriter, r *http.Request) {
 	// 	return
 	// }
 
-	w.WriteHeader(http.StatusOK)
-
 	if r.Method != http.MethodDelete {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 		return
 	}
 
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
 	// 解析 JSON 请求体
 	type DeleteFirmwareRequest struct {
 		Id          string `json:"id"`
@@ -390,59 +540,28 @@ func deleteFirmwareHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = deleteFirmwareInfo(request.Id, request.ProductName)
+	request.ProductName, err = resolveFirmwareProductName(r.Context(), repo, request.ProductName)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), firmwareProductErrorStatus(err))
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
-}
-
-// getFirmwareList 查询固件信息的函数
-func getFirmwareList(productName string) ([]FirmwareInfo, error) {
-	bucketName := "nxt-device"
-	ctx := context.Background()
-
-	// 构造 objectKey
-	objectKey := getObjectKey(productName)
-
-	// 检查 firmwareInfo.json 文件是否存在
-	_, err := minioClient.StatObject(ctx, bucketName, objectKey, minio.StatObjectOptions{})
-	if err != nil {
-		// 如果文件不存在，返回 nil
-		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
-			return []FirmwareInfo{}, nil
-		}
-		// 其他错误
-		return nil, fmt.Errorf("获取对象信息失败: %v", err)
-	}
-
-	// 文件存在，下载并解析内容
-	object, err := minioClient.GetObject(ctx, bucketName, objectKey, minio.GetObjectOptions{})
+	err = deleteFirmwareInfo(repo, request.Id, request.ProductName)
 	if err != nil {
-		return nil, fmt.Errorf("下载对象失败: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	defer object.Close()
 
-	var firmwareList []FirmwareInfo
-	decoder := json.NewDecoder(object)
-	decoder.DisallowUnknownFields() // 可选：防止存在未知字段时解析失败
+	// 校验和删除都成功后再写状态码，产品不存在等错误才能返回对应的状态码
+	w.WriteHeader(http.StatusOK)
+}
 
-	err = decoder.Decode(&firmwareList)
+// getFirmwareList 查询固件信息的函数
+func getFirmwareList(repo *firmwareRepository, productName string) ([]FirmwareInfo, error) {
+	firmwareList, _, err := loadFirmwareCatalog(context.Background(), repo, productName)
 	if err != nil {
-		// 如果 JSON 内容为空，返回一个空的切片
-		if err == fmt.Errorf("EOF") {
-			return []FirmwareInfo{}, nil
-		}
-		return nil, fmt.Errorf("解析 JSON 文件失败: %v", err)
+		return nil, err
 	}
-
-	// 确保返回的切片不为 nil
-	if firmwareList == nil {
-		firmwareList = []FirmwareInfo{}
-	}
-
 	return firmwareList, nil
 }
 
@@ -465,15 +584,20 @@ func getFirmwareListHandler(w http.ResponseWriter, r *http.Request) {
 	// 	return
 	// }
 
-	w.WriteHeader(http.StatusOK)
-
 	if r.Method != http.MethodPost {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 		return
 	}
 
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
 	type GetFirmwareListRequest struct {
-		ProductName string `json:"product_name"`
+		ProductName       string `json:"product_name"`
+		VersionConstraint string `json:"version_constraint"` // 版本约束，例如 ">=1.2.0 <2.0.0"
+		Channel           string `json:"channel"`            // 可选，只返回该通道可见的固件
 	}
 
 	var request GetFirmwareListRequest
@@ -483,13 +607,49 @@ func getFirmwareListHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	firmwareList, err := getFirmwareList(request.ProductName)
+	request.ProductName, err = resolveFirmwareProductName(r.Context(), repo, request.ProductName)
+	if err != nil {
+		http.Error(w, err.Error(), firmwareProductErrorStatus(err))
+		return
+	}
+
+	constraint, err := parseVersionConstraint(request.VersionConstraint)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	channel := ""
+	if request.Channel != "" {
+		channel, err = normalizeFirmwareChannel(request.Channel)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	firmwareList, err := getFirmwareList(repo, request.ProductName)
 
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// 按版本约束和通道过滤，保持目录中的原有顺序
+	if request.VersionConstraint != "" || channel != "" {
+		matched := make(map[string]bool)
+		for _, entry := range firmwareEntriesFromList(firmwareList, firmwareQuery{Channel: channel, Constraint: constraint}) {
+			matched[entry.Info.ID] = true
+		}
+		filtered := []FirmwareInfo{}
+		for _, firmware := range firmwareList {
+			if matched[firmware.ID] {
+				filtered = append(filtered, firmware)
+			}
+		}
+		firmwareList = filtered
+	}
+
 	// 将树形结构转换为JSON格式
 	jsonFirmwareList, err := json.MarshalIndent(firmwareList, "", "  ")
 	if err != nil {
@@ -499,101 +659,42 @@ func getFirmwareListHandler(w http.ResponseWriter, r *http.Request) {
 
 	// 发送JSON响应
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	w.Write(jsonFirmwareList)
 }
 
-func getLatestFirmware(productName string) (*LatestFirmware, error) {
-	bucketName := "nxt-device"
-	ctx := context.Background()
-
-	// 构造文件夹前缀
-	prefix := fmt.Sprintf("firmware/%s/", productName)
-
-	// 列出所有对象，以 prefix 为前缀
-	objectsCh := minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
-		Prefix:    prefix,
-		Recursive: false, // 仅列出该目录下的对象，不递归子目录
-	})
-
-	type FirmwareFile struct {
-		Version   string
-		Date      string
-		FullName  string
-		ObjectKey string
+// getLatestFirmware 根据固件目录计算产品中满足查询条件的最新固件，与上传、删除使用同一套固件描述；
+// current 不为空时同时计算从该版本出发的升级路径
+func getLatestFirmware(repo *firmwareRepository, productName string, query firmwareQuery, current *SemVer) (*LatestFirmware, error) {
+	entries, err := listFirmwareEntries(repo, productName, query)
+	if err != nil {
+		return nil, err
 	}
 
-	var firmwareFiles []FirmwareFile
-
-	// 正则表达式匹配文件名
-	// 示例文件名：NXT2204_[Std]_V1.0.5_20211011.img
-	regex := regexp.MustCompile(`^` + regexp.QuoteMeta(productName) + `_[^\_]+_V(\d+\.\d+\.\d+)_(\d{8})\.img$`)
-
-	for object := range objectsCh {
-		if object.Err != nil {
-			return nil, fmt.Errorf("列出对象时出错: %v", object.Err)
-		}
-
-		// 只处理 .img 文件
-		if !strings.HasSuffix(object.Key, ".img") {
-			continue
-		}
-
-		// 提取文件名
-		fileName := strings.TrimPrefix(object.Key, prefix)
-
-		// 使用正则表达式解析文件名
-		matches := regex.FindStringSubmatch(fileName)
-		if len(matches) != 3 {
-			// 文件名不符合预期格式，跳过
-			fmt.Printf("跳过不符合格式的文件名: %s\n", fileName)
-			continue
+	if len(entries) == 0 {
+		if query.Constraint.String() != "" {
+			return nil, fmt.Errorf("未找到产品 %s 满足 %s 的固件", productName, query.Constraint)
 		}
-
-		version := matches[1] // e.g., "1.0.5"
-		date := matches[2]    // e.g., "20211011"
-
-		firmwareFiles = append(firmwareFiles, FirmwareFile{
-			Version:   version,
-			Date:      date,
-			FullName:  fileName,
-			ObjectKey: object.Key,
-		})
-	}
-
-	if len(firmwareFiles) == 0 {
-		return nil, fmt.Errorf("未找到产品 %s 的任何 .img 文件", productName)
+		return nil, fmt.Errorf("未找到产品 %s 的任何固件", productName)
 	}
 
-	// 排序固件文件，版本号升序，日期升序
-	sort.Slice(firmwareFiles, func(i, j int) bool {
-		// 比较版本号
-		vi, _ := parseVersion(firmwareFiles[i].Version)
-		vj, _ := parseVersion(firmwareFiles[j].Version)
-
-		if vi[0] != vj[0] {
-			return vi[0] < vj[0]
-		}
-		if vi[1] != vj[1] {
-			return vi[1] < vj[1]
-		}
-		if vi[2] != vj[2] {
-			return vi[2] < vj[2]
-		}
-
-		// 版本号相同，比较日期
-		return firmwareFiles[i].Date < firmwareFiles[j].Date
-	})
-
 	// 最新的固件在最后
-	latest := firmwareFiles[len(firmwareFiles)-1]
-
-	// 构造 newest_version 字段
-	newestVersion := fmt.Sprintf("[Std]_V%s_%s", latest.Version, latest.Date)
+	latest := entries[len(entries)-1]
 
-	return &LatestFirmware{
+	result := &LatestFirmware{
 		ProductName:   productName,
-		NewestVersion: newestVersion,
-	}, nil
+		NewestVersion: latest.Desc.DisplayVersion(),
+		Channel:       query.Channel,
+		Firmware:      &latest.Info,
+	}
+	if current != nil {
+		if path := latestUpgradePath(entries, *current); len(path) > 0 {
+			next := path[0].Info
+			result.NextFirmware = &next
+			result.UpgradePath = upgradePathVersions(path)
+		}
+	}
+	return result, nil
 }
 
 // getLatestFirmwaresHandler 处理获取多个产品最新固件版本的 HTTP 请求
@@ -616,9 +717,19 @@ func getLatestFirmwaresHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
 	// 定义请求体结构
 	type GetLatestFirmwaresRequest struct {
-		ProductNameList []string `json:"product_name_list"`
+		ProductNameList   []string `json:"product_name_list"`  // 为 ["*"] 时查询所有未归档的产品
+		AllProducts       bool     `json:"all_products"`       // 查询所有未归档的产品，与 ["*"] 相同
+		VersionConstraint string   `json:"version_constraint"` // 可选，只在满足约束的版本中查找最新版本
+		Channels          []string `json:"channels"`           // 可选，按通道分别返回最新版本，默认只查 stable
+		CurrentVersion    string   `json:"current_version"`    // 可选，提供时返回从该版本出发的升级路径
+		HardwareRevision  string   `json:"hardware_revision"`  // 可选，只返回该硬件版本支持的固件
 	}
 
 	// 定义响应体结构
@@ -635,41 +746,115 @@ func getLatestFirmwaresHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 验证 ProductNameList 是否为空
-	if len(request.ProductNameList) == 0 {
+	products, err := loadFirmwareProducts(r.Context(), repo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// 查询所有产品时按名称排序
+	if request.AllProducts || (len(request.ProductNameList) == 1 && request.ProductNameList[0] == "*") {
+		request.ProductNameList = nil
+		for _, product := range products {
+			if !product.Archived {
+				request.ProductNameList = append(request.ProductNameList, product.Name)
+			}
+		}
+		sort.Strings(request.ProductNameList)
+	} else if len(request.ProductNameList) == 0 {
+		// 验证 ProductNameList 是否为空
 		http.Error(w, "ProductNameList is empty", http.StatusBadRequest)
 		return
 	}
 
+	constraint, err := parseVersionConstraint(request.VersionConstraint)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var current *SemVer
+	if request.CurrentVersion != "" {
+		version, err := parseSemVer(request.CurrentVersion)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		current = &version
+	}
+
+	channels := request.Channels
+	if len(channels) == 0 {
+		channels = []string{firmwareChannelStable}
+	}
+	for i, channel := range channels {
+		channels[i], err = normalizeFirmwareChannel(channel)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	// 初始化响应数据
 	response := GetLatestFirmwaresResponse{
 		LatestFirmwares: []LatestFirmware{},
 		Errors:          make(map[string]string),
 	}
 
-	// 使用 WaitGroup 和 Mutex 实现并发查询和数据安全
+	// 先解析产品名称再启动查询：未注册的产品记录错误，改名前的名称按新名称查询
+	resolvedNames := make([]string, len(request.ProductNameList))
+	for i, productName := range request.ProductNameList {
+		registered := findFirmwareProduct(products, productName)
+		if registered < 0 {
+			response.Errors[productName] = fmt.Sprintf("%v: %s", errProductNotFound, productName)
+			continue
+		}
+		resolvedNames[i] = products[registered].Name
+	}
+
+	// 使用 WaitGroup 和 Mutex 实现并发查询和数据安全；
+	// 结果按请求中的产品、通道顺序排列
 	var wg sync.WaitGroup
 	var mu sync.Mutex
+	results := make([]*LatestFirmware, len(request.ProductNameList)*len(channels))
 
-	for _, productName := range request.ProductNameList {
-		wg.Add(1)
-		go func(pName string) {
-			defer wg.Done()
-			latest, err := getLatestFirmware(pName)
-			mu.Lock()
-			defer mu.Unlock()
-			if err != nil {
-				// 记录错误信息
-				response.Errors[pName] = err.Error()
-			} else {
-				// 添加到响应列表
-				response.LatestFirmwares = append(response.LatestFirmwares, *latest)
-			}
-		}(productName)
+	for i, productName := range resolvedNames {
+		if productName == "" {
+			continue
+		}
+		for j, channel := range channels {
+			wg.Add(1)
+			go func(slot int, pName, channel string) {
+				defer wg.Done()
+				latest, err := getLatestFirmware(repo, pName, firmwareQuery{
+					Channel:          channel,
+					Constraint:       constraint,
+					HardwareRevision: request.HardwareRevision,
+				}, current)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					// 记录错误信息，查询多个通道时以 产品/通道 区分
+					errorKey := pName
+					if len(channels) > 1 {
+						errorKey = pName + "/" + channel
+					}
+					response.Errors[errorKey] = err.Error()
+				} else {
+					results[slot] = latest
+				}
+			}(i*len(channels)+j, productName, channel)
+		}
 	}
 
 	// 等待所有 goroutine 完成
 	wg.Wait()
+	for _, latest := range results {
+		if latest != nil {
+			// 添加到响应列表
+			response.LatestFirmwares = append(response.LatestFirmwares, *latest)
+		}
+	}
 
 	// // 检查是否有错误
 	// if len(response.Errors) > 0 {
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+)
+
+// 固件镜像对象上记录校验和的元数据键
+const (
+	firmwareSha256MetadataKey = "Firmware-Sha256"
+	firmwareMd5MetadataKey    = "Firmware-Md5"
+	firmwareCrc32MetadataKey  = "Firmware-Crc32"
+)
+
+// 固件镜像上传时的暂存前缀，校验和计算完成后复制到正式路径
+func firmwareStagingPrefix(repo *firmwareRepository) string {
+	return repo.key(".staging") + "/"
+}
+
+// 镜像校验状态
+const (
+	integrityStatusOK       = "ok"
+	integrityStatusMismatch = "mismatch"
+	integrityStatusMissing  = "missing"
+	integrityStatusError    = "error"
+)
+
+// 镜像缺失或内容与记录的校验和不一致时返回
+var errFirmwareIntegrity = errors.New("firmware integrity check failed")
+
+// firmwareHasher 在一次读取中同时计算 SHA-256、MD5、CRC32 和大小
+type firmwareHasher struct {
+	sha256 hash.Hash
+	md5    hash.Hash
+	crc32  hash.Hash32
+	size   int64
+}
+
+func newFirmwareHasher() *firmwareHasher {
+	return &firmwareHasher{
+		sha256: sha256.New(),
+		md5:    md5.New(),
+		crc32:  crc32.NewIEEE(),
+	}
+}
+
+func (h *firmwareHasher) Write(p []byte) (int, error) {
+	h.sha256.Write(p)
+	h.md5.Write(p)
+	h.crc32.Write(p)
+	h.size += int64(len(p))
+	return len(p), nil
+}
+
+func (h *firmwareHasher) Checksums() FirmwareChecksums {
+	return FirmwareChecksums{
+		Size:   h.size,
+		SHA256: hex.EncodeToString(h.sha256.Sum(nil)),
+		MD5:    hex.EncodeToString(h.md5.Sum(nil)),
+		CRC32:  fmt.Sprintf("%08x", h.crc32.Sum32()),
+	}
+}
+
+// 校验和写入对象元数据
+func (c FirmwareChecksums) metadata() map[string]string {
+	return map[string]string{
+		firmwareSha256MetadataKey: c.SHA256,
+		firmwareMd5MetadataKey:    c.MD5,
+		firmwareCrc32MetadataKey:  c.CRC32,
+	}
+}
+
+// putFirmwareImage 上传固件镜像：先流式写入暂存对象并计算校验和，
+// 再服务端复制到正式路径并把校验和写入对象元数据，避免正式路径上出现没有校验和的镜像
+func putFirmwareImage(ctx context.Context, repo *firmwareRepository, objectKey string, r io.Reader, size int64, contentType string) (FirmwareChecksums, error) {
+	stagingKey := firmwareStagingPrefix(repo) + uuid.NewString()
+
+	hasher := newFirmwareHasher()
+	_, err := minioClient.PutObject(ctx, repo.Bucket, stagingKey, io.TeeReader(r, hasher), size, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	if err != nil {
+		return FirmwareChecksums{}, err
+	}
+	defer minioClient.RemoveObject(ctx, repo.Bucket, stagingKey, minio.RemoveObjectOptions{})
+
+	checksums := hasher.Checksums()
+	_, err = minioClient.CopyObject(ctx, minio.CopyDestOptions{
+		Bucket:          repo.Bucket,
+		Object:          objectKey,
+		ReplaceMetadata: true,
+		UserMetadata:    checksums.metadata(),
+	}, minio.CopySrcOptions{
+		Bucket: repo.Bucket,
+		Object: stagingKey,
+	})
+	if err != nil {
+		return FirmwareChecksums{}, err
+	}
+	return checksums, nil
+}
+
+// hashFirmwareImage 读取存储中的固件镜像并重新计算校验和
+func hashFirmwareImage(ctx context.Context, repo *firmwareRepository, objectKey string) (FirmwareChecksums, error) {
+	object, err := minioClient.GetObject(ctx, repo.Bucket, objectKey, minio.GetObjectOptions{})
+	if err != nil {
+		return FirmwareChecksums{}, err
+	}
+	defer object.Close()
+
+	hasher := newFirmwareHasher()
+	if _, err := io.Copy(hasher, object); err != nil {
+		return FirmwareChecksums{}, err
+	}
+	return hasher.Checksums(), nil
+}
+
+// verifyFirmwareImage 校验固件镜像与目录中记录的校验和是否一致；早期条目没有记录时补录
+func verifyFirmwareImage(ctx context.Context, repo *firmwareRepository, info FirmwareInfo) FirmwareVerifyResult {
+	result := FirmwareVerifyResult{
+		ID:        info.ID,
+		Version:   info.Version,
+		ObjectKey: firmwareObjectKey(repo, info),
+		Recorded:  info.FirmwareChecksums,
+	}
+
+	actual, err := hashFirmwareImage(ctx, repo, result.ObjectKey)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			result.Status = integrityStatusMissing
+		} else {
+			result.Status = integrityStatusError
+		}
+		result.Error = err.Error()
+		return result
+	}
+	result.Actual = actual
+
+	switch {
+	case info.SHA256 == "":
+		result.Backfilled = true
+		result.Status = integrityStatusOK
+	case actual.SHA256 != info.SHA256 || (info.Size != 0 && actual.Size != info.Size):
+		result.Status = integrityStatusMismatch
+	default:
+		result.Status = integrityStatusOK
+	}
+	return result
+}
+
+// verifyFirmware 重新校验产品的固件镜像（id 为空时校验全部），并把结果写回目录
+func verifyFirmware(ctx context.Context, repo *firmwareRepository, productName, id string) ([]FirmwareVerifyResult, error) {
+	firmwareList, err := getFirmwareList(repo, productName)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]FirmwareVerifyResult)
+	var ordered []FirmwareVerifyResult
+	for _, info := range firmwareList {
+		if id != "" && info.ID != id {
+			continue
+		}
+		result := verifyFirmwareImage(ctx, repo, info)
+		if result.Status == integrityStatusMismatch || result.Status == integrityStatusMissing {
+			log.Printf("固件 %s (%s) 校验失败: %s", info.ID, result.ObjectKey, result.Status)
+		}
+		results[info.ID] = result
+		ordered = append(ordered, result)
+	}
+	if id != "" && len(ordered) == 0 {
+		return nil, errFirmwareNotFound
+	}
+
+	verifiedAt := firmwareTimestamp()
+	err = updateFirmwareCatalog(ctx, repo, productName, func(firmwareList []FirmwareInfo) ([]FirmwareInfo, error) {
+		for i := range firmwareList {
+			result, ok := results[firmwareList[i].ID]
+			if !ok || result.Status == integrityStatusError {
+				// 读取失败不代表镜像损坏，不更新状态
+				continue
+			}
+			if result.Backfilled {
+				firmwareList[i].FirmwareChecksums = result.Actual
+			}
+			firmwareList[i].IntegrityStatus = result.Status
+			firmwareList[i].LastVerifiedAt = verifiedAt
+		}
+		return firmwareList, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ordered, nil
+}
+
+// verifyFirmwareHandler 重新校验存储中的固件镜像，检测静默损坏
+func verifyFirmwareHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	type VerifyFirmwareRequest struct {
+		ProductName string `json:"product_name"`
+		Id          string `json:"id"` // 可选，为空时校验产品的全部固件
+	}
+
+	var request VerifyFirmwareRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.ProductName == "" {
+		http.Error(w, "product_name is required", http.StatusBadRequest)
+		return
+	}
+
+	request.ProductName, err = resolveFirmwareProductName(r.Context(), repo, request.ProductName)
+	if err != nil {
+		http.Error(w, err.Error(), firmwareProductErrorStatus(err))
+		return
+	}
+
+	results, err := verifyFirmware(r.Context(), repo, request.ProductName, request.Id)
+	if errors.Is(err, errFirmwareNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	type VerifyFirmwareResponse struct {
+		Total   int                    `json:"total"`
+		Failed  int                    `json:"failed"`
+		Results []FirmwareVerifyResult `json:"results"`
+	}
+	response := VerifyFirmwareResponse{
+		Total:   len(results),
+		Results: results,
+	}
+	for _, result := range results {
+		if result.Status != integrityStatusOK {
+			response.Failed++
+		}
+	}
+
+	jsonResponse, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResponse)
+}
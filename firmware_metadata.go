@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 默认语言：早期条目的发布说明是单个字符串，读取时作为该语言的说明
+const defaultReleaseNotesLanguage = "zh"
+
+// UnmarshalJSON 兼容早期目录中字符串格式的发布说明
+func (t *LocalizedText) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '"' {
+		var text string
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+		*t = nil
+		if text != "" {
+			*t = LocalizedText{defaultReleaseNotesLanguage: text}
+		}
+		return nil
+	}
+
+	var texts map[string]string
+	if err := json.Unmarshal(data, &texts); err != nil {
+		return err
+	}
+	*t = texts
+	return nil
+}
+
+// Get 返回指定语言的文本，没有时依次回退到默认语言、en 和任意一种语言
+func (t LocalizedText) Get(language string) string {
+	for _, lang := range []string{normalizeLanguage(language), defaultReleaseNotesLanguage, "en"} {
+		if text, ok := t[lang]; ok {
+			return text
+		}
+	}
+	languages := make([]string, 0, len(t))
+	for lang := range t {
+		languages = append(languages, lang)
+	}
+	sort.Strings(languages)
+	if len(languages) > 0 {
+		return t[languages[0]]
+	}
+	return ""
+}
+
+// Select 只保留指定语言的文本，language 为空时返回全部语言
+func (t LocalizedText) Select(language string) LocalizedText {
+	if language == "" || len(t) == 0 {
+		return t
+	}
+	lang := normalizeLanguage(language)
+	if _, ok := t[lang]; !ok {
+		// 没有该语言时返回回退的文本，仍以请求的语言为 key
+		return LocalizedText{lang: t.Get(lang)}
+	}
+	return LocalizedText{lang: t[lang]}
+}
+
+// 语言代码统一为小写，zh-CN 之类的地区代码只保留语言部分
+func normalizeLanguage(language string) string {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if i := strings.IndexAny(language, "-_"); i > 0 {
+		language = language[:i]
+	}
+	return language
+}
+
+// normalize 校验并规范化发布信息；version 为固件自身的版本号，最低版本要求必须低于它
+func (r *FirmwareReleaseInfo) normalize(version string) error {
+	notes := make(LocalizedText)
+	for lang, text := range r.ReleaseNotes {
+		lang = normalizeLanguage(lang)
+		if lang == "" || strings.TrimSpace(text) == "" {
+			continue
+		}
+		notes[lang] = text
+	}
+	r.ReleaseNotes = nil
+	if len(notes) > 0 {
+		r.ReleaseNotes = notes
+	}
+
+	r.MinVersion = strings.TrimSpace(r.MinVersion)
+	if r.MinVersion != "" {
+		minVersion, err := parseSemVer(r.MinVersion)
+		if err != nil {
+			return fmt.Errorf("invalid min_version: %v", err)
+		}
+		if own, err := parseSemVer(version); err == nil && minVersion.Compare(own) >= 0 {
+			return fmt.Errorf("min_version %s must be lower than firmware version %s", minVersion, own)
+		}
+		r.MinVersion = minVersion.String()
+	}
+
+	r.CompatibleFrom = strings.TrimSpace(r.CompatibleFrom)
+	if r.CompatibleFrom != "" {
+		constraint, err := parseVersionConstraint(r.CompatibleFrom)
+		if err != nil {
+			return fmt.Errorf("invalid compatible_from: %v", err)
+		}
+		r.CompatibleFrom = constraint.String()
+	}
+
+	seen := make(map[string]bool)
+	var revisions []string
+	for _, revision := range r.HardwareRevisions {
+		revision = strings.TrimSpace(revision)
+		if revision != "" && !seen[revision] {
+			seen[revision] = true
+			revisions = append(revisions, revision)
+		}
+	}
+	r.HardwareRevisions = revisions
+
+	r.ChangelogURL = strings.TrimSpace(r.ChangelogURL)
+	if r.ChangelogURL != "" {
+		u, err := url.Parse(r.ChangelogURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid changelog_url %q, must be an http or https URL", r.ChangelogURL)
+		}
+	}
+	return nil
+}
+
+// firmwareReleaseInfoFromForm 读取上传表单中的发布信息：
+// release_notes 可以是纯文本（默认语言）或 {"zh": "...", "en": "..."} 格式的 JSON，
+// 也可以用 release_notes_zh、release_notes_en 等字段分别提供；
+// hardware_revisions 可以重复提供或用逗号分隔
+func firmwareReleaseInfoFromForm(form *multipart.Form) (FirmwareReleaseInfo, error) {
+	var release FirmwareReleaseInfo
+
+	notes := make(LocalizedText)
+	if text := firstFormValue(form, "release_notes"); text != "" {
+		if strings.HasPrefix(text, "{") {
+			var parsed map[string]string
+			if err := json.Unmarshal([]byte(text), &parsed); err != nil {
+				return release, fmt.Errorf("invalid release_notes JSON: %v", err)
+			}
+			for lang, text := range parsed {
+				notes[lang] = text
+			}
+		} else {
+			notes[defaultReleaseNotesLanguage] = text
+		}
+	}
+	for key := range form.Value {
+		if lang, ok := strings.CutPrefix(key, "release_notes_"); ok {
+			notes[lang] = firstFormValue(form, key)
+		}
+	}
+	release.ReleaseNotes = notes
+
+	release.MinVersion = firstFormValue(form, "min_version")
+	release.CompatibleFrom = firstFormValue(form, "compatible_from")
+	for _, value := range form.Value["hardware_revisions"] {
+		release.HardwareRevisions = append(release.HardwareRevisions, strings.Split(value, ",")...)
+	}
+	if value := firstFormValue(form, "mandatory"); value != "" {
+		mandatory, err := strconv.ParseBool(value)
+		if err != nil {
+			return release, fmt.Errorf("invalid mandatory %q", value)
+		}
+		release.Mandatory = mandatory
+	}
+	release.ChangelogURL = firstFormValue(form, "changelog_url")
+
+	// 固件版本号在校验文件名后才知道，这里只校验格式
+	if err := release.normalize(""); err != nil {
+		return release, err
+	}
+	return release, nil
+}
+
+// firmwareSupportsHardware 判断固件是否支持该硬件版本，没有限制硬件版本的固件支持所有设备
+func firmwareSupportsHardware(info FirmwareInfo, hardwareRevision string) bool {
+	return len(info.HardwareRevisions) == 0 || containsString(info.HardwareRevisions, hardwareRevision)
+}
+
+// 修改发布信息的请求，字段为空表示保持不变
+type firmwareReleaseUpdate struct {
+	ReleaseNotes      LocalizedText `json:"release_notes"` // 按语言合并，某语言的值为空字符串时删除该语言
+	MinVersion        *string       `json:"min_version"`
+	CompatibleFrom    *string       `json:"compatible_from"`
+	HardwareRevisions *[]string     `json:"hardware_revisions"`
+	Mandatory         *bool         `json:"mandatory"`
+	ChangelogURL      *string       `json:"changelog_url"`
+}
+
+func (u firmwareReleaseUpdate) apply(release FirmwareReleaseInfo) FirmwareReleaseInfo {
+	if u.ReleaseNotes != nil {
+		notes := make(LocalizedText)
+		for lang, text := range release.ReleaseNotes {
+			notes[lang] = text
+		}
+		for lang, text := range u.ReleaseNotes {
+			notes[normalizeLanguage(lang)] = text
+		}
+		release.ReleaseNotes = notes
+	}
+	if u.MinVersion != nil {
+		release.MinVersion = *u.MinVersion
+	}
+	if u.CompatibleFrom != nil {
+		release.CompatibleFrom = *u.CompatibleFrom
+	}
+	if u.HardwareRevisions != nil {
+		release.HardwareRevisions = *u.HardwareRevisions
+	}
+	if u.Mandatory != nil {
+		release.Mandatory = *u.Mandatory
+	}
+	if u.ChangelogURL != nil {
+		release.ChangelogURL = *u.ChangelogURL
+	}
+	return release
+}
+
+// 修改后的发布信息不合法
+var errInvalidReleaseInfo = errors.New("invalid release info")
+
+// updateFirmwareReleaseInfo 修改已发布固件的发布说明和安装要求，无需重新上传镜像
+func updateFirmwareReleaseInfo(ctx context.Context, repo *firmwareRepository, productName, id string, update firmwareReleaseUpdate, updatedBy string) (*FirmwareInfo, error) {
+	var updated *FirmwareInfo
+	err := updateFirmwareCatalog(ctx, repo, productName, func(firmwareList []FirmwareInfo) ([]FirmwareInfo, error) {
+		updated = nil
+		for i := range firmwareList {
+			if firmwareList[i].ID != id {
+				continue
+			}
+
+			release := update.apply(firmwareList[i].FirmwareReleaseInfo)
+			if err := release.normalize(firmwareList[i].Version); err != nil {
+				return nil, fmt.Errorf("%w: %v", errInvalidReleaseInfo, err)
+			}
+			firmwareList[i].FirmwareReleaseInfo = release
+			firmwareList[i].ReleaseInfoUpdatedBy = updatedBy
+			firmwareList[i].ReleaseInfoUpdatedAt = firmwareTimestamp()
+
+			firmwareCopy := firmwareList[i]
+			updated = &firmwareCopy
+			return firmwareList, nil
+		}
+		return nil, errFirmwareNotFound
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 清单中记录了安装要求，修改后重新签名
+	if err := resignFirmwareManifest(ctx, repo, *updated); err != nil {
+		log.Printf("重新签名固件 %s 的清单失败: %v", updated.ID, err)
+	}
+	return updated, nil
+}
+
+// updateFirmwareInfoHandler 修改已发布固件的发布说明、兼容的源版本、硬件版本、强制更新标记和更新日志链接，
+// 修改后有旧版本无法升级到该固件时在 warnings 中提示
+func updateFirmwareInfoHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	type UpdateFirmwareInfoRequest struct {
+		Id          string `json:"id"`
+		ProductName string `json:"product_name"`
+		UpdatedBy   string `json:"updated_by"`
+		firmwareReleaseUpdate
+	}
+
+	var request UpdateFirmwareInfoRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.Id == "" || request.ProductName == "" {
+		http.Error(w, "id and product_name are required", http.StatusBadRequest)
+		return
+	}
+
+	// 归档的产品不能再修改固件
+	product, err := writableFirmwareProduct(r.Context(), repo, request.ProductName)
+	if err != nil {
+		http.Error(w, err.Error(), firmwareProductErrorStatus(err))
+		return
+	}
+	request.ProductName = product.Name
+
+	updated, err := updateFirmwareReleaseInfo(r.Context(), repo, request.ProductName, request.Id, request.firmwareReleaseUpdate, request.UpdatedBy)
+	if errors.Is(err, errFirmwareNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errInvalidReleaseInfo) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// 修改兼容性约束后提示无法升级到该固件的旧版本
+	type UpdateFirmwareInfoResponse struct {
+		*FirmwareInfo
+		Warnings []string `json:"warnings,omitempty"`
+	}
+	response := UpdateFirmwareInfoResponse{
+		FirmwareInfo: updated,
+		Warnings:     logUpgradePathWarnings(repo, request.ProductName, *updated),
+	}
+
+	jsonResponse, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResponse)
+}
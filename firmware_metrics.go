@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Prometheus 计数器，key 为格式化后的标签；进程重启后从 0 开始
+type promCounter struct {
+	name   string
+	help   string
+	values map[string]float64
+}
+
+var (
+	firmwareDownloadsTotal = &promCounter{
+		name:   "firmware_downloads_total",
+		help:   "Firmware image downloads by repository, product, version and result (complete or aborted).",
+		values: make(map[string]float64),
+	}
+	firmwareDownloadBytesTotal = &promCounter{
+		name:   "firmware_download_bytes_total",
+		help:   "Bytes of firmware images served by repository, product and version.",
+		values: make(map[string]float64),
+	}
+	firmwareUpdateChecksTotal = &promCounter{
+		name:   "firmware_update_checks_total",
+		help:   "Device update checks by repository, product and result (update_available or up_to_date).",
+		values: make(map[string]float64),
+	}
+	firmwareMetricsMu sync.Mutex
+)
+
+// promLabels 按 Prometheus 文本格式生成标签，参数为 名称、值 交替排列
+func promLabels(pairs ...string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	var labels []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, pairs[i], replacer.Replace(pairs[i+1])))
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+func (c *promCounter) add(labels string, value float64) {
+	c.values[labels] += value
+}
+
+// write 按标签排序输出计数器
+func (c *promCounter) write(b *strings.Builder) {
+	fmt.Fprintf(b, "# HELP %s %s\n", c.name, c.help)
+	fmt.Fprintf(b, "# TYPE %s counter\n", c.name)
+	labels := make([]string, 0, len(c.values))
+	for label := range c.values {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		fmt.Fprintf(b, "%s%s %g\n", c.name, label, c.values[label])
+	}
+}
+
+// countFirmwareAccess 根据访问记录更新计数器
+func countFirmwareAccess(event FirmwareAccessEvent) {
+	firmwareMetricsMu.Lock()
+	defer firmwareMetricsMu.Unlock()
+
+	switch event.Type {
+	case firmwareAccessDownload:
+		firmwareDownloadsTotal.add(promLabels("repository", event.Repository, "product", event.ProductName, "version", event.Version, "result", event.Result), 1)
+		firmwareDownloadBytesTotal.add(promLabels("repository", event.Repository, "product", event.ProductName, "version", event.Version), float64(event.BytesServed))
+	case firmwareAccessCheck:
+		firmwareUpdateChecksTotal.add(promLabels("repository", event.Repository, "product", event.ProductName, "result", event.Result), 1)
+	}
+}
+
+// metricsHandler 以 Prometheus 文本格式输出固件下载和检查更新的计数
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var b strings.Builder
+	firmwareMetricsMu.Lock()
+	for _, counter := range []*promCounter{firmwareDownloadsTotal, firmwareDownloadBytesTotal, firmwareUpdateChecksTotal} {
+		counter.write(&b)
+	}
+	firmwareMetricsMu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// firmwareInstallableFrom 判断运行 current 版本的设备能否直接安装该固件：
+// 需要满足最低版本要求和兼容的源版本约束
+func firmwareInstallableFrom(info FirmwareInfo, current SemVer) bool {
+	if info.MinVersion != "" {
+		if minVersion, err := parseSemVer(info.MinVersion); err == nil && current.Compare(minVersion) < 0 {
+			return false
+		}
+	}
+	if info.CompatibleFrom != "" {
+		if constraint, err := parseVersionConstraint(info.CompatibleFrom); err == nil && !constraint.Match(current) {
+			return false
+		}
+	}
+	return true
+}
+
+// upgradeReachability 在 entries（按版本升序排列）中从 current 版本出发广度优先搜索升级路径，
+// 每一步只能安装版本更高、且可以从当前所在版本直接安装的固件；
+// 返回每个固件在最短路径上的前一个固件下标（-1 表示起点）以及是否可以到达
+func upgradeReachability(entries []firmwareEntry, current SemVer) (parents []int, reached []bool) {
+	parents = make([]int, len(entries))
+	reached = make([]bool, len(entries))
+
+	queue := []int{-1}
+	for len(queue) > 0 {
+		from := queue[0]
+		queue = queue[1:]
+
+		version := current
+		if from >= 0 {
+			version = entries[from].Version
+		}
+		// 从新到旧扩展，跳数相同时优先经过更新的版本
+		for i := len(entries) - 1; i >= 0; i-- {
+			if reached[i] || entries[i].Version.Compare(version) <= 0 || !firmwareInstallableFrom(entries[i].Info, version) {
+				continue
+			}
+			reached[i] = true
+			parents[i] = from
+			queue = append(queue, i)
+		}
+	}
+	return parents, reached
+}
+
+// upgradePathTo 根据 upgradeReachability 的结果还原到 entries[target] 的路径
+func upgradePathTo(entries []firmwareEntry, parents []int, target int) []firmwareEntry {
+	var path []firmwareEntry
+	for i := target; i >= 0; i = parents[i] {
+		path = append([]firmwareEntry{entries[i]}, path...)
+	}
+	return path
+}
+
+// latestUpgradePath 返回从 current 出发可以到达的最新固件的升级路径；
+// 最新固件不高于当前版本时直接返回该固件（已是最新或需要回退），没有可以到达的更高版本时返回 nil
+func latestUpgradePath(entries []firmwareEntry, current SemVer) []firmwareEntry {
+	if len(entries) == 0 {
+		return nil
+	}
+	if latest := entries[len(entries)-1]; latest.Version.Compare(current) <= 0 {
+		return []firmwareEntry{latest}
+	}
+
+	parents, reached := upgradeReachability(entries, current)
+	for i := len(entries) - 1; i >= 0; i-- {
+		if reached[i] {
+			return upgradePathTo(entries, parents, i)
+		}
+	}
+	return nil
+}
+
+// 升级路径中各固件的版本描述
+func upgradePathVersions(path []firmwareEntry) []string {
+	versions := make([]string, 0, len(path))
+	for _, entry := range path {
+		versions = append(versions, entry.Desc.DisplayVersion())
+	}
+	return versions
+}
+
+// upgradePathWarnings 检查固件的兼容性约束：同一版本类型中比它旧的每个版本都应该能经过若干次升级到达它，
+// 返回无法到达的旧版本的提示；检查不区分通道和硬件版本
+func upgradePathWarnings(repo *firmwareRepository, productName string, info FirmwareInfo) ([]string, error) {
+	desc, err := firmwareDescriptorFromInfo(info)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := listFirmwareEntries(repo, productName, firmwareQuery{Edition: desc.Edition})
+	if err != nil {
+		return nil, err
+	}
+
+	target := -1
+	for i := range entries {
+		if entries[i].Info.ID == info.ID {
+			target = i
+		}
+	}
+	if target < 0 {
+		return nil, errFirmwareNotFound
+	}
+
+	var warnings []string
+	checked := make(map[string]bool)
+	for _, entry := range entries[:target] {
+		version := entry.Version.String()
+		if checked[version] || entry.Version.Compare(entries[target].Version) >= 0 {
+			continue
+		}
+		checked[version] = true
+
+		if _, reached := upgradeReachability(entries, entry.Version); !reached[target] {
+			warnings = append(warnings, fmt.Sprintf("version %s has no upgrade path to %s", entry.Desc.DisplayVersion(), entries[target].Desc.DisplayVersion()))
+		}
+	}
+	return warnings, nil
+}
+
+// 记录固件发布或修改后的升级路径提示
+func logUpgradePathWarnings(repo *firmwareRepository, productName string, info FirmwareInfo) []string {
+	warnings, err := upgradePathWarnings(repo, productName, info)
+	if err != nil {
+		log.Printf("检查固件 %s 的升级路径失败: %v", info.ID, err)
+		return nil
+	}
+	for _, warning := range warnings {
+		log.Printf("固件 %s 升级路径警告: %s", info.ID, warning)
+	}
+	return warnings
+}
+
+// getUpgradePathHandler 计算设备从当前版本到目标版本（默认为可以到达的最新版本）的升级路径
+func getUpgradePathHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	type GetUpgradePathRequest struct {
+		ProductName      string `json:"product_name"`
+		CurrentVersion   string `json:"current_version"`
+		TargetVersion    string `json:"target_version"` // 可选，为空时取可以到达的最新版本
+		Edition          string `json:"edition"`
+		Channel          string `json:"channel"` // 默认 stable
+		HardwareRevision string `json:"hardware_revision"`
+	}
+
+	var request GetUpgradePathRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.ProductName == "" || request.CurrentVersion == "" {
+		http.Error(w, "product_name and current_version are required", http.StatusBadRequest)
+		return
+	}
+
+	request.ProductName, err = resolveFirmwareProductName(r.Context(), repo, request.ProductName)
+	if err != nil {
+		http.Error(w, err.Error(), firmwareProductErrorStatus(err))
+		return
+	}
+	current, err := parseSemVer(request.CurrentVersion)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.Channel == "" {
+		request.Channel = firmwareChannelStable
+	}
+	channel, err := normalizeFirmwareChannel(request.Channel)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := listFirmwareEntries(repo, request.ProductName, firmwareQuery{
+		Edition:          request.Edition,
+		Channel:          channel,
+		HardwareRevision: request.HardwareRevision,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := FirmwareUpgradePath{
+		ProductName:    request.ProductName,
+		CurrentVersion: request.CurrentVersion,
+		Hops:           []FirmwareInfo{},
+	}
+
+	var path []firmwareEntry
+	if request.TargetVersion == "" {
+		path = latestUpgradePath(entries, current)
+		if len(path) > 0 && path[len(path)-1].Version.Compare(current) <= 0 {
+			// 已是最新版本，不需要升级
+			path = nil
+		}
+		response.Reachable = true
+	} else {
+		targetVersion, err := parseSemVer(request.TargetVersion)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// 同一版本号有多个构建时取最新的
+		target := -1
+		for i := range entries {
+			if entries[i].Version.Compare(targetVersion) == 0 {
+				target = i
+			}
+		}
+		if target < 0 {
+			http.Error(w, fmt.Sprintf("firmware version %s not found", targetVersion), http.StatusNotFound)
+			return
+		}
+		response.TargetVersion = entries[target].Desc.DisplayVersion()
+
+		parents, reached := upgradeReachability(entries, current)
+		response.Reachable = reached[target] || targetVersion.Compare(current) == 0
+		if reached[target] {
+			path = upgradePathTo(entries, parents, target)
+		}
+	}
+
+	for _, entry := range path {
+		response.Hops = append(response.Hops, entry.Info)
+	}
+	if len(path) > 0 && response.TargetVersion == "" {
+		response.TargetVersion = path[len(path)-1].Desc.DisplayVersion()
+	}
+
+	jsonResponse, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResponse)
+}
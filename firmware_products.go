@@ -0,0 +1,759 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// 固件产品注册表，保存在固件仓库中
+func firmwareProductsKey(repo *firmwareRepository) string {
+	return repo.key("products.json")
+}
+
+// 产品名称用于存储路径和固件文件名，只允许字母、数字、点、下划线和连字符
+var firmwareProductNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+var (
+	// 产品未注册
+	errProductNotFound = errors.New("firmware product not found")
+	// 产品名称已被使用
+	errProductExists = errors.New("firmware product already exists")
+	// 产品已归档，不能再发布或修改固件
+	errProductArchived = errors.New("firmware product is archived")
+	// 产品名称、版本类型或硬件版本不合法
+	errInvalidProduct = errors.New("invalid firmware product")
+)
+
+// validateFirmwareProductName 校验产品名称，避免非法字符和 ../ 逃出固件目录
+func validateFirmwareProductName(name string) error {
+	if !firmwareProductNameRe.MatchString(name) || strings.Contains(name, "..") {
+		return fmt.Errorf("%w: invalid product name %q", errInvalidProduct, name)
+	}
+	return nil
+}
+
+// 产品注册错误对应的 HTTP 状态码
+func firmwareProductErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errProductNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errProductExists), errors.Is(err, errProductArchived):
+		return http.StatusConflict
+	case errors.Is(err, errInvalidProduct):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func loadFirmwareProducts(ctx context.Context, repo *firmwareRepository) ([]FirmwareProduct, error) {
+	products, _, err := loadFirmwareObject[[]FirmwareProduct](ctx, repo, firmwareProductsKey(repo))
+	return products, err
+}
+
+func updateFirmwareProducts(ctx context.Context, repo *firmwareRepository, update func([]FirmwareProduct) ([]FirmwareProduct, error)) error {
+	return updateFirmwareObject(ctx, repo, firmwareProductsKey(repo), update)
+}
+
+// findFirmwareProduct 按名称或改名前的名称查找产品
+func findFirmwareProduct(products []FirmwareProduct, name string) int {
+	for i := range products {
+		if products[i].Name == name {
+			return i
+		}
+	}
+	for i := range products {
+		if containsString(products[i].Aliases, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// lookupFirmwareProduct 返回已注册的产品，改名前的名称也可以查到
+func lookupFirmwareProduct(ctx context.Context, repo *firmwareRepository, name string) (*FirmwareProduct, error) {
+	if name == "" {
+		return nil, fmt.Errorf("%w: product_name is required", errInvalidProduct)
+	}
+	products, err := loadFirmwareProducts(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	i := findFirmwareProduct(products, name)
+	if i < 0 {
+		return nil, fmt.Errorf("%w: %s", errProductNotFound, name)
+	}
+	return &products[i], nil
+}
+
+// resolveFirmwareProductName 校验产品已注册并返回当前名称
+func resolveFirmwareProductName(ctx context.Context, repo *firmwareRepository, name string) (string, error) {
+	product, err := lookupFirmwareProduct(ctx, repo, name)
+	if err != nil {
+		return "", err
+	}
+	return product.Name, nil
+}
+
+// writableFirmwareProduct 返回可以发布、修改固件的产品，归档的产品返回 errProductArchived
+func writableFirmwareProduct(ctx context.Context, repo *firmwareRepository, name string) (*FirmwareProduct, error) {
+	product, err := lookupFirmwareProduct(ctx, repo, name)
+	if err != nil {
+		return nil, err
+	}
+	if product.Archived {
+		return nil, fmt.Errorf("%w: %s", errProductArchived, product.Name)
+	}
+	return product, nil
+}
+
+// importTargetProduct 返回导入固件的目标产品：未注册的产品自动注册并允许导入的文件类型，dryRun 时只校验名称；
+// 归档的产品返回 errProductArchived
+func importTargetProduct(ctx context.Context, repo *firmwareRepository, name, fileType, createdBy string, dryRun bool) (*FirmwareProduct, error) {
+	product, err := writableFirmwareProduct(ctx, repo, name)
+	if !errors.Is(err, errProductNotFound) {
+		return product, err
+	}
+	product = &FirmwareProduct{Name: name, CreatedBy: createdBy}
+	if fileType != "" && fileType != firmwareDefaultFileType {
+		product.FileTypes = []string{fileType}
+	}
+	if dryRun {
+		return product, validateFirmwareProductName(name)
+	}
+	return createFirmwareProduct(ctx, repo, *product)
+}
+
+// checkRelease 校验固件的版本类型和硬件版本在产品允许的范围内
+func (p FirmwareProduct) checkRelease(edition string, hardwareRevisions []string) error {
+	if len(p.Variants) > 0 && !containsString(p.Variants, edition) {
+		return fmt.Errorf("%w: edition %q is not one of %s", errInvalidProduct, edition, strings.Join(p.Variants, ", "))
+	}
+	if len(p.HardwareRevisions) > 0 {
+		for _, revision := range hardwareRevisions {
+			if !containsString(p.HardwareRevisions, revision) {
+				return fmt.Errorf("%w: hardware revision %q is not one of %s", errInvalidProduct, revision, strings.Join(p.HardwareRevisions, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// 去掉空白和重复的值
+func normalizeStringList(values []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value != "" && !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// createFirmwareProduct 注册新产品
+func createFirmwareProduct(ctx context.Context, repo *firmwareRepository, product FirmwareProduct) (*FirmwareProduct, error) {
+	if err := validateFirmwareProductName(product.Name); err != nil {
+		return nil, err
+	}
+	product.Variants = normalizeStringList(product.Variants)
+	product.HardwareRevisions = normalizeStringList(product.HardwareRevisions)
+	fileTypes, err := normalizeFirmwareFileTypes(product.FileTypes)
+	if err != nil {
+		return nil, err
+	}
+	product.FileTypes = fileTypes
+	product.Aliases = nil
+	product.Archived = false
+	product.CreatedAt = firmwareTimestamp()
+	product.UpdatedBy = ""
+	product.UpdatedAt = ""
+
+	err = updateFirmwareProducts(ctx, repo, func(products []FirmwareProduct) ([]FirmwareProduct, error) {
+		if findFirmwareProduct(products, product.Name) >= 0 {
+			return nil, fmt.Errorf("%w: %s", errProductExists, product.Name)
+		}
+		return append(products, product), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+// 修改产品信息的请求，字段为空表示保持不变
+type firmwareProductUpdate struct {
+	DisplayName       *string   `json:"display_name"`
+	Variants          *[]string `json:"variants"`
+	HardwareRevisions *[]string `json:"hardware_revisions"`
+	FileTypes         *[]string `json:"file_types"`
+	Archived          *bool     `json:"archived"`
+}
+
+// updateFirmwareProduct 修改产品的显示名称、版本类型、硬件版本、文件类型或归档状态
+func updateFirmwareProduct(ctx context.Context, repo *firmwareRepository, name string, update firmwareProductUpdate, updatedBy string) (*FirmwareProduct, error) {
+	var fileTypes []string
+	if update.FileTypes != nil {
+		var err error
+		if fileTypes, err = normalizeFirmwareFileTypes(*update.FileTypes); err != nil {
+			return nil, err
+		}
+	}
+
+	var updated *FirmwareProduct
+	err := updateFirmwareProducts(ctx, repo, func(products []FirmwareProduct) ([]FirmwareProduct, error) {
+		i := findFirmwareProduct(products, name)
+		if i < 0 {
+			return nil, fmt.Errorf("%w: %s", errProductNotFound, name)
+		}
+		if update.DisplayName != nil {
+			products[i].DisplayName = strings.TrimSpace(*update.DisplayName)
+		}
+		if update.Variants != nil {
+			products[i].Variants = normalizeStringList(*update.Variants)
+		}
+		if update.HardwareRevisions != nil {
+			products[i].HardwareRevisions = normalizeStringList(*update.HardwareRevisions)
+		}
+		if update.FileTypes != nil {
+			products[i].FileTypes = fileTypes
+		}
+		if update.Archived != nil {
+			products[i].Archived = *update.Archived
+		}
+		products[i].UpdatedBy = updatedBy
+		products[i].UpdatedAt = firmwareTimestamp()
+
+		productCopy := products[i]
+		updated = &productCopy
+		return products, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// renameFirmwareProduct 修改产品名称。先在注册表中改名并把旧名称保留为别名，此后按任一名称发布、提升或锁定的固件
+// 都写入新目录；再把旧目录中的条目逐条迁移到新目录（复制镜像、重新签名清单），目录、发布策略和下载次数都通过条件写入合并。
+// 只删除已经迁移的对象，不清空旧目录。迁移中途失败时注册表已经改名，用旧名称再次改名会继续迁移
+func renameFirmwareProduct(ctx context.Context, repo *firmwareRepository, oldName, newName, updatedBy string) (*FirmwareProduct, error) {
+	if err := validateFirmwareProductName(newName); err != nil {
+		return nil, err
+	}
+	product, err := writableFirmwareProduct(ctx, repo, oldName)
+	if err != nil {
+		return nil, err
+	}
+	// 上次改名中途失败：注册表中已经是新名称，继续迁移旧名称下的目录
+	resume := product.Name == newName && oldName != newName && containsString(product.Aliases, oldName)
+	if !resume {
+		oldName = product.Name
+		if oldName == newName {
+			return product, nil
+		}
+	}
+
+	// 先确认所有条目都能按新名称生成路径，避免注册表改名后才发现无法迁移
+	firmwareList, _, err := loadFirmwareCatalog(ctx, repo, oldName)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range firmwareList {
+		if _, err := renamedFirmwareDescriptor(info, newName); err != nil {
+			return nil, err
+		}
+	}
+
+	updated := product
+	if !resume {
+		err = updateFirmwareProducts(ctx, repo, func(products []FirmwareProduct) ([]FirmwareProduct, error) {
+			i := findFirmwareProduct(products, oldName)
+			if i < 0 {
+				return nil, fmt.Errorf("%w: %s", errProductNotFound, oldName)
+			}
+			if j := findFirmwareProduct(products, newName); j >= 0 && j != i {
+				return nil, fmt.Errorf("%w: %s", errProductExists, newName)
+			}
+			products[i].Name = newName
+			products[i].Aliases = normalizeStringList(append(products[i].Aliases, oldName))
+			products[i].UpdatedBy = updatedBy
+			products[i].UpdatedAt = firmwareTimestamp()
+
+			productCopy := products[i]
+			updated = &productCopy
+			return products, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	renameDeviceCheckinProduct(repo, oldName, newName)
+
+	if err := migrateFirmwareCatalog(ctx, repo, oldName, newName); err != nil {
+		return nil, fmt.Errorf("迁移产品 %s 的固件失败，用原名称重新改名可以继续: %w", oldName, err)
+	}
+	if err := migrateFirmwareRollouts(ctx, repo, oldName, newName); err != nil {
+		return nil, fmt.Errorf("迁移产品 %s 的发布策略失败，用原名称重新改名可以继续: %w", oldName, err)
+	}
+	if err := migrateFirmwareDownloads(ctx, repo, oldName, newName); err != nil {
+		return nil, fmt.Errorf("迁移产品 %s 的下载次数失败，用原名称重新改名可以继续: %w", oldName, err)
+	}
+
+	// 差分按新名称重新生成，只删除旧差分索引中记录的差分文件
+	deltas, err := loadFirmwareDeltas(ctx, repo, oldName)
+	if err != nil {
+		log.Printf("读取产品 %s 的差分索引失败: %v", oldName, err)
+	}
+	for _, delta := range deltas {
+		removeFirmwareObjects(ctx, repo, delta.ObjectKey)
+	}
+	if err == nil {
+		removeFirmwareObjects(ctx, repo, getDeltaIndexKey(repo, oldName))
+	}
+	triggerFirmwareDeltas(repo, newName)
+	return updated, nil
+}
+
+// renamedFirmwareDescriptor 返回固件按新产品名称生成的描述
+func renamedFirmwareDescriptor(info FirmwareInfo, productName string) (FirmwareDescriptor, error) {
+	desc, err := firmwareDescriptorFromInfo(info)
+	if err != nil {
+		return FirmwareDescriptor{}, fmt.Errorf("firmware %s cannot be renamed: %v", info.ID, err)
+	}
+	desc.ProductName = productName
+	return desc, nil
+}
+
+// migrateFirmwareCatalog 把旧目录中的条目迁移到新目录，完成后删除已经清空的旧目录。
+// 每一轮只从旧目录中移除与本轮快照一致的条目并删除它们的镜像和清单，快照之后新增或修改的条目在下一轮重新迁移
+func migrateFirmwareCatalog(ctx context.Context, repo *firmwareRepository, oldName, newName string) error {
+	copied := make(map[string]bool) // 已复制到新目录的旧镜像 key
+	for round := 0; round < firmwareCatalogMaxRetries; round++ {
+		snapshot, _, err := loadFirmwareCatalog(ctx, repo, oldName)
+		if err != nil {
+			return err
+		}
+		if len(snapshot) == 0 {
+			removeFirmwareObjects(ctx, repo, getObjectKey(repo, oldName))
+			return nil
+		}
+
+		original := make(map[string]FirmwareInfo, len(snapshot))
+		renamed := make(map[string]FirmwareInfo, len(snapshot))
+		for _, info := range snapshot {
+			objectKey := firmwareObjectKey(repo, info)
+			moved, err := copyFirmwareToProduct(ctx, repo, info, newName, !copied[objectKey])
+			if err != nil {
+				return err
+			}
+			copied[objectKey] = true
+			original[info.ID] = info
+			renamed[info.ID] = moved
+		}
+
+		// 新目录中已有的同一固件（上一轮迁移的旧版本）原位替换，其余按旧目录的顺序追加
+		err = updateFirmwareCatalog(ctx, repo, newName, func(firmwareList []FirmwareInfo) ([]FirmwareInfo, error) {
+			merged := make([]FirmwareInfo, 0, len(firmwareList)+len(snapshot))
+			replaced := make(map[string]bool)
+			for _, info := range firmwareList {
+				if moved, ok := renamed[info.ID]; ok {
+					info = moved
+					replaced[info.ID] = true
+				}
+				merged = append(merged, info)
+			}
+			for _, info := range snapshot {
+				if !replaced[info.ID] {
+					merged = append(merged, renamed[info.ID])
+				}
+			}
+			return merged, nil
+		})
+		if err != nil {
+			return fmt.Errorf("写入固件目录失败: %v", err)
+		}
+
+		var removed []FirmwareInfo
+		err = updateFirmwareCatalog(ctx, repo, oldName, func(firmwareList []FirmwareInfo) ([]FirmwareInfo, error) {
+			removed = nil
+			kept := make([]FirmwareInfo, 0, len(firmwareList))
+			for _, info := range firmwareList {
+				if prev, ok := original[info.ID]; ok && reflect.DeepEqual(prev, info) {
+					removed = append(removed, info)
+					continue
+				}
+				kept = append(kept, info)
+			}
+			if len(removed) == 0 {
+				return nil, errCatalogUnchanged
+			}
+			return kept, nil
+		})
+		if err != nil {
+			return fmt.Errorf("更新固件目录失败: %v", err)
+		}
+		for _, info := range removed {
+			objectKey := firmwareObjectKey(repo, info)
+			manifestKey := info.ManifestKey
+			if manifestKey == "" {
+				manifestKey = firmwareManifestKey(objectKey)
+			}
+			removeFirmwareObjects(ctx, repo, objectKey, manifestKey)
+		}
+	}
+	return fmt.Errorf("产品 %s 的固件目录在迁移期间持续变化", oldName)
+}
+
+// copyFirmwareToProduct 把固件复制到新产品名称下并重新签名清单，返回新的目录条目；
+// copyImage 为 false 时镜像已经复制过，只重新生成清单
+func copyFirmwareToProduct(ctx context.Context, repo *firmwareRepository, info FirmwareInfo, productName string, copyImage bool) (FirmwareInfo, error) {
+	desc, err := renamedFirmwareDescriptor(info, productName)
+	if err != nil {
+		return FirmwareInfo{}, err
+	}
+	newKey := desc.ObjectKey(repo)
+	if copyImage {
+		src := minio.CopySrcOptions{Bucket: repo.Bucket, Object: firmwareObjectKey(repo, info)}
+		dst := minio.CopyDestOptions{Bucket: repo.Bucket, Object: newKey}
+		if _, err := minioClient.CopyObject(ctx, dst, src); err != nil {
+			return FirmwareInfo{}, fmt.Errorf("复制固件 %s 失败: %v", info.ID, err)
+		}
+	}
+
+	// 镜像签名只与镜像内容有关，新清单沿用原有的镜像签名
+	var imageSignatures []FirmwareSignature
+	if _, manifest, err := loadFirmwareManifest(ctx, repo, info); err == nil {
+		imageSignatures = manifest.ImageSignatures
+	}
+
+	info.ProductName = productName
+	info.ObjectKey = newKey
+	info.URL = repo.objectURL(newKey)
+	info.ManifestKey, err = writeFirmwareManifest(ctx, repo, newKey, newFirmwareManifest(repo, info, imageSignatures))
+	if err != nil {
+		return FirmwareInfo{}, err
+	}
+	return info, nil
+}
+
+// migrateFirmwareRollouts 把旧名称下的发布策略合并到新名称下，新名称下已有同一固件的策略时保留新策略
+func migrateFirmwareRollouts(ctx context.Context, repo *firmwareRepository, oldName, newName string) error {
+	policies, err := loadRollouts(ctx, repo, oldName)
+	if err != nil {
+		return err
+	}
+	if len(policies) == 0 {
+		return nil
+	}
+	err = updateRollouts(ctx, repo, newName, func(list []RolloutPolicy) ([]RolloutPolicy, error) {
+		existing := make(map[string]bool, len(list))
+		for _, policy := range list {
+			existing[policy.FirmwareID] = true
+		}
+		merged := append([]RolloutPolicy(nil), list...)
+		for _, policy := range policies {
+			if !existing[policy.FirmwareID] {
+				policy.ProductName = newName
+				merged = append(merged, policy)
+			}
+		}
+		if len(merged) == len(list) {
+			return nil, errCatalogUnchanged
+		}
+		sort.Slice(merged, func(i, j int) bool { return merged[i].FirmwareID < merged[j].FirmwareID })
+		return merged, nil
+	})
+	if err != nil {
+		return err
+	}
+	removeFirmwareObjects(ctx, repo, getRolloutObjectKey(repo, oldName))
+	return nil
+}
+
+// migrateFirmwareDownloads 把旧名称下的下载次数累加到新名称下，固件 ID 不变
+func migrateFirmwareDownloads(ctx context.Context, repo *firmwareRepository, oldName, newName string) error {
+	downloads, _, err := loadFirmwareObject[map[string]FirmwareDownloadCount](ctx, repo, getDownloadsObjectKey(repo, oldName))
+	if err != nil {
+		return err
+	}
+	if len(downloads) == 0 {
+		return nil
+	}
+	err = updateFirmwareObject(ctx, repo, getDownloadsObjectKey(repo, newName), func(counts map[string]FirmwareDownloadCount) (map[string]FirmwareDownloadCount, error) {
+		if counts == nil {
+			counts = make(map[string]FirmwareDownloadCount)
+		}
+		for id, previous := range downloads {
+			count, ok := counts[id]
+			if !ok {
+				counts[id] = previous
+				continue
+			}
+			count.Downloads += previous.Downloads
+			if previous.LastDownloadAt > count.LastDownloadAt {
+				count.LastDownloadAt = previous.LastDownloadAt
+			}
+			counts[id] = count
+		}
+		return counts, nil
+	})
+	if err != nil {
+		return err
+	}
+	removeFirmwareObjects(ctx, repo, getDownloadsObjectKey(repo, oldName))
+	return nil
+}
+
+// 初始化产品注册表：把各仓库中已有固件目录但尚未注册的产品登记到仓库的注册表
+func initFirmwareProducts() {
+	for _, repo := range firmwareRepositories() {
+		importFirmwareProducts(context.Background(), repo)
+	}
+}
+
+func importFirmwareProducts(ctx context.Context, repo *firmwareRepository) {
+	names, err := listFirmwareProducts(ctx, repo)
+	if err != nil {
+		log.Printf("列出仓库 %s 的固件产品失败: %v", repo.Name, err)
+		return
+	}
+
+	err = updateFirmwareProducts(ctx, repo, func(products []FirmwareProduct) ([]FirmwareProduct, error) {
+		imported := 0
+		for _, name := range names {
+			if findFirmwareProduct(products, name) >= 0 {
+				continue
+			}
+			if err := validateFirmwareProductName(name); err != nil {
+				log.Printf("跳过名称不合法的固件目录 %q", name)
+				continue
+			}
+			products = append(products, FirmwareProduct{
+				Name:      name,
+				CreatedBy: "import",
+				CreatedAt: firmwareTimestamp(),
+			})
+			imported++
+		}
+		if imported == 0 {
+			return nil, errCatalogUnchanged
+		}
+		log.Printf("已导入 %d 个固件产品到仓库 %s 的产品注册表", imported, repo.Name)
+		return products, nil
+	})
+	if err != nil {
+		log.Printf("导入固件产品失败: %v", err)
+	}
+}
+
+func createFirmwareProductHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	type CreateFirmwareProductRequest struct {
+		ProductName       string   `json:"product_name"`
+		DisplayName       string   `json:"display_name"`
+		Variants          []string `json:"variants"`
+		HardwareRevisions []string `json:"hardware_revisions"`
+		FileTypes         []string `json:"file_types"`
+		CreatedBy         string   `json:"created_by"`
+	}
+
+	var request CreateFirmwareProductRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	product, err := createFirmwareProduct(r.Context(), repo, FirmwareProduct{
+		Name:              request.ProductName,
+		DisplayName:       request.DisplayName,
+		Variants:          request.Variants,
+		HardwareRevisions: request.HardwareRevisions,
+		FileTypes:         request.FileTypes,
+		CreatedBy:         request.CreatedBy,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), firmwareProductErrorStatus(err))
+		return
+	}
+
+	jsonResponse, err := json.MarshalIndent(product, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResponse)
+}
+
+// listFirmwareProductsHandler 返回按名称排序的产品列表，include_archived=true 时包括已归档的产品
+func listFirmwareProductsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "GET")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	products, err := loadFirmwareProducts(r.Context(), repo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	includeArchived := r.URL.Query().Get("include_archived") == "true"
+
+	result := []FirmwareProduct{}
+	for _, product := range products {
+		if !product.Archived || includeArchived {
+			result = append(result, product)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	jsonResponse, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResponse)
+}
+
+// updateFirmwareProductHandler 修改产品信息；archived 为 true 时归档产品，为 false 时恢复
+func updateFirmwareProductHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	type UpdateFirmwareProductRequest struct {
+		ProductName string `json:"product_name"`
+		UpdatedBy   string `json:"updated_by"`
+		firmwareProductUpdate
+	}
+
+	var request UpdateFirmwareProductRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	product, err := updateFirmwareProduct(r.Context(), repo, request.ProductName, request.firmwareProductUpdate, request.UpdatedBy)
+	if err != nil {
+		http.Error(w, err.Error(), firmwareProductErrorStatus(err))
+		return
+	}
+
+	jsonResponse, err := json.MarshalIndent(product, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResponse)
+}
+
+func renameFirmwareProductHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	type RenameFirmwareProductRequest struct {
+		ProductName string `json:"product_name"`
+		NewName     string `json:"new_name"`
+		UpdatedBy   string `json:"updated_by"`
+	}
+
+	var request RenameFirmwareProductRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	product, err := renameFirmwareProduct(r.Context(), repo, request.ProductName, request.NewName, request.UpdatedBy)
+	if err != nil {
+		http.Error(w, err.Error(), firmwareProductErrorStatus(err))
+		return
+	}
+
+	jsonResponse, err := json.MarshalIndent(product, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 改名期间按旧名称发布的固件也要迁移到新目录；旧目录中只删除已迁移的对象
+func TestRenameFirmwareProduct(t *testing.T) {
+	fake := newFakeMinio(t)
+	repo := defaultFirmwareRepo
+	ctx := context.Background()
+
+	products, _ := json.Marshal([]FirmwareProduct{{Name: "NXT2204"}})
+	fake.put(repo.Bucket, firmwareProductsKey(repo), products)
+	publish := func(i int) FirmwareInfo {
+		info, err := appendFirmwareInfo(repo, newTestFirmwareInfo(repo, "NXT2204", i), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fake.put(repo.Bucket, info.ObjectKey, []byte("image "+info.Version))
+		return *info
+	}
+	var published []FirmwareInfo
+	for i := 0; i < 3; i++ {
+		published = append(published, publish(i))
+	}
+	unrelated := repo.key("NXT2204", "notes.txt")
+	fake.put(repo.Bucket, unrelated, []byte("keep"))
+
+	// 第一次写入新目录前，另一个请求按改名前解析到的旧名称发布了固件
+	var once sync.Once
+	fake.afterRead = func(method, bucket, key string, found bool) {
+		if key == getObjectKey(repo, "NXT3300") {
+			once.Do(func() { published = append(published, publish(3)) })
+		}
+	}
+
+	product, err := renameFirmwareProduct(ctx, repo, "NXT2204", "NXT3300", "tester")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if product.Name != "NXT3300" || !containsString(product.Aliases, "NXT2204") {
+		t.Fatalf("renamed product = %+v", product)
+	}
+	if name, err := resolveFirmwareProductName(ctx, repo, "NXT2204"); err != nil || name != "NXT3300" {
+		t.Fatalf("old name resolves to %q, %v", name, err)
+	}
+
+	firmwareList := readTestCatalog(t, fake, repo, "NXT3300")
+	checkTestCatalog(t, firmwareList, 4)
+	for _, info := range firmwareList {
+		if info.ProductName != "NXT3300" || !strings.HasPrefix(info.ObjectKey, repo.productPrefix("NXT3300")) {
+			t.Errorf("firmware %s not renamed: %+v", info.ID, info)
+		}
+		if data, ok := fake.get(repo.Bucket, info.ObjectKey); !ok || string(data) != "image "+info.Version {
+			t.Errorf("image of %s not copied to %s", info.ID, info.ObjectKey)
+		}
+	}
+	for _, info := range published {
+		if _, ok := fake.get(repo.Bucket, info.ObjectKey); ok {
+			t.Errorf("old image %s not removed", info.ObjectKey)
+		}
+	}
+	if _, ok := fake.get(repo.Bucket, getObjectKey(repo, "NXT2204")); ok {
+		t.Error("old catalog not removed")
+	}
+	if _, ok := fake.get(repo.Bucket, unrelated); !ok {
+		t.Error("object not written by the old catalog was removed")
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// 固件仓库：固件镜像、固件目录和产品注册表所在的存储桶和 key 前缀。
+// 一个实例可以服务多个仓库（例如按 OEM 客户划分），每个请求通过 repository 查询参数
+// 或 X-Firmware-Repository 请求头选择仓库，未指定时使用默认仓库
+type firmwareRepository struct {
+	Name      string
+	Bucket    string
+	Prefix    string // key 前缀，以 / 结尾，例如 firmware/；为空表示存储桶根目录
+	URLScheme string // 固件目录中 url 字段使用的协议，例如 oss://{bucket}/{key}
+}
+
+// 默认仓库的名称
+const defaultFirmwareRepoName = "default"
+
+// 请求指定的仓库未配置
+var errRepositoryNotFound = errors.New("firmware repository not found")
+
+var firmwareRepoNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// 仓库注册表，启动时由 initFirmwareRepository 根据环境变量初始化，之后只读
+var (
+	firmwareRepos       = make(map[string]*firmwareRepository)
+	defaultFirmwareRepo *firmwareRepository
+)
+
+// initFirmwareRepository 读取固件仓库配置，并确保各仓库的存储桶存在。
+// 默认仓库由 FIRMWARE_BUCKET_NAME、FIRMWARE_PREFIX 配置；
+// FIRMWARE_REPOSITORIES 配置其他仓库，格式为 name=bucket[/prefix]，多个仓库以逗号分隔，
+// 例如 oem-a=oem-a-firmware,oem-b=nxt-device/oem-b；FIRMWARE_URL_SCHEME 对所有仓库生效
+func initFirmwareRepository() {
+	scheme := "oss"
+	if value := strings.TrimSpace(os.Getenv("FIRMWARE_URL_SCHEME")); value != "" {
+		scheme = strings.TrimSuffix(value, "://")
+	}
+
+	defaultRepo := &firmwareRepository{
+		Name:      defaultFirmwareRepoName,
+		Bucket:    "nxt-device",
+		Prefix:    "firmware/",
+		URLScheme: scheme,
+	}
+	if bucket := strings.TrimSpace(os.Getenv("FIRMWARE_BUCKET_NAME")); bucket != "" {
+		defaultRepo.Bucket = bucket
+	}
+	if prefix, ok := os.LookupEnv("FIRMWARE_PREFIX"); ok {
+		defaultRepo.Prefix = normalizeFirmwareRepoPrefix(prefix)
+	}
+	repos := []*firmwareRepository{defaultRepo}
+
+	for _, spec := range normalizeStringList(strings.Split(os.Getenv("FIRMWARE_REPOSITORIES"), ",")) {
+		name, location, ok := strings.Cut(spec, "=")
+		name, location = strings.TrimSpace(name), strings.Trim(strings.TrimSpace(location), "/")
+		if !ok || location == "" {
+			log.Fatalf("FIRMWARE_REPOSITORIES 格式错误: %q，应为 name=bucket[/prefix]", spec)
+		}
+		bucket, prefix, _ := strings.Cut(location, "/")
+		repos = append(repos, &firmwareRepository{
+			Name:      name,
+			Bucket:    bucket,
+			Prefix:    normalizeFirmwareRepoPrefix(prefix),
+			URLScheme: scheme,
+		})
+	}
+	if err := registerFirmwareRepositories(repos); err != nil {
+		log.Fatalln(err)
+	}
+
+	// 与租户存储桶一样，启动时创建固件存储桶
+	ctx := context.Background()
+	created := make(map[string]bool)
+	for _, repo := range firmwareRepositories() {
+		if !created[repo.Bucket] {
+			created[repo.Bucket] = true
+			err := minioClient.MakeBucket(ctx, repo.Bucket, minio.MakeBucketOptions{})
+			if err != nil {
+				exists, errBucketExists := minioClient.BucketExists(ctx, repo.Bucket)
+				if errBucketExists == nil && exists {
+					fmt.Printf("We already own %s\n", repo.Bucket)
+				} else {
+					log.Fatalln(err)
+				}
+			}
+		}
+		log.Printf("固件仓库 %s: 存储桶 %s，前缀 %q", repo.Name, repo.Bucket, repo.Prefix)
+	}
+}
+
+func normalizeFirmwareRepoPrefix(prefix string) string {
+	prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return prefix
+}
+
+// registerFirmwareRepositories 校验并替换仓库注册表，第一个仓库为默认仓库。
+// 同一存储桶中的仓库前缀不能互相包含，否则一个仓库会把另一个仓库的目录当作产品
+func registerFirmwareRepositories(repos []*firmwareRepository) error {
+	registry := make(map[string]*firmwareRepository)
+	for i, repo := range repos {
+		if !firmwareRepoNameRe.MatchString(repo.Name) {
+			return fmt.Errorf("固件仓库名称不合法: %q", repo.Name)
+		}
+		if registry[repo.Name] != nil {
+			return fmt.Errorf("固件仓库 %s 重复配置", repo.Name)
+		}
+		for _, other := range repos[:i] {
+			if repo.Bucket == other.Bucket && (strings.HasPrefix(repo.Prefix, other.Prefix) || strings.HasPrefix(other.Prefix, repo.Prefix)) {
+				return fmt.Errorf("固件仓库 %s 与 %s 的位置重叠: %s/%s, %s/%s", repo.Name, other.Name, repo.Bucket, repo.Prefix, other.Bucket, other.Prefix)
+			}
+		}
+		registry[repo.Name] = repo
+	}
+	firmwareRepos = registry
+	defaultFirmwareRepo = repos[0]
+	return nil
+}
+
+// firmwareRepositories 按名称顺序返回所有仓库，后台任务逐个仓库执行
+func firmwareRepositories() []*firmwareRepository {
+	repos := make([]*firmwareRepository, 0, len(firmwareRepos))
+	for _, repo := range firmwareRepos {
+		repos = append(repos, repo)
+	}
+	sort.Slice(repos, func(i, j int) bool { return repos[i].Name < repos[j].Name })
+	return repos
+}
+
+// lookupFirmwareRepository 按名称查找仓库，名称为空时返回默认仓库
+func lookupFirmwareRepository(name string) (*firmwareRepository, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return defaultFirmwareRepo, nil
+	}
+	repo, ok := firmwareRepos[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errRepositoryNotFound, name)
+	}
+	return repo, nil
+}
+
+// firmwareRepoFromRequest 返回请求选择的仓库：查询参数 repository 优先，也可以通过
+// X-Firmware-Repository 请求头指定；仓库不存在时写入 404 并返回 false
+func firmwareRepoFromRequest(w http.ResponseWriter, r *http.Request) (*firmwareRepository, bool) {
+	name := r.URL.Query().Get("repository")
+	if name == "" {
+		name = r.Header.Get("X-Firmware-Repository")
+	}
+	repo, err := lookupFirmwareRepository(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+	return repo, true
+}
+
+// key 返回仓库中的对象 key，例如 key("NXT2204", "firmwareInfo.json")
+func (repo *firmwareRepository) key(elem ...string) string {
+	return repo.Prefix + strings.Join(elem, "/")
+}
+
+// productPrefix 返回产品目录的前缀，以 / 结尾
+func (repo *firmwareRepository) productPrefix(productName string) string {
+	return repo.key(productName) + "/"
+}
+
+// objectURL 返回固件目录中记录的对象地址
+func (repo *firmwareRepository) objectURL(objectKey string) string {
+	return fmt.Sprintf("%s://%s/%s", repo.URLScheme, repo.Bucket, objectKey)
+}
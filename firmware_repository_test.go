@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegisterFirmwareRepositories(t *testing.T) {
+	prevRepos, prevDefaultRepo := firmwareRepos, defaultFirmwareRepo
+	t.Cleanup(func() { firmwareRepos, defaultFirmwareRepo = prevRepos, prevDefaultRepo })
+
+	repo := func(name, bucket, prefix string) *firmwareRepository {
+		return &firmwareRepository{Name: name, Bucket: bucket, Prefix: prefix, URLScheme: "oss"}
+	}
+	tests := []struct {
+		name  string
+		repos []*firmwareRepository
+		ok    bool
+	}{
+		{"single", []*firmwareRepository{repo("default", "nxt-device", "firmware/")}, true},
+		{"different prefixes", []*firmwareRepository{repo("default", "nxt-device", "firmware/"), repo("oem-a", "nxt-device", "oem-a/")}, true},
+		{"different buckets", []*firmwareRepository{repo("default", "nxt-device", "firmware/"), repo("oem-a", "oem-a", "firmware/")}, true},
+		{"sibling prefixes", []*firmwareRepository{repo("default", "nxt-device", "firmware/"), repo("oem-a", "nxt-device", "firmware-oem/")}, true},
+		{"same location", []*firmwareRepository{repo("default", "nxt-device", "firmware/"), repo("oem-a", "nxt-device", "firmware/")}, false},
+		{"nested prefix", []*firmwareRepository{repo("default", "nxt-device", "firmware/"), repo("oem-a", "nxt-device", "firmware/oem-a/")}, false},
+		{"bucket root", []*firmwareRepository{repo("default", "nxt-device", "firmware/"), repo("oem-a", "nxt-device", "")}, false},
+		{"duplicate name", []*firmwareRepository{repo("default", "nxt-device", "firmware/"), repo("default", "oem-a", "firmware/")}, false},
+		{"invalid name", []*firmwareRepository{repo("default", "nxt-device", "firmware/"), repo("../oem", "oem-a", "firmware/")}, false},
+	}
+	for _, tt := range tests {
+		err := registerFirmwareRepositories(tt.repos)
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: registered overlapping or invalid repositories", tt.name)
+		}
+		if tt.ok && (defaultFirmwareRepo != tt.repos[0] || len(firmwareRepos) != len(tt.repos)) {
+			t.Errorf("%s: registry has %d repositories, default %s", tt.name, len(firmwareRepos), defaultFirmwareRepo.Name)
+		}
+	}
+}
+
+func TestFirmwareRepoFromRequest(t *testing.T) {
+	newFakeMinio(t,
+		&firmwareRepository{Name: defaultFirmwareRepoName, Bucket: "nxt-device", Prefix: "firmware/", URLScheme: "oss"},
+		&firmwareRepository{Name: "oem-a", Bucket: "oem-a", Prefix: "firmware/", URLScheme: "oss"},
+		&firmwareRepository{Name: "oem-b", Bucket: "oem-b", Prefix: "firmware/", URLScheme: "oss"},
+	)
+
+	tests := []struct {
+		url    string
+		header string
+		want   string // 为空表示应返回 404
+	}{
+		{"/getFirmwareList", "", defaultFirmwareRepoName},
+		{"/getFirmwareList?repository=oem-a", "", "oem-a"},
+		{"/getFirmwareList", "oem-b", "oem-b"},
+		{"/getFirmwareList?repository=oem-a", "oem-b", "oem-a"},
+		{"/getFirmwareList?repository=oem-c", "", ""},
+		{"/getFirmwareList", "oem-c", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, tt.url, nil)
+		if tt.header != "" {
+			r.Header.Set("X-Firmware-Repository", tt.header)
+		}
+		w := httptest.NewRecorder()
+		repo, ok := firmwareRepoFromRequest(w, r)
+		if tt.want == "" {
+			if ok || w.Code != http.StatusNotFound {
+				t.Errorf("%s (header %q): got ok=%v status %d, want 404", tt.url, tt.header, ok, w.Code)
+			}
+			continue
+		}
+		if !ok || repo.Name != tt.want {
+			t.Errorf("%s (header %q): got %v, want repository %s", tt.url, tt.header, repo, tt.want)
+		}
+	}
+
+	if _, err := lookupFirmwareRepository("oem-c"); !errors.Is(err, errRepositoryNotFound) {
+		t.Errorf("lookupFirmwareRepository returned %v, want errRepositoryNotFound", err)
+	}
+}
+
+// 处理函数在选择仓库之前不能写入状态码，否则未配置的仓库会以 200 返回
+func TestFirmwareHandlersUnknownRepository(t *testing.T) {
+	newFakeMinio(t)
+
+	tests := []struct {
+		method  string
+		url     string
+		handler http.HandlerFunc
+	}{
+		{http.MethodPost, "/getFirmwareList?repository=oem-c", getFirmwareListHandler},
+		{http.MethodDelete, "/deleteFirmware?repository=oem-c", deleteFirmwareHandler},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.url, strings.NewReader(`{"product_name":"NXT2204","id":"x"}`))
+		w := httptest.NewRecorder()
+		tt.handler(w, r)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s %s: status %d, want 404", tt.method, tt.url, w.Code)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// 每个通道、版本类型保留的最新版本数，0 表示不自动清理
+var firmwareRetentionKeep int
+
+// 初始化固件保留策略：FIRMWARE_RETENTION_KEEP 配置每个通道保留的最新版本数，未配置时不自动清理；
+// FIRMWARE_RETENTION_INTERVAL 配置后台清理的间隔，默认 24 小时
+func initFirmwareRetention() {
+	firmwareRetentionKeep = int(envInt64("FIRMWARE_RETENTION_KEEP", 0))
+	if firmwareRetentionKeep <= 0 {
+		log.Println("未开启固件保留策略")
+		return
+	}
+
+	interval, err := time.ParseDuration(os.Getenv("FIRMWARE_RETENTION_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = 24 * time.Hour
+	}
+	go func() {
+		for range time.Tick(interval) {
+			for _, repo := range firmwareRepositories() {
+				applyRepositoryRetention(repo)
+			}
+		}
+	}()
+}
+
+// applyRepositoryRetention 对仓库中的所有产品执行保留策略
+func applyRepositoryRetention(repo *firmwareRepository) {
+	products, err := listFirmwareProducts(context.Background(), repo)
+	if err != nil {
+		log.Printf("列出仓库 %s 的固件产品失败: %v", repo.Name, err)
+		return
+	}
+	for _, productName := range products {
+		result, err := applyFirmwareRetention(context.Background(), repo, productName, firmwareRetentionKeep, false)
+		if err != nil {
+			log.Printf("执行产品 %s 的固件保留策略失败: %v", productName, err)
+			continue
+		}
+		for _, item := range result.Deleted {
+			log.Printf("保留策略删除固件 %s %s (%s)", productName, item.Version, item.Channel)
+		}
+	}
+}
+
+// firmwareProtectionReason 返回固件不能被保留策略删除的原因，可以删除时返回空字符串：
+// 固定的固件、设备上报正在运行或被下发为目标的版本、发布中或暂停的分阶段发布版本都会保留
+func firmwareProtectionReason(entry firmwareEntry, checkins []DeviceCheckin, policies map[string]RolloutPolicy) string {
+	if entry.Info.Pinned {
+		return "pinned"
+	}
+	if policy, ok := policies[entry.Info.ID]; ok && (policy.Status == rolloutStatusActive || policy.Status == rolloutStatusPaused) {
+		return "rollout " + policy.Status
+	}
+	for _, checkin := range checkins {
+		if checkin.Edition != "" && checkin.Edition != entry.Desc.Edition {
+			continue
+		}
+		if version, err := parseSemVer(checkin.CurrentVersion); err == nil && version.Compare(entry.Version) == 0 {
+			return "reported by devices"
+		}
+		if version, err := parseSemVer(checkin.TargetVersion); err == nil && version.Compare(entry.Version) == 0 {
+			return "offered to devices"
+		}
+	}
+	return ""
+}
+
+// applyFirmwareRetention 对产品执行保留策略：每个通道、版本类型保留最新的 keep 个版本，
+// 其余没有保护原因的固件从目录和存储中删除；删除后会使保留的旧版本无法升级到最新版本的中间版本也会保留。
+// dryRun 时只返回将要删除的固件
+func applyFirmwareRetention(ctx context.Context, repo *firmwareRepository, productName string, keep int, dryRun bool) (*FirmwareRetentionResult, error) {
+	result := &FirmwareRetentionResult{
+		ProductName: productName,
+		Keep:        keep,
+		DryRun:      dryRun,
+		Deleted:     []FirmwareRetentionItem{},
+		Protected:   []FirmwareRetentionItem{},
+	}
+
+	entries, err := listFirmwareEntries(repo, productName, firmwareQuery{})
+	if err != nil {
+		return nil, err
+	}
+	policies, err := loadRollouts(ctx, repo, productName)
+	if err != nil {
+		return nil, err
+	}
+	checkins := listDeviceCheckins(repo, productName)
+
+	// 从新到旧计数，每个 通道/版本类型 分别保留
+	kept := make(map[string]int)
+	removed := make([]bool, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		channel := firmwareChannelOf(entry.Info)
+		group := channel + "/" + entry.Desc.Edition
+		if kept[group] < keep {
+			kept[group]++
+			continue
+		}
+
+		item := FirmwareRetentionItem{
+			ID:      entry.Info.ID,
+			Version: entry.Desc.DisplayVersion(),
+			Channel: channel,
+		}
+		reason := firmwareProtectionReason(entry, checkins, policies)
+		if reason == "" && breaksUpgradePath(entries, removed, i) {
+			reason = "required upgrade hop"
+		}
+		if reason != "" {
+			item.Reason = reason
+			result.Protected = append(result.Protected, item)
+			continue
+		}
+
+		item.Reason = fmt.Sprintf("older than latest %d versions", keep)
+		if !dryRun {
+			if err := deleteFirmwareInfo(repo, entry.Info.ID, productName); err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", item.Version, err))
+				continue
+			}
+		}
+		removed[i] = true
+		result.Deleted = append(result.Deleted, item)
+	}
+	return result, nil
+}
+
+// breaksUpgradePath 判断在已删除 removed 的基础上再删除 entries[candidate] 后，
+// 是否有保留的旧版本原本可以升级、之后却无法升级到同一版本类型在某个通道中的最新版本
+// （例如新版本的 min_version 或 compatible_from 要求先升级到该版本）
+func breaksUpgradePath(entries []firmwareEntry, removed []bool, candidate int) bool {
+	edition := entries[candidate].Desc.Edition
+	for channel := range firmwareChannelRank {
+		var before, after []firmwareEntry
+		for i, entry := range entries {
+			if removed[i] || entry.Desc.Edition != edition || !firmwareChannelVisible(firmwareChannelOf(entry.Info), channel) {
+				continue
+			}
+			before = append(before, entry)
+			if i != candidate {
+				after = append(after, entry)
+			}
+		}
+		// 该通道看不到这个固件，或删除后没有其他版本
+		if len(after) == len(before) || len(after) == 0 {
+			continue
+		}
+
+		latest := after[len(after)-1]
+		latestBefore := len(before) - 1
+		for before[latestBefore].Info.ID != latest.Info.ID {
+			latestBefore--
+		}
+		for _, entry := range after[:len(after)-1] {
+			if entry.Version.Compare(latest.Version) >= 0 {
+				continue
+			}
+			_, reachedBefore := upgradeReachability(before, entry.Version)
+			_, reachedAfter := upgradeReachability(after, entry.Version)
+			if reachedBefore[latestBefore] && !reachedAfter[len(after)-1] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setFirmwarePinned 固定或取消固定固件
+func setFirmwarePinned(ctx context.Context, repo *firmwareRepository, productName, id string, pinned bool, pinnedBy string) (*FirmwareInfo, error) {
+	var updated *FirmwareInfo
+	err := updateFirmwareCatalog(ctx, repo, productName, func(firmwareList []FirmwareInfo) ([]FirmwareInfo, error) {
+		updated = nil
+		for i := range firmwareList {
+			if firmwareList[i].ID != id {
+				continue
+			}
+			firmwareList[i].Pinned = pinned
+			firmwareList[i].PinnedBy = ""
+			firmwareList[i].PinnedAt = ""
+			if pinned {
+				firmwareList[i].PinnedBy = pinnedBy
+				firmwareList[i].PinnedAt = firmwareTimestamp()
+			}
+			firmwareCopy := firmwareList[i]
+			updated = &firmwareCopy
+			return firmwareList, nil
+		}
+		return nil, errFirmwareNotFound
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+func pinFirmwareHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	type PinFirmwareRequest struct {
+		Id          string `json:"id"`
+		ProductName string `json:"product_name"`
+		Pinned      bool   `json:"pinned"`
+		PinnedBy    string `json:"pinned_by"`
+	}
+
+	var request PinFirmwareRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.Id == "" || request.ProductName == "" {
+		http.Error(w, "id and product_name are required", http.StatusBadRequest)
+		return
+	}
+
+	request.ProductName, err = resolveFirmwareProductName(r.Context(), repo, request.ProductName)
+	if err != nil {
+		http.Error(w, err.Error(), firmwareProductErrorStatus(err))
+		return
+	}
+
+	updated, err := setFirmwarePinned(r.Context(), repo, request.ProductName, request.Id, request.Pinned, request.PinnedBy)
+	if errors.Is(err, errFirmwareNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.MarshalIndent(updated, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResponse)
+}
+
+// runFirmwareRetentionHandler 立即执行保留策略，product_name 为空时处理所有产品，dry_run 时只返回将要删除的固件
+func runFirmwareRetentionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	type RunFirmwareRetentionRequest struct {
+		ProductName string `json:"product_name"`
+		Keep        int    `json:"keep"` // 默认使用 FIRMWARE_RETENTION_KEEP
+		DryRun      bool   `json:"dry_run"`
+	}
+
+	var request RunFirmwareRetentionRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.Keep <= 0 {
+		request.Keep = firmwareRetentionKeep
+	}
+	if request.Keep <= 0 {
+		http.Error(w, "keep is required when FIRMWARE_RETENTION_KEEP is not set", http.StatusBadRequest)
+		return
+	}
+
+	if request.ProductName != "" {
+		request.ProductName, err = resolveFirmwareProductName(r.Context(), repo, request.ProductName)
+		if err != nil {
+			http.Error(w, err.Error(), firmwareProductErrorStatus(err))
+			return
+		}
+	}
+
+	products := []string{request.ProductName}
+	if request.ProductName == "" {
+		products, err = listFirmwareProducts(r.Context(), repo)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	results := []FirmwareRetentionResult{}
+	for _, productName := range products {
+		result, err := applyFirmwareRetention(r.Context(), repo, productName, request.Keep, request.DryRun)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		results = append(results, *result)
+	}
+
+	jsonResponse, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResponse)
+}
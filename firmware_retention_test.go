@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// 超出保留数量的中间版本是保留的旧版本升级到最新版本的必经之路时不能删除
+func TestApplyFirmwareRetentionRequiredHop(t *testing.T) {
+	fake := newFakeMinio(t)
+	repo := defaultFirmwareRepo
+
+	publish := func(version, minVersion string, pinned bool) {
+		desc := FirmwareDescriptor{ProductName: "NXT2204", Edition: "Std", Version: version, BuildDate: "20240101"}
+		info := FirmwareInfo{
+			ProductName: desc.ProductName,
+			Version:     desc.Version,
+			Edition:     desc.Edition,
+			BuildDate:   desc.BuildDate,
+			ObjectKey:   desc.ObjectKey(repo),
+			Pinned:      pinned,
+		}
+		info.MinVersion = minVersion
+		if _, err := appendFirmwareInfo(repo, info, true); err != nil {
+			t.Fatal(err)
+		}
+		fake.put(repo.Bucket, info.ObjectKey, []byte(version))
+	}
+	publish("1.0.0", "", true) // 固定的旧版本，设备可能仍在运行
+	publish("1.1.0", "", false)
+	publish("1.2.0", "", false)
+	publish("2.0.0", "1.2.0", false) // 只能从 1.2.0 及以上升级
+
+	for _, dryRun := range []bool{true, false} {
+		result, err := applyFirmwareRetention(context.Background(), repo, "NXT2204", 1, dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reasons := make(map[string]string)
+		for _, item := range result.Protected {
+			reasons[item.Version] = item.Reason
+		}
+		if len(result.Deleted) != 1 || result.Deleted[0].Version != "[Std]_V1.1.0_20240101" {
+			t.Errorf("dry run %v: deleted %+v, want only 1.1.0", dryRun, result.Deleted)
+		}
+		if reasons["[Std]_V1.2.0_20240101"] != "required upgrade hop" || reasons["[Std]_V1.0.0_20240101"] != "pinned" {
+			t.Errorf("dry run %v: protected %+v", dryRun, result.Protected)
+		}
+	}
+	checkTestCatalog(t, readTestCatalog(t, fake, repo, "NXT2204"), 3)
+}
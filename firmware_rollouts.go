@@ -0,0 +1,584 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// 发布策略状态
+const (
+	rolloutStatusActive    = "active"
+	rolloutStatusPaused    = "paused"
+	rolloutStatusAborted   = "aborted"
+	rolloutStatusCompleted = "completed"
+)
+
+// 发布策略状态不允许当前操作时返回
+var errInvalidRolloutTransition = errors.New("invalid rollout status transition")
+
+// 固件目录中不存在指定固件的发布策略
+var errRolloutNotFound = errors.New("rollout not found")
+
+// 发布策略存放在产品目录下，与固件目录使用同一套乐观锁写入
+func getRolloutObjectKey(repo *firmwareRepository, productName string) string {
+	return repo.key(productName, "rollouts.json")
+}
+
+// loadRollouts 读取产品的全部发布策略，按固件 ID 索引
+func loadRollouts(ctx context.Context, repo *firmwareRepository, productName string) (map[string]RolloutPolicy, error) {
+	policies, _, err := loadFirmwareObject[[]RolloutPolicy](ctx, repo, getRolloutObjectKey(repo, productName))
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]RolloutPolicy, len(policies))
+	for _, policy := range policies {
+		result[policy.FirmwareID] = policy
+	}
+	return result, nil
+}
+
+func updateRollouts(ctx context.Context, repo *firmwareRepository, productName string, update func([]RolloutPolicy) ([]RolloutPolicy, error)) error {
+	return updateFirmwareObject(ctx, repo, getRolloutObjectKey(repo, productName), func(policies []RolloutPolicy) ([]RolloutPolicy, error) {
+		if policies == nil {
+			policies = []RolloutPolicy{}
+		}
+		return update(policies)
+	})
+}
+
+// setRolloutPolicy 创建或修改固件的发布策略；新策略状态为 active，已有策略保持原状态
+func setRolloutPolicy(ctx context.Context, repo *firmwareRepository, policy RolloutPolicy) (*RolloutPolicy, error) {
+	if policy.Percentage < 0 || policy.Percentage > 100 {
+		return nil, fmt.Errorf("percentage must be between 0 and 100")
+	}
+	if policy.StartTime != "" {
+		if _, err := time.Parse(time.RFC3339, policy.StartTime); err != nil {
+			return nil, fmt.Errorf("invalid start_time %q, must be RFC3339", policy.StartTime)
+		}
+	}
+
+	// 发布策略必须对应目录中已有的固件
+	firmwareList, err := getFirmwareList(repo, policy.ProductName)
+	if err != nil {
+		return nil, err
+	}
+	var firmware *FirmwareInfo
+	for i := range firmwareList {
+		if firmwareList[i].ID == policy.FirmwareID {
+			firmware = &firmwareList[i]
+			break
+		}
+	}
+	if firmware == nil {
+		return nil, errFirmwareNotFound
+	}
+	policy.Version = firmware.Version
+	policy.UpdatedAt = firmwareTimestamp()
+
+	err = updateRollouts(ctx, repo, policy.ProductName, func(policies []RolloutPolicy) ([]RolloutPolicy, error) {
+		for i := range policies {
+			if policies[i].FirmwareID == policy.FirmwareID {
+				policy.Status = policies[i].Status
+				policies[i] = policy
+				return policies, nil
+			}
+		}
+		policy.Status = rolloutStatusActive
+		return append(policies, policy), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// 各操作允许的原状态和目标状态
+var rolloutTransitions = map[string]struct {
+	from []string
+	to   string
+}{
+	"pause":    {from: []string{rolloutStatusActive}, to: rolloutStatusPaused},
+	"resume":   {from: []string{rolloutStatusPaused, rolloutStatusAborted}, to: rolloutStatusActive},
+	"abort":    {from: []string{rolloutStatusActive, rolloutStatusPaused}, to: rolloutStatusAborted},
+	"complete": {from: []string{rolloutStatusActive, rolloutStatusPaused}, to: rolloutStatusCompleted},
+}
+
+// setRolloutStatus 暂停、恢复、中止或完成发布
+func setRolloutStatus(ctx context.Context, repo *firmwareRepository, productName, firmwareID, action, updatedBy string) (*RolloutPolicy, error) {
+	transition, ok := rolloutTransitions[action]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown action %q, must be one of pause, resume, abort, complete", errInvalidRolloutTransition, action)
+	}
+
+	var updated *RolloutPolicy
+	err := updateRollouts(ctx, repo, productName, func(policies []RolloutPolicy) ([]RolloutPolicy, error) {
+		for i := range policies {
+			if policies[i].FirmwareID != firmwareID {
+				continue
+			}
+
+			allowed := false
+			for _, status := range transition.from {
+				if policies[i].Status == status {
+					allowed = true
+				}
+			}
+			if !allowed {
+				return nil, fmt.Errorf("%w: cannot %s a rollout that is %s", errInvalidRolloutTransition, action, policies[i].Status)
+			}
+
+			policies[i].Status = transition.to
+			if transition.to == rolloutStatusCompleted {
+				policies[i].Percentage = 100
+			}
+			policies[i].UpdatedBy = updatedBy
+			policies[i].UpdatedAt = firmwareTimestamp()
+
+			policyCopy := policies[i]
+			updated = &policyCopy
+			return policies, nil
+		}
+		return nil, errRolloutNotFound
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// removeRolloutPolicy 删除固件的发布策略，固件删除时调用
+func removeRolloutPolicy(ctx context.Context, repo *firmwareRepository, productName, firmwareID string) error {
+	return updateRollouts(ctx, repo, productName, func(policies []RolloutPolicy) ([]RolloutPolicy, error) {
+		for i := range policies {
+			if policies[i].FirmwareID == firmwareID {
+				return append(policies[:i], policies[i+1:]...), nil
+			}
+		}
+		return nil, errCatalogUnchanged
+	})
+}
+
+// rolloutBucket 将设备确定性地映射到 [0, 100) 的分桶：
+// 对 product:version:deviceID 取 SHA-256，同一版本下提高百分比时已命中的设备保持命中
+func rolloutBucket(productName, version, deviceID string) int {
+	sum := sha256.Sum256([]byte(productName + ":" + version + ":" + deviceID))
+	return int(binary.BigEndian.Uint64(sum[:8]) % 100)
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateRollout 判断设备是否可以获得发布策略对应的固件，并返回原因。
+// 暂停的发布不再扩大范围，只有已经运行该版本的设备保持不变；中止的发布对所有设备不可见，设备回退到上一版本
+func evaluateRollout(policy RolloutPolicy, deviceID string, runningThisVersion bool, now time.Time) (bool, string) {
+	switch policy.Status {
+	case rolloutStatusCompleted:
+		return true, "rollout completed"
+	case rolloutStatusAborted:
+		return false, "rollout aborted"
+	case rolloutStatusPaused:
+		if runningThisVersion {
+			return true, "rollout paused, device already running this version"
+		}
+		return false, "rollout paused"
+	}
+
+	if containsString(policy.DenyList, deviceID) {
+		return false, "device in deny list"
+	}
+	if containsString(policy.AllowList, deviceID) {
+		return true, "device in allow list"
+	}
+	if policy.StartTime != "" {
+		start, err := time.Parse(time.RFC3339, policy.StartTime)
+		if err == nil && now.Before(start) {
+			return false, "rollout not started"
+		}
+	}
+
+	bucket := rolloutBucket(policy.ProductName, policy.Version, deviceID)
+	if bucket < policy.Percentage {
+		return true, fmt.Sprintf("device bucket %d within rollout percentage %d", bucket, policy.Percentage)
+	}
+	return false, fmt.Sprintf("device bucket %d outside rollout percentage %d", bucket, policy.Percentage)
+}
+
+// 设备查询固件时上报的信息
+type firmwareDevice struct {
+	ProductName      string
+	DeviceID         string
+	Channel          string
+	Edition          string
+	CurrentVersion   string
+	HardwareRevision string // 为空时不按硬件版本过滤
+}
+
+// resolveDeviceFirmware 计算设备应运行的固件：在设备通道可见、设备硬件支持的固件中从新到旧查找，
+// 选择第一个没有发布策略或发布策略命中该设备、且可以从当前版本升级到达的版本；
+// 需要经过中间版本时返回路径上的第一个固件
+func resolveDeviceFirmware(ctx context.Context, repo *firmwareRepository, device firmwareDevice) (*DeviceFirmwareDecision, error) {
+	entries, err := listFirmwareEntries(repo, device.ProductName, firmwareQuery{
+		Edition:          device.Edition,
+		Channel:          device.Channel,
+		HardwareRevision: device.HardwareRevision,
+	})
+	if err != nil {
+		return nil, err
+	}
+	policies, err := loadRollouts(ctx, repo, device.ProductName)
+	if err != nil {
+		return nil, err
+	}
+
+	decision := &DeviceFirmwareDecision{
+		ProductName: device.ProductName,
+		DeviceID:    device.DeviceID,
+		Channel:     device.Channel,
+	}
+
+	// 校验失败的镜像不下发给设备，也不作为升级路径的中间版本
+	var usable []firmwareEntry
+	for _, entry := range entries {
+		if status := entry.Info.IntegrityStatus; status == integrityStatusMismatch || status == integrityStatusMissing {
+			decision.Skipped = append(decision.Skipped, fmt.Sprintf("%s: integrity %s", entry.Desc.DisplayVersion(), status))
+			continue
+		}
+		usable = append(usable, entry)
+	}
+
+	// 设备上报的当前版本，无法解析时视为未知，不检查升级路径
+	current, currentErr := parseSemVer(device.CurrentVersion)
+	var parents []int
+	var reached []bool
+	if currentErr == nil {
+		parents, reached = upgradeReachability(usable, current)
+	}
+
+	now := time.Now()
+	for i := len(usable) - 1; i >= 0; i-- {
+		entry := usable[i]
+
+		// 升级需要有兼容的升级路径，当前版本和回退版本直接安装
+		path := []firmwareEntry{entry}
+		if currentErr == nil && entry.Version.Compare(current) > 0 {
+			if !reached[i] {
+				decision.Skipped = append(decision.Skipped, fmt.Sprintf("%s: no upgrade path from %s", entry.Desc.DisplayVersion(), device.CurrentVersion))
+				continue
+			}
+			path = upgradePathTo(usable, parents, i)
+		}
+
+		policy, ok := policies[entry.Info.ID]
+		if !ok {
+			decision.Reason = "no rollout policy"
+		} else {
+			running := currentErr == nil && current.Compare(entry.Version) == 0
+			eligible, reason := evaluateRollout(policy, device.DeviceID, running, now)
+			if !eligible {
+				decision.Skipped = append(decision.Skipped, fmt.Sprintf("%s: %s", entry.Desc.DisplayVersion(), reason))
+				continue
+			}
+			decision.Rollout = policy.Status
+			decision.Reason = reason
+		}
+
+		info := path[0].Info
+		decision.Firmware = &info
+		decision.Version = path[0].Desc.DisplayVersion()
+		if len(path) > 1 {
+			decision.UpgradePath = upgradePathVersions(path)
+		}
+		return decision, nil
+	}
+
+	decision.Reason = "no firmware available"
+	return decision, nil
+}
+
+func setFirmwareRolloutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	type SetFirmwareRolloutRequest struct {
+		ProductName string   `json:"product_name"`
+		FirmwareID  string   `json:"firmware_id"`
+		Percentage  int      `json:"percentage"`
+		AllowList   []string `json:"allow_list"`
+		DenyList    []string `json:"deny_list"`
+		StartTime   string   `json:"start_time"`
+		UpdatedBy   string   `json:"updated_by"`
+	}
+
+	var request SetFirmwareRolloutRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.ProductName == "" || request.FirmwareID == "" {
+		http.Error(w, "product_name and firmware_id are required", http.StatusBadRequest)
+		return
+	}
+
+	// 归档的产品不能再修改固件
+	product, err := writableFirmwareProduct(r.Context(), repo, request.ProductName)
+	if err != nil {
+		http.Error(w, err.Error(), firmwareProductErrorStatus(err))
+		return
+	}
+	request.ProductName = product.Name
+
+	policy, err := setRolloutPolicy(r.Context(), repo, RolloutPolicy{
+		FirmwareID:  request.FirmwareID,
+		ProductName: request.ProductName,
+		Percentage:  request.Percentage,
+		AllowList:   request.AllowList,
+		DenyList:    request.DenyList,
+		StartTime:   request.StartTime,
+		UpdatedBy:   request.UpdatedBy,
+	})
+	if errors.Is(err, errFirmwareNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	jsonResponse, err := json.MarshalIndent(policy, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResponse)
+}
+
+// setFirmwareRolloutStatusHandler 暂停（pause）、恢复（resume）、中止（abort）或完成（complete）发布
+func setFirmwareRolloutStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	type SetFirmwareRolloutStatusRequest struct {
+		ProductName string `json:"product_name"`
+		FirmwareID  string `json:"firmware_id"`
+		Action      string `json:"action"`
+		UpdatedBy   string `json:"updated_by"`
+	}
+
+	var request SetFirmwareRolloutStatusRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// 归档的产品不能再修改固件
+	product, err := writableFirmwareProduct(r.Context(), repo, request.ProductName)
+	if err != nil {
+		http.Error(w, err.Error(), firmwareProductErrorStatus(err))
+		return
+	}
+	request.ProductName = product.Name
+
+	policy, err := setRolloutStatus(r.Context(), repo, request.ProductName, request.FirmwareID, request.Action, request.UpdatedBy)
+	if errors.Is(err, errRolloutNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errInvalidRolloutTransition) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.MarshalIndent(policy, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResponse)
+}
+
+func getFirmwareRolloutsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	type GetFirmwareRolloutsRequest struct {
+		ProductName string `json:"product_name"`
+	}
+
+	var request GetFirmwareRolloutsRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	request.ProductName, err = resolveFirmwareProductName(r.Context(), repo, request.ProductName)
+	if err != nil {
+		http.Error(w, err.Error(), firmwareProductErrorStatus(err))
+		return
+	}
+
+	policies, _, err := loadFirmwareObject[[]RolloutPolicy](r.Context(), repo, getRolloutObjectKey(repo, request.ProductName))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if policies == nil {
+		policies = []RolloutPolicy{}
+	}
+
+	jsonResponse, err := json.MarshalIndent(policies, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResponse)
+}
+
+// resolveDeviceFirmwareHandler 设备查询自己应运行的固件
+func resolveDeviceFirmwareHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	type ResolveDeviceFirmwareRequest struct {
+		ProductName      string `json:"product_name"`
+		DeviceID         string `json:"device_id"`
+		Channel          string `json:"channel"` // 默认 stable
+		Edition          string `json:"edition"`
+		CurrentVersion   string `json:"current_version"`
+		HardwareRevision string `json:"hardware_revision"`
+	}
+
+	var request ResolveDeviceFirmwareRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.ProductName == "" || request.DeviceID == "" {
+		http.Error(w, "product_name and device_id are required", http.StatusBadRequest)
+		return
+	}
+
+	request.ProductName, err = resolveFirmwareProductName(r.Context(), repo, request.ProductName)
+	if err != nil {
+		http.Error(w, err.Error(), firmwareProductErrorStatus(err))
+		return
+	}
+	if request.Channel == "" {
+		request.Channel = firmwareChannelStable
+	}
+	channel, err := normalizeFirmwareChannel(request.Channel)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	decision, err := resolveDeviceFirmware(r.Context(), repo, firmwareDevice{
+		ProductName:      request.ProductName,
+		DeviceID:         request.DeviceID,
+		Channel:          channel,
+		Edition:          request.Edition,
+		CurrentVersion:   request.CurrentVersion,
+		HardwareRevision: request.HardwareRevision,
+	})
+	if err != nil {
+		log.Printf("计算设备 %s 的固件失败: %v", request.DeviceID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.MarshalIndent(decision, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResponse)
+}
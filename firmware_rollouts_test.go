@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// 同一设备多次计算得到相同分桶，且分桶在 [0, 100) 范围内
+func TestRolloutBucketDeterministic(t *testing.T) {
+	tests := []struct {
+		productName, version, deviceID string
+	}{
+		{"NXT2204", "1.0.0", "device-0001"},
+		{"NXT2204", "1.0.0", ""},
+		{"NXT2204", "2.3.4-rc.1", "AA:BB:CC:DD:EE:FF"},
+		{"NXT3300", "1.0.0", "device-0001"},
+	}
+	for _, tt := range tests {
+		bucket := rolloutBucket(tt.productName, tt.version, tt.deviceID)
+		if bucket < 0 || bucket >= 100 {
+			t.Errorf("rolloutBucket(%q, %q, %q) = %d, out of range", tt.productName, tt.version, tt.deviceID, bucket)
+		}
+		for i := 0; i < 3; i++ {
+			if got := rolloutBucket(tt.productName, tt.version, tt.deviceID); got != bucket {
+				t.Errorf("rolloutBucket(%q, %q, %q) = %d, then %d", tt.productName, tt.version, tt.deviceID, bucket, got)
+			}
+		}
+	}
+}
+
+// 大量设备应大致均匀地分布到各个分桶，提高百分比时命中设备的比例随之增长
+func TestRolloutBucketDistribution(t *testing.T) {
+	const devices = 100000
+	counts := make([]int, 100)
+	for i := 0; i < devices; i++ {
+		counts[rolloutBucket("NXT2204", "1.0.0", fmt.Sprintf("device-%06d", i))]++
+	}
+
+	// 每个分桶期望 1000 台，允许 ±20% 的偏差（约 6 个标准差）
+	for bucket, count := range counts {
+		if count < 800 || count > 1200 {
+			t.Errorf("bucket %d has %d devices, want about %d", bucket, count, devices/100)
+		}
+	}
+
+	for _, percentage := range []int{1, 5, 10, 25, 50, 100} {
+		hit := 0
+		for bucket := 0; bucket < percentage; bucket++ {
+			hit += counts[bucket]
+		}
+		want := devices * percentage / 100
+		if diff := hit - want; diff < -want/10-200 || diff > want/10+200 {
+			t.Errorf("%d%% rollout reaches %d devices, want about %d", percentage, hit, want)
+		}
+	}
+}
+
+// 不同版本的发布使用独立的分桶，避免每次都是同一批设备先升级
+func TestRolloutBucketIndependentPerVersion(t *testing.T) {
+	const devices = 10000
+	same := 0
+	for i := 0; i < devices; i++ {
+		deviceID := fmt.Sprintf("device-%06d", i)
+		if rolloutBucket("NXT2204", "1.0.0", deviceID) == rolloutBucket("NXT2204", "1.1.0", deviceID) {
+			same++
+		}
+	}
+	// 相互独立时约 1% 的设备分桶相同
+	if same > devices/20 {
+		t.Errorf("%d of %d devices share a bucket across versions", same, devices)
+	}
+}
+
+func TestEvaluateRollout(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	const deviceID = "device-0001"
+	bucket := rolloutBucket("NXT2204", "1.1.0", deviceID)
+	policy := func(status string, percentage int) RolloutPolicy {
+		return RolloutPolicy{ProductName: "NXT2204", Version: "1.1.0", Percentage: percentage, Status: status}
+	}
+	withLists := func(p RolloutPolicy, allow, deny []string) RolloutPolicy {
+		p.AllowList, p.DenyList = allow, deny
+		return p
+	}
+	withStart := func(p RolloutPolicy, start time.Time) RolloutPolicy {
+		p.StartTime = start.Format(time.RFC3339)
+		return p
+	}
+
+	tests := []struct {
+		name    string
+		policy  RolloutPolicy
+		running bool
+		want    bool
+	}{
+		{"completed", policy(rolloutStatusCompleted, 0), false, true},
+		{"aborted", policy(rolloutStatusAborted, 100), false, false},
+		{"aborted while running", policy(rolloutStatusAborted, 100), true, false},
+		{"paused", policy(rolloutStatusPaused, 100), false, false},
+		{"paused while running", policy(rolloutStatusPaused, 0), true, true},
+		{"inside percentage", policy(rolloutStatusActive, bucket+1), false, true},
+		{"outside percentage", policy(rolloutStatusActive, bucket), false, false},
+		{"zero percent", policy(rolloutStatusActive, 0), false, false},
+		{"full rollout", policy(rolloutStatusActive, 100), false, true},
+		{"allow list", withLists(policy(rolloutStatusActive, 0), []string{deviceID}, nil), false, true},
+		{"deny list", withLists(policy(rolloutStatusActive, 100), nil, []string{deviceID}), false, false},
+		{"deny list wins over allow list", withLists(policy(rolloutStatusActive, 100), []string{deviceID}, []string{deviceID}), false, false},
+		{"other device in lists", withLists(policy(rolloutStatusActive, 0), []string{"device-0002"}, nil), false, false},
+		{"not started", withStart(policy(rolloutStatusActive, 100), now.Add(time.Hour)), false, false},
+		{"started", withStart(policy(rolloutStatusActive, 100), now.Add(-time.Hour)), false, true},
+		{"allow list before start", withStart(withLists(policy(rolloutStatusActive, 0), []string{deviceID}, nil), now.Add(time.Hour)), false, true},
+	}
+	for _, tt := range tests {
+		got, reason := evaluateRollout(tt.policy, deviceID, tt.running, now)
+		if got != tt.want {
+			t.Errorf("%s: evaluateRollout = %v (%s), want %v", tt.name, got, reason, tt.want)
+		}
+	}
+}
+
+// 发布策略不命中设备、暂停或中止时，设备回退到上一个可用版本
+func TestResolveDeviceFirmware(t *testing.T) {
+	fake := newFakeMinio(t)
+	repo := defaultFirmwareRepo
+	const deviceID = "device-0001"
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		info, err := appendFirmwareInfo(repo, newTestFirmwareInfo(repo, "NXT2204", i), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, info.ID)
+	}
+	previous, latest := "[Std]_V1.0.0_20240101", "[Std]_V1.0.1_20240101"
+	bucket := rolloutBucket("NXT2204", "1.0.1", deviceID)
+
+	tests := []struct {
+		name    string
+		policy  *RolloutPolicy // 最新版本的发布策略，nil 表示没有策略
+		current string
+		want    string
+	}{
+		{"no policy", nil, "1.0.0", latest},
+		{"inside percentage", &RolloutPolicy{Percentage: bucket + 1, Status: rolloutStatusActive}, "1.0.0", latest},
+		{"outside percentage", &RolloutPolicy{Percentage: bucket, Status: rolloutStatusActive}, "1.0.0", previous},
+		{"allow list", &RolloutPolicy{AllowList: []string{deviceID}, Status: rolloutStatusActive}, "1.0.0", latest},
+		{"deny list", &RolloutPolicy{Percentage: 100, DenyList: []string{deviceID}, Status: rolloutStatusActive}, "1.0.0", previous},
+		{"not started", &RolloutPolicy{Percentage: 100, StartTime: time.Now().Add(time.Hour).Format(time.RFC3339), Status: rolloutStatusActive}, "1.0.0", previous},
+		{"paused", &RolloutPolicy{Percentage: 100, Status: rolloutStatusPaused}, "1.0.0", previous},
+		{"paused while running", &RolloutPolicy{Percentage: 100, Status: rolloutStatusPaused}, "1.0.1", latest},
+		{"aborted while running", &RolloutPolicy{Percentage: 100, Status: rolloutStatusAborted}, "1.0.1", previous},
+		{"completed", &RolloutPolicy{Status: rolloutStatusCompleted}, "1.0.0", latest},
+	}
+	for _, tt := range tests {
+		policies := []RolloutPolicy{}
+		if tt.policy != nil {
+			policy := *tt.policy
+			policy.FirmwareID, policy.ProductName, policy.Version = ids[1], "NXT2204", "1.0.1"
+			policies = append(policies, policy)
+		}
+		data, _ := json.Marshal(policies)
+		fake.put(repo.Bucket, getRolloutObjectKey(repo, "NXT2204"), data)
+
+		decision, err := resolveDeviceFirmware(context.Background(), repo, firmwareDevice{
+			ProductName:    "NXT2204",
+			DeviceID:       deviceID,
+			Channel:        firmwareChannelStable,
+			CurrentVersion: tt.current,
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if decision.Version != tt.want {
+			t.Errorf("%s: resolved %q (%s, skipped %v), want %q", tt.name, decision.Version, decision.Reason, decision.Skipped, tt.want)
+		}
+	}
+}
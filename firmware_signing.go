@@ -0,0 +1,564 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// 签名清单的 DSSE payloadType
+const firmwareManifestPayloadType = "application/vnd.nxt.firmware-manifest+json"
+
+// 签名清单与镜像存放在同一目录
+func firmwareManifestKey(objectKey string) string {
+	return objectKey + ".manifest.json"
+}
+
+// 签名校验失败或缺少签名时返回
+var errFirmwareSignature = errors.New("firmware signature rejected")
+
+// 本地签名密钥和受信任的发布者公钥
+type firmwareKey struct {
+	id        string
+	algorithm string
+	public    crypto.PublicKey
+	signer    crypto.Signer // 只有本地签名密钥才有
+}
+
+var (
+	firmwareSigningKey     *firmwareKey
+	firmwareTrustedKeys    = make(map[string]*firmwareKey)
+	firmwareSigningMu      sync.RWMutex
+	firmwareRequireSigning bool
+
+	// 是否默认接受清单未签名的离线固件包，单次导入也可以通过 allow_unsigned 表单字段允许
+	firmwareBundleAllowUnsigned bool
+)
+
+// 初始化固件签名：加载本地签名私钥和受信任的发布者公钥目录。
+// FIRMWARE_REQUIRE_SIGNATURE=true 时拒绝既没有有效发布者签名、又无法由本地密钥签名的固件；
+// 离线固件包的清单默认必须签名，FIRMWARE_BUNDLE_ALLOW_UNSIGNED=true 时接受未签名的清单
+func initFirmwareSigning() {
+	firmwareRequireSigning = os.Getenv("FIRMWARE_REQUIRE_SIGNATURE") == "true"
+	firmwareBundleAllowUnsigned = os.Getenv("FIRMWARE_BUNDLE_ALLOW_UNSIGNED") == "true"
+
+	keyFile := os.Getenv("FIRMWARE_SIGNING_KEY_FILE")
+	if keyFile == "" {
+		keyFile = "/data/minio-bridge/firmware-signing.pem"
+	}
+	key, err := loadFirmwareSigningKey(keyFile)
+	if err != nil {
+		log.Printf("未加载固件签名私钥 %s，不对固件签名: %v", keyFile, err)
+	}
+
+	trustedDir := os.Getenv("FIRMWARE_TRUSTED_KEYS_DIR")
+	if trustedDir == "" {
+		trustedDir = "/data/minio-bridge/trusted-keys"
+	}
+	trusted, err := loadFirmwareTrustedKeys(trustedDir)
+	if err != nil {
+		log.Printf("加载受信任的发布者公钥失败: %v", err)
+	}
+
+	firmwareSigningMu.Lock()
+	firmwareSigningKey = key
+	firmwareTrustedKeys = trusted
+	firmwareSigningMu.Unlock()
+
+	if key == nil && firmwareRequireSigning {
+		log.Println("FIRMWARE_REQUIRE_SIGNATURE 已开启但没有本地签名私钥，只接受带有效发布者签名的固件")
+	}
+}
+
+// 读取 PKCS#8 PEM 格式的 Ed25519 或 ECDSA 私钥
+func loadFirmwareSigningKey(path string) (*firmwareKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("不是 PEM 格式")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("不支持的私钥类型 %T", parsed)
+	}
+	algorithm, err := firmwareKeyAlgorithm(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	id, err := firmwareKeyID(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &firmwareKey{id: "bridge-" + id, algorithm: algorithm, public: signer.Public(), signer: signer}, nil
+}
+
+// 读取目录中 PKIX PEM 格式的公钥，密钥 ID 为去掉扩展名的文件名
+func loadFirmwareTrustedKeys(dir string) (map[string]*firmwareKey, error) {
+	keys := make(map[string]*firmwareKey)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return keys, nil
+	}
+	if err != nil {
+		return keys, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("读取公钥 %s 失败: %v", path, err)
+			continue
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			log.Printf("公钥 %s 不是 PEM 格式，已跳过", path)
+			continue
+		}
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			log.Printf("解析公钥 %s 失败: %v", path, err)
+			continue
+		}
+		algorithm, err := firmwareKeyAlgorithm(public)
+		if err != nil {
+			log.Printf("公钥 %s: %v", path, err)
+			continue
+		}
+		id := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		keys[id] = &firmwareKey{id: id, algorithm: algorithm, public: public}
+	}
+	return keys, nil
+}
+
+func firmwareKeyAlgorithm(public crypto.PublicKey) (string, error) {
+	switch key := public.(type) {
+	case ed25519.PublicKey:
+		return "ed25519", nil
+	case *ecdsa.PublicKey:
+		return "ecdsa-" + strings.ToLower(strings.ReplaceAll(key.Curve.Params().Name, "-", "")), nil
+	}
+	return "", fmt.Errorf("不支持的密钥类型 %T，只支持 Ed25519 和 ECDSA", public)
+}
+
+// 本地密钥的 ID 取公钥 DER 的 SHA-256 前 8 字节
+func firmwareKeyID(public crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// verifyDigestSignature 校验对 SHA-256 摘要的签名：Ed25519 直接对摘要签名，ECDSA 以摘要作为哈希值（ASN.1 编码）
+func verifyDigestSignature(public crypto.PublicKey, digest, sig []byte) bool {
+	switch key := public.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, digest, sig)
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, digest, sig)
+	}
+	return false
+}
+
+func signDigest(key *firmwareKey, digest []byte) ([]byte, error) {
+	if _, ok := key.public.(ed25519.PublicKey); ok {
+		return key.signer.Sign(rand.Reader, digest, crypto.Hash(0))
+	}
+	return key.signer.Sign(rand.Reader, digest, crypto.SHA256)
+}
+
+// DSSE 预认证编码：DSSEv1 SP LEN(type) SP type SP LEN(body) SP body
+func dssePAE(payloadType string, payload []byte) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "DSSEv1 %d %s %d ", len(payloadType), payloadType, len(payload))
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+// 清单签名：Ed25519 对 PAE 签名，ECDSA 对 PAE 的 SHA-256 签名
+func signManifest(key *firmwareKey, pae []byte) ([]byte, error) {
+	if _, ok := key.public.(ed25519.PublicKey); ok {
+		return key.signer.Sign(rand.Reader, pae, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(pae)
+	return key.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// decodeSignature 解析上传的签名文件，支持原始字节或 base64 文本
+func decodeSignature(data []byte) []byte {
+	text := strings.TrimSpace(string(data))
+	if decoded, err := base64.StdEncoding.DecodeString(text); err == nil && len(decoded) > 0 {
+		return decoded
+	}
+	return data
+}
+
+// signFirmwareImage 为镜像生成签名：有发布者签名时用受信任的公钥校验，
+// 没有时用本地私钥签名；两者都没有且要求签名时拒绝
+func signFirmwareImage(checksums FirmwareChecksums, publisherSignature []byte) ([]FirmwareSignature, error) {
+	digest, err := hex.DecodeString(checksums.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	firmwareSigningMu.RLock()
+	defer firmwareSigningMu.RUnlock()
+
+	if len(publisherSignature) > 0 {
+		sig := decodeSignature(publisherSignature)
+		for _, key := range firmwareTrustedKeys {
+			if verifyDigestSignature(key.public, digest, sig) {
+				return []FirmwareSignature{{KeyID: key.id, Sig: base64.StdEncoding.EncodeToString(sig)}}, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: signature does not match any trusted publisher key", errFirmwareSignature)
+	}
+
+	if firmwareSigningKey != nil {
+		sig, err := signDigest(firmwareSigningKey, digest)
+		if err != nil {
+			return nil, err
+		}
+		return []FirmwareSignature{{KeyID: firmwareSigningKey.id, Sig: base64.StdEncoding.EncodeToString(sig)}}, nil
+	}
+
+	if firmwareRequireSigning {
+		return nil, fmt.Errorf("%w: firmware must be uploaded with a detached signature", errFirmwareSignature)
+	}
+	return nil, nil
+}
+
+// trustedImageSignature 从其他网桥导出的镜像签名中找出受信任发布者的签名，
+// 用于导入时保留发布者签名；没有时返回 nil，由本地私钥重新签名
+func trustedImageSignature(signatures []FirmwareSignature) []byte {
+	firmwareSigningMu.RLock()
+	defer firmwareSigningMu.RUnlock()
+
+	for _, sig := range signatures {
+		if _, ok := firmwareTrustedKeys[sig.KeyID]; !ok {
+			continue
+		}
+		if decoded, err := base64.StdEncoding.DecodeString(sig.Sig); err == nil {
+			return decoded
+		}
+	}
+	return nil
+}
+
+// newFirmwareManifest 根据目录条目生成清单内容
+func newFirmwareManifest(repo *firmwareRepository, info FirmwareInfo, imageSignatures []FirmwareSignature) FirmwareManifest {
+	return FirmwareManifest{
+		FirmwareID:        info.ID,
+		ProductName:       info.ProductName,
+		Version:           info.Version,
+		Edition:           info.Edition,
+		BuildDate:         info.BuildDate,
+		FileType:          info.FileType,
+		Channel:           firmwareChannelOf(info),
+		ObjectKey:         firmwareObjectKey(repo, info),
+		Size:              info.Size,
+		SHA256:            info.SHA256,
+		Timestamp:         time.Now().UTC().Format(time.RFC3339),
+		MinVersion:        info.MinVersion,
+		CompatibleFrom:    info.CompatibleFrom,
+		HardwareRevisions: info.HardwareRevisions,
+		Mandatory:         info.Mandatory,
+		ImageSignatures:   imageSignatures,
+	}
+}
+
+// newSignedEnvelope 生成 DSSE 信封并用本地私钥签名，没有本地私钥时信封不带签名
+func newSignedEnvelope(payloadType string, payload []byte) (FirmwareManifestEnvelope, error) {
+	envelope := FirmwareManifestEnvelope{
+		PayloadType: payloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []FirmwareSignature{},
+	}
+
+	firmwareSigningMu.RLock()
+	key := firmwareSigningKey
+	firmwareSigningMu.RUnlock()
+	if key != nil {
+		sig, err := signManifest(key, dssePAE(payloadType, payload))
+		if err != nil {
+			return envelope, fmt.Errorf("签名清单失败: %v", err)
+		}
+		envelope.Signatures = append(envelope.Signatures, FirmwareSignature{KeyID: key.id, Sig: base64.StdEncoding.EncodeToString(sig)})
+	}
+	return envelope, nil
+}
+
+// verifyManifestSignature 校验 signManifest 生成的签名
+func verifyManifestSignature(public crypto.PublicKey, pae, sig []byte) bool {
+	if key, ok := public.(ed25519.PublicKey); ok {
+		return ed25519.Verify(key, pae, sig)
+	}
+	digest := sha256.Sum256(pae)
+	return verifyDigestSignature(public, digest[:], sig)
+}
+
+// verifyEnvelope 用本地签名公钥和受信任的发布者公钥校验 DSSE 信封，返回签名有效的密钥 ID 和 payload；
+// 信封没有签名时返回空的密钥 ID，有签名但都无法校验时返回 errFirmwareSignature
+func verifyEnvelope(envelope FirmwareManifestEnvelope, payloadType string) (string, []byte, error) {
+	if envelope.PayloadType != payloadType {
+		return "", nil, fmt.Errorf("unexpected payload type %q", envelope.PayloadType)
+	}
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return "", nil, fmt.Errorf("解析签名清单失败: %v", err)
+	}
+	if len(envelope.Signatures) == 0 {
+		return "", payload, nil
+	}
+
+	firmwareSigningMu.RLock()
+	defer firmwareSigningMu.RUnlock()
+
+	pae := dssePAE(payloadType, payload)
+	for _, signature := range envelope.Signatures {
+		key := firmwareTrustedKeys[signature.KeyID]
+		if key == nil && firmwareSigningKey != nil && firmwareSigningKey.id == signature.KeyID {
+			key = firmwareSigningKey
+		}
+		if key == nil {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(signature.Sig)
+		if err == nil && verifyManifestSignature(key.public, pae, sig) {
+			return key.id, payload, nil
+		}
+	}
+	return "", nil, fmt.Errorf("%w: manifest is not signed by a trusted key", errFirmwareSignature)
+}
+
+// writeFirmwareManifest 用本地私钥签名清单并写入存储；没有本地私钥时清单只携带镜像签名
+func writeFirmwareManifest(ctx context.Context, repo *firmwareRepository, objectKey string, manifest FirmwareManifest) (string, error) {
+	payload, err := json.Marshal(manifest)
+	if err != nil {
+		return "", fmt.Errorf("JSON 编码失败: %v", err)
+	}
+	envelope, err := newSignedEnvelope(firmwareManifestPayloadType, payload)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.MarshalIndent(envelope, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("JSON 编码失败: %v", err)
+	}
+	manifestKey := firmwareManifestKey(objectKey)
+	_, err = minioClient.PutObject(ctx, repo.Bucket, manifestKey, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/json",
+	})
+	if err != nil {
+		return "", fmt.Errorf("保存签名清单失败: %v", err)
+	}
+	return manifestKey, nil
+}
+
+// loadFirmwareManifest 读取签名清单信封及其内容
+func loadFirmwareManifest(ctx context.Context, repo *firmwareRepository, info FirmwareInfo) (*FirmwareManifestEnvelope, *FirmwareManifest, error) {
+	manifestKey := info.ManifestKey
+	if manifestKey == "" {
+		manifestKey = firmwareManifestKey(firmwareObjectKey(repo, info))
+	}
+	envelope, _, err := loadFirmwareObject[*FirmwareManifestEnvelope](ctx, repo, manifestKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if envelope == nil {
+		return nil, nil, fmt.Errorf("firmware %s has no manifest", info.ID)
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return nil, nil, fmt.Errorf("解析签名清单失败: %v", err)
+	}
+	var manifest FirmwareManifest
+	if err := json.Unmarshal(payload, &manifest); err != nil {
+		return nil, nil, fmt.Errorf("解析签名清单失败: %v", err)
+	}
+	return envelope, &manifest, nil
+}
+
+// resignFirmwareManifest 目录条目变化后（例如提升通道）重新生成并签名清单，保留原有的镜像签名
+func resignFirmwareManifest(ctx context.Context, repo *firmwareRepository, info FirmwareInfo) error {
+	var imageSignatures []FirmwareSignature
+	if _, manifest, err := loadFirmwareManifest(ctx, repo, info); err == nil {
+		imageSignatures = manifest.ImageSignatures
+	}
+	_, err := writeFirmwareManifest(ctx, repo, firmwareObjectKey(repo, info), newFirmwareManifest(repo, info, imageSignatures))
+	return err
+}
+
+// firmwareSigningKeys 返回本地签名公钥和受信任的发布者公钥
+func firmwareSigningKeys() ([]FirmwareSigningKey, error) {
+	firmwareSigningMu.RLock()
+	defer firmwareSigningMu.RUnlock()
+
+	var keys []*firmwareKey
+	roles := make(map[*firmwareKey]string)
+	if firmwareSigningKey != nil {
+		keys = append(keys, firmwareSigningKey)
+		roles[firmwareSigningKey] = "bridge"
+	}
+	var trusted []*firmwareKey
+	for _, key := range firmwareTrustedKeys {
+		trusted = append(trusted, key)
+	}
+	sort.Slice(trusted, func(i, j int) bool { return trusted[i].id < trusted[j].id })
+	for _, key := range trusted {
+		keys = append(keys, key)
+		roles[key] = "publisher"
+	}
+
+	result := []FirmwareSigningKey{}
+	for _, key := range keys {
+		der, err := x509.MarshalPKIXPublicKey(key.public)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, FirmwareSigningKey{
+			KeyID:     key.id,
+			Algorithm: key.algorithm,
+			Role:      roles[key],
+			PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		})
+	}
+	return result, nil
+}
+
+// getFirmwareManifestHandler 返回固件的签名清单信封，设备校验签名和哈希后再下载镜像
+func getFirmwareManifestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	type GetFirmwareManifestRequest struct {
+		Id          string `json:"id"`
+		ProductName string `json:"product_name"`
+	}
+
+	var request GetFirmwareManifestRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	request.ProductName, err = resolveFirmwareProductName(r.Context(), repo, request.ProductName)
+	if err != nil {
+		http.Error(w, err.Error(), firmwareProductErrorStatus(err))
+		return
+	}
+
+	firmwareList, err := getFirmwareList(repo, request.ProductName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var info *FirmwareInfo
+	for i := range firmwareList {
+		if firmwareList[i].ID == request.Id {
+			info = &firmwareList[i]
+			break
+		}
+	}
+	if info == nil {
+		http.Error(w, errFirmwareNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	envelope, _, err := loadFirmwareManifest(r.Context(), repo, *info)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	jsonResponse, err := json.MarshalIndent(envelope, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResponse)
+}
+
+// getFirmwareSigningKeysHandler 返回设备校验清单和镜像签名所需的公钥
+func getFirmwareSigningKeysHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "GET")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	keys, err := firmwareSigningKeys()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResponse)
+}
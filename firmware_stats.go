@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 固件访问记录：每次下载和检查更新追加一行 JSON 到按天分割的文件
+// {FIRMWARE_DATA_DIR}/access-log/access-YYYY-MM-DD.jsonl，文件只追加不修改，超过保留天数后整个删除
+const firmwareAccessLogDateLayout = "2006-01-02"
+
+// 访问记录类型和结果
+const (
+	firmwareAccessDownload = "download"
+	firmwareAccessCheck    = "check"
+
+	firmwareAccessComplete        = "complete"
+	firmwareAccessAborted         = "aborted"
+	firmwareAccessUpdateAvailable = "update_available"
+	firmwareAccessUpToDate        = "up_to_date"
+)
+
+// 访问记录查询默认返回的最大条数
+const firmwareAccessLogDefaultLimit = 1000
+
+var (
+	firmwareAccessLogDir  string
+	firmwareAccessLogDays int // 原始记录保留天数，0 表示一直保留
+	firmwareAccessLogMu   sync.Mutex
+)
+
+func firmwareAccessLogFile(date string) string {
+	return filepath.Join(firmwareAccessLogDir, "access-"+date+".jsonl")
+}
+
+// 初始化访问记录：保存在固件数据目录下，FIRMWARE_ACCESS_LOG_RETENTION_DAYS 配置原始记录的保留天数，默认 90 天；
+// 需要在 initDeviceCheckins 之后调用
+func initFirmwareAccessLog() {
+	firmwareAccessLogDays = int(envInt64("FIRMWARE_ACCESS_LOG_RETENTION_DAYS", 90))
+
+	dir := filepath.Join(firmwareDataDir, "access-log")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("无法创建固件访问记录目录 %s，不记录下载和检查更新: %v", dir, err)
+		return
+	}
+	firmwareAccessLogMu.Lock()
+	firmwareAccessLogDir = dir
+	firmwareAccessLogMu.Unlock()
+
+	if firmwareAccessLogDays <= 0 {
+		return
+	}
+	go func() {
+		for {
+			pruneFirmwareAccessLog(time.Now())
+			time.Sleep(24 * time.Hour)
+		}
+	}()
+}
+
+// pruneFirmwareAccessLog 删除超过保留天数的记录文件
+func pruneFirmwareAccessLog(now time.Time) {
+	cutoff := now.AddDate(0, 0, -firmwareAccessLogDays).Format(firmwareAccessLogDateLayout)
+	files, err := filepath.Glob(filepath.Join(firmwareAccessLogDir, "access-*.jsonl"))
+	if err != nil {
+		log.Printf("列出固件访问记录失败: %v", err)
+		return
+	}
+	for _, file := range files {
+		date := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), "access-"), ".jsonl")
+		if date >= cutoff {
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			log.Printf("删除固件访问记录 %s 失败: %v", file, err)
+		}
+	}
+}
+
+// recordFirmwareAccess 追加一条访问记录并更新 Prometheus 计数
+func recordFirmwareAccess(event FirmwareAccessEvent) {
+	now := time.Now()
+	event.Time = now.Format(time.RFC3339)
+	countFirmwareAccess(event)
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("JSON 编码失败: %v", err)
+		return
+	}
+
+	firmwareAccessLogMu.Lock()
+	defer firmwareAccessLogMu.Unlock()
+	if firmwareAccessLogDir == "" {
+		return
+	}
+	file, err := os.OpenFile(firmwareAccessLogFile(now.Format(firmwareAccessLogDateLayout)), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("写入固件访问记录失败: %v", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		log.Printf("写入固件访问记录失败: %v", err)
+	}
+}
+
+// 请求中的设备 ID 和用户：查询参数优先，设备也可以通过 X-Device-ID 请求头上报
+func firmwareAccessIdentity(r *http.Request) (deviceID, user string) {
+	deviceID = r.URL.Query().Get("device_id")
+	if deviceID == "" {
+		deviceID = r.Header.Get("X-Device-ID")
+	}
+	return deviceID, r.URL.Query().Get("user")
+}
+
+// recordFirmwareUpdateCheck 记录一次检查更新，Version 为设备当前版本，TargetVersion 为下发的目标版本
+func recordFirmwareUpdateCheck(r *http.Request, repo *firmwareRepository, device firmwareDevice, plan *FirmwareUpdatePlan) {
+	event := FirmwareAccessEvent{
+		Type:        firmwareAccessCheck,
+		Repository:  repo.Name,
+		ProductName: device.ProductName,
+		Version:     device.CurrentVersion,
+		Edition:     device.Edition,
+		DeviceID:    device.DeviceID,
+		RemoteAddr:  r.RemoteAddr,
+		Result:      firmwareAccessUpToDate,
+	}
+	if plan.UpdateAvailable && plan.Target != nil {
+		event.Result = firmwareAccessUpdateAvailable
+		event.FirmwareID = plan.Target.ID
+		event.TargetVersion = plan.Target.Version
+	}
+	recordFirmwareAccess(event)
+}
+
+// readFirmwareAccessLog 按日期顺序读取 [from, to] 范围内满足条件的记录，无法解析的行会被跳过
+func readFirmwareAccessLog(from, to time.Time, match func(FirmwareAccessEvent) bool) ([]FirmwareAccessEvent, error) {
+	firmwareAccessLogMu.Lock()
+	dir := firmwareAccessLogDir
+	firmwareAccessLogMu.Unlock()
+	if dir == "" {
+		return nil, fmt.Errorf("firmware access log is not available")
+	}
+
+	var events []FirmwareAccessEvent
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		file, err := os.Open(firmwareAccessLogFile(day.Format(firmwareAccessLogDateLayout)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var event FirmwareAccessEvent
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				continue
+			}
+			// 支持多仓库之前的记录属于默认仓库
+			if event.Repository == "" {
+				event.Repository = defaultFirmwareRepoName
+			}
+			if match(event) {
+				events = append(events, event)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+// parseFirmwareAccessRange 解析查询的日期范围（YYYY-MM-DD，包含两端），默认最近 7 天
+func parseFirmwareAccessRange(fromText, toText string) (time.Time, time.Time, error) {
+	today, _ := time.ParseInLocation(firmwareAccessLogDateLayout, time.Now().Format(firmwareAccessLogDateLayout), time.Local)
+	to := today
+	if toText != "" {
+		var err error
+		if to, err = time.ParseInLocation(firmwareAccessLogDateLayout, toText, time.Local); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to date %q, must be YYYY-MM-DD", toText)
+		}
+	}
+	from := to.AddDate(0, 0, -6)
+	if fromText != "" {
+		var err error
+		if from, err = time.ParseInLocation(firmwareAccessLogDateLayout, fromText, time.Local); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from date %q, must be YYYY-MM-DD", fromText)
+		}
+	}
+	if from.After(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from date %s is after to date %s", from.Format(firmwareAccessLogDateLayout), to.Format(firmwareAccessLogDateLayout))
+	}
+	return from, to, nil
+}
+
+// aggregateFirmwareAccess 按 日期/版本 和 版本 汇总访问记录：
+// 下载计入下载的版本；检查更新计入设备当前版本，下发的更新计入目标版本
+func aggregateFirmwareAccess(events []FirmwareAccessEvent) (daily, totals []FirmwareAccessStats) {
+	type statsKey struct{ date, version, edition string }
+	rows := make(map[statsKey]*FirmwareAccessStats)
+	devices := make(map[statsKey]map[string]bool)
+	row := func(key statsKey) *FirmwareAccessStats {
+		if rows[key] == nil {
+			rows[key] = &FirmwareAccessStats{Date: key.date, Version: key.version, Edition: key.edition}
+			devices[key] = make(map[string]bool)
+		}
+		return rows[key]
+	}
+
+	for _, event := range events {
+		date := ""
+		if len(event.Time) >= len(firmwareAccessLogDateLayout) {
+			date = event.Time[:len(firmwareAccessLogDateLayout)]
+		}
+		for _, d := range []string{date, ""} {
+			switch event.Type {
+			case firmwareAccessDownload:
+				key := statsKey{d, event.Version, event.Edition}
+				stats := row(key)
+				stats.Downloads++
+				stats.BytesServed += event.BytesServed
+				if event.Result == firmwareAccessComplete {
+					stats.CompletedDownloads++
+					if event.DeviceID != "" {
+						devices[key][event.DeviceID] = true
+						stats.UniqueDevices = len(devices[key])
+					}
+				} else {
+					stats.AbortedDownloads++
+				}
+			case firmwareAccessCheck:
+				row(statsKey{d, event.Version, event.Edition}).UpdateChecks++
+				if event.Result == firmwareAccessUpdateAvailable {
+					row(statsKey{d, event.TargetVersion, event.Edition}).UpdatesOffered++
+				}
+			}
+		}
+	}
+
+	daily = []FirmwareAccessStats{}
+	totals = []FirmwareAccessStats{}
+	for _, stats := range rows {
+		if stats.Date == "" {
+			totals = append(totals, *stats)
+		} else {
+			daily = append(daily, *stats)
+		}
+	}
+	less := func(list []FirmwareAccessStats) func(i, j int) bool {
+		return func(i, j int) bool {
+			if list[i].Date != list[j].Date {
+				return list[i].Date < list[j].Date
+			}
+			if list[i].Version != list[j].Version {
+				return compareVersionStrings(list[i].Version, list[j].Version) > 0
+			}
+			return list[i].Edition < list[j].Edition
+		}
+	}
+	sort.Slice(daily, less(daily))
+	sort.Slice(totals, less(totals))
+	return daily, totals
+}
+
+// 比较两个版本号，无法解析时按字符串比较
+func compareVersionStrings(a, b string) int {
+	va, errA := parseSemVer(a)
+	vb, errB := parseSemVer(b)
+	if errA == nil && errB == nil {
+		return va.Compare(vb)
+	}
+	return strings.Compare(a, b)
+}
+
+// getFirmwareStatsHandler 按产品汇总下载和检查更新：返回每天每个版本的统计和整个时间范围内每个版本的合计
+func getFirmwareStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	type GetFirmwareStatsRequest struct {
+		ProductName string `json:"product_name"`
+		Version     string `json:"version"` // 可选，只统计该版本
+		From        string `json:"from"`    // YYYY-MM-DD，默认 to 之前 6 天
+		To          string `json:"to"`      // YYYY-MM-DD，默认今天
+	}
+
+	var request GetFirmwareStatsRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	request.ProductName, err = resolveFirmwareProductName(r.Context(), repo, request.ProductName)
+	if err != nil {
+		http.Error(w, err.Error(), firmwareProductErrorStatus(err))
+		return
+	}
+	from, to, err := parseFirmwareAccessRange(request.From, request.To)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, err := readFirmwareAccessLog(from, to, func(event FirmwareAccessEvent) bool {
+		return event.Repository == repo.Name && event.ProductName == request.ProductName
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	report := FirmwareStatsReport{
+		ProductName: request.ProductName,
+		From:        from.Format(firmwareAccessLogDateLayout),
+		To:          to.Format(firmwareAccessLogDateLayout),
+	}
+	report.Daily, report.Totals = aggregateFirmwareAccess(events)
+	if request.Version != "" {
+		filter := func(list []FirmwareAccessStats) []FirmwareAccessStats {
+			result := []FirmwareAccessStats{}
+			for _, stats := range list {
+				if compareVersionStrings(stats.Version, request.Version) == 0 {
+					result = append(result, stats)
+				}
+			}
+			return result
+		}
+		report.Daily, report.Totals = filter(report.Daily), filter(report.Totals)
+	}
+
+	jsonResponse, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResponse)
+}
+
+// getFirmwareAccessLogHandler 查询原始访问记录，用于审计哪个设备或用户下载了哪个版本
+func getFirmwareAccessLogHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	type GetFirmwareAccessLogRequest struct {
+		ProductName string `json:"product_name"` // 可选
+		DeviceID    string `json:"device_id"`    // 可选
+		User        string `json:"user"`         // 可选
+		Type        string `json:"type"`         // 可选，download 或 check
+		Version     string `json:"version"`      // 可选
+		From        string `json:"from"`         // YYYY-MM-DD，默认 to 之前 6 天
+		To          string `json:"to"`           // YYYY-MM-DD，默认今天
+		Limit       int    `json:"limit"`        // 返回最近的条数，默认 1000
+	}
+
+	var request GetFirmwareAccessLogRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.ProductName != "" {
+		request.ProductName, err = resolveFirmwareProductName(r.Context(), repo, request.ProductName)
+		if err != nil {
+			http.Error(w, err.Error(), firmwareProductErrorStatus(err))
+			return
+		}
+	}
+	if request.Type != "" && request.Type != firmwareAccessDownload && request.Type != firmwareAccessCheck {
+		http.Error(w, "type must be download or check", http.StatusBadRequest)
+		return
+	}
+	if request.Limit <= 0 {
+		request.Limit = firmwareAccessLogDefaultLimit
+	}
+	from, to, err := parseFirmwareAccessRange(request.From, request.To)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, err := readFirmwareAccessLog(from, to, func(event FirmwareAccessEvent) bool {
+		return event.Repository == repo.Name &&
+			(request.ProductName == "" || event.ProductName == request.ProductName) &&
+			(request.DeviceID == "" || event.DeviceID == request.DeviceID) &&
+			(request.User == "" || event.User == request.User) &&
+			(request.Type == "" || event.Type == request.Type) &&
+			(request.Version == "" || compareVersionStrings(event.Version, request.Version) == 0 || compareVersionStrings(event.TargetVersion, request.Version) == 0)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(events) > request.Limit {
+		events = events[len(events)-request.Limit:]
+	}
+	if events == nil {
+		events = []FirmwareAccessEvent{}
+	}
+
+	jsonResponse, err := json.MarshalIndent(events, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,885 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+)
+
+// U 盘上的固件目录结构：
+//
+//	{FIRMWARE_USB_DIR}/{product}/firmwareInfo.json                    导出的目录条目
+//	{FIRMWARE_USB_DIR}/{product}/SHA256SUMS                           sha256sum 格式的校验和
+//	{FIRMWARE_USB_DIR}/{product}/{image}                              固件镜像，使用标准文件名
+//	{FIRMWARE_USB_DIR}/{product}/{image}.manifest.json                签名清单
+//	{FIRMWARE_USB_DIR}/{product}/{image}.release-notes.{lang}.md      各语言的发布说明
+const (
+	firmwareUSBInfoFile = "firmwareInfo.json"
+	firmwareUSBSumsFile = "SHA256SUMS"
+)
+
+// 导入时登记的上传人和自动注册产品的创建人
+const firmwareUSBImportUser = "usb-import"
+
+// 保留在内存中的已结束任务数
+const firmwareUSBJobHistory = 20
+
+// 任务状态
+const (
+	firmwareUSBJobRunning   = "running"
+	firmwareUSBJobCompleted = "completed"
+	firmwareUSBJobFailed    = "failed"
+)
+
+// 已有导出或导入任务在执行时返回，同一时间只允许一个任务读写 U 盘
+var errFirmwareUSBBusy = errors.New("another firmware USB job is running")
+
+// 导出选择中的版本号或通道不合法
+var errInvalidExportSelection = errors.New("invalid firmware selection")
+
+var (
+	firmwareUSBJobs     = make(map[string]*FirmwareUSBJob)
+	firmwareUSBJobOrder []string
+	firmwareUSBRunning  bool
+	firmwareUSBJobsMu   sync.Mutex
+)
+
+// 推送到 /usbEvents 的任务进度事件
+type firmwareUSBEvent struct {
+	Event string         `json:"event"` // firmware_usb_export 或 firmware_usb_import
+	Job   FirmwareUSBJob `json:"job"`
+}
+
+// U 盘固件目录，可通过 FIRMWARE_USB_DIR 配置，默认 /data/usb/firmware
+func firmwareUSBDir() string {
+	if dir := os.Getenv("FIRMWARE_USB_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(usbMountBase, "firmware")
+}
+
+// startFirmwareUSBJob 创建任务；已有任务在执行时返回 errFirmwareUSBBusy
+func startFirmwareUSBJob(repo *firmwareRepository, jobType, dir string, dryRun bool, startedBy string, total int) (*FirmwareUSBJob, error) {
+	firmwareUSBJobsMu.Lock()
+	defer firmwareUSBJobsMu.Unlock()
+
+	if firmwareUSBRunning {
+		return nil, errFirmwareUSBBusy
+	}
+	firmwareUSBRunning = true
+
+	job := &FirmwareUSBJob{
+		ID:         uuid.NewString(),
+		Type:       jobType,
+		Repository: repo.Name,
+		Status:     firmwareUSBJobRunning,
+		Dir:        dir,
+		DryRun:     dryRun,
+		Total:      total,
+		Items:      []FirmwareUSBItem{},
+		StartedBy:  startedBy,
+		StartedAt:  firmwareTimestamp(),
+	}
+	firmwareUSBJobs[job.ID] = job
+	firmwareUSBJobOrder = append(firmwareUSBJobOrder, job.ID)
+
+	// 只保留最近的任务
+	for len(firmwareUSBJobOrder) > firmwareUSBJobHistory {
+		delete(firmwareUSBJobs, firmwareUSBJobOrder[0])
+		firmwareUSBJobOrder = firmwareUSBJobOrder[1:]
+	}
+	return job, nil
+}
+
+// 返回任务的副本，避免与执行中的任务共享切片
+func (job *FirmwareUSBJob) snapshot() FirmwareUSBJob {
+	copied := *job
+	copied.Items = append([]FirmwareUSBItem{}, job.Items...)
+	return copied
+}
+
+// updateFirmwareUSBJob 修改任务状态并推送进度
+func updateFirmwareUSBJob(job *FirmwareUSBJob, update func(*FirmwareUSBJob)) {
+	firmwareUSBJobsMu.Lock()
+	update(job)
+	if job.Status != firmwareUSBJobRunning {
+		job.FinishedAt = firmwareTimestamp()
+		job.Current = ""
+		firmwareUSBRunning = false
+	}
+	event := firmwareUSBEvent{Event: "firmware_usb_" + job.Type, Job: job.snapshot()}
+	firmwareUSBJobsMu.Unlock()
+
+	broadcastEvent(event)
+}
+
+// 记录一个固件的处理结果
+func addFirmwareUSBItem(job *FirmwareUSBJob, item FirmwareUSBItem) {
+	updateFirmwareUSBJob(job, func(job *FirmwareUSBJob) {
+		job.Items = append(job.Items, item)
+		job.Done++
+	})
+}
+
+// 结束任务，err 不为空时任务失败
+func finishFirmwareUSBJob(job *FirmwareUSBJob, err error) {
+	updateFirmwareUSBJob(job, func(job *FirmwareUSBJob) {
+		job.Status = firmwareUSBJobCompleted
+		if err != nil {
+			job.Status = firmwareUSBJobFailed
+			job.Error = err.Error()
+		}
+	})
+	if err != nil {
+		log.Printf("固件 U 盘任务 %s 失败: %v", job.ID, err)
+	} else {
+		log.Printf("固件 U 盘任务 %s 完成", job.ID)
+	}
+}
+
+// 导出的固件选择，未指定 ids 和 versions 时导出通道中每个版本类型的最新版本
+type firmwareExportSelection struct {
+	ProductName string   `json:"product_name"`
+	IDs         []string `json:"ids"`
+	Versions    []string `json:"versions"` // 同一版本号的所有构建都会导出
+	Edition     string   `json:"edition"`
+	Channel     string   `json:"channel"` // 默认 stable
+}
+
+// selectFirmwareForExport 解析导出选择，返回要导出的固件
+func selectFirmwareForExport(ctx context.Context, repo *firmwareRepository, selection firmwareExportSelection) ([]firmwareEntry, error) {
+	productName, err := resolveFirmwareProductName(ctx, repo, selection.ProductName)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := listFirmwareEntries(repo, productName, firmwareQuery{Edition: selection.Edition})
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []firmwareEntry
+	switch {
+	case len(selection.IDs) > 0:
+		for _, id := range selection.IDs {
+			found := false
+			for _, entry := range entries {
+				if entry.Info.ID == id {
+					selected = append(selected, entry)
+					found = true
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("%w: %s %s", errFirmwareNotFound, productName, id)
+			}
+		}
+	case len(selection.Versions) > 0:
+		for _, text := range selection.Versions {
+			version, err := parseSemVer(text)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", errInvalidExportSelection, err)
+			}
+			found := false
+			for _, entry := range entries {
+				if entry.Version.Compare(version) == 0 {
+					selected = append(selected, entry)
+					found = true
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("%w: %s %s", errFirmwareNotFound, productName, version)
+			}
+		}
+	default:
+		channel := selection.Channel
+		if channel == "" {
+			channel = firmwareChannelStable
+		}
+		channel, err := normalizeFirmwareChannel(channel)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidExportSelection, err)
+		}
+		// entries 按版本升序排列，后出现的覆盖先出现的
+		latest := make(map[string]firmwareEntry)
+		for _, entry := range entries {
+			if firmwareChannelVisible(firmwareChannelOf(entry.Info), channel) {
+				latest[entry.Desc.Edition] = entry
+			}
+		}
+		for _, entry := range latest {
+			selected = append(selected, entry)
+		}
+		sort.Slice(selected, func(i, j int) bool { return selected[i].Desc.Edition < selected[j].Desc.Edition })
+	}
+	return selected, nil
+}
+
+// selectFirmwareForExportAll 解析多个产品的导出选择并去重，没有匹配的固件时返回 errFirmwareNotFound
+func selectFirmwareForExportAll(ctx context.Context, repo *firmwareRepository, selections []firmwareExportSelection) ([]firmwareEntry, error) {
+	var entries []firmwareEntry
+	seen := make(map[string]bool)
+	for _, selection := range selections {
+		selected, err := selectFirmwareForExport(ctx, repo, selection)
+		if err != nil {
+			return nil, err
+		}
+		// 同一个固件被多个条件选中时只导出一次
+		for _, entry := range selected {
+			if !seen[entry.Info.ID] {
+				seen[entry.Info.ID] = true
+				entries = append(entries, entry)
+			}
+		}
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%w: no firmware matches the selection", errFirmwareNotFound)
+	}
+	return entries, nil
+}
+
+// 导出选择错误对应的 HTTP 状态码
+func exportSelectionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errFirmwareNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errInvalidExportSelection):
+		return http.StatusBadRequest
+	}
+	return firmwareProductErrorStatus(err)
+}
+
+// writeUSBFile 先写入临时文件再改名，避免拔出 U 盘时留下不完整的文件；返回写入内容的校验和
+func writeUSBFile(path string, r io.Reader) (FirmwareChecksums, error) {
+	tmpPath := path + ".part"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return FirmwareChecksums{}, err
+	}
+
+	hasher := newFirmwareHasher()
+	_, err = io.Copy(io.MultiWriter(file, hasher), r)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return FirmwareChecksums{}, err
+	}
+	return hasher.Checksums(), nil
+}
+
+// 语言代码用作文件名的一部分，不能包含路径分隔符和点
+func safeUSBLanguage(lang string) bool {
+	return lang != "" && !strings.ContainsAny(lang, `/\.`)
+}
+
+// exportFirmwareToUSB 把一个固件的镜像、签名清单和发布说明写入 U 盘，镜像校验和与目录记录不一致时失败
+func exportFirmwareToUSB(ctx context.Context, repo *firmwareRepository, dir string, entry firmwareEntry) error {
+	productDir := filepath.Join(dir, entry.Desc.ProductName)
+	if err := os.MkdirAll(productDir, 0755); err != nil {
+		return err
+	}
+	fileName := entry.Desc.FileName()
+
+	object, err := minioClient.GetObject(ctx, repo.Bucket, firmwareObjectKey(repo, entry.Info), minio.GetObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("读取固件镜像失败: %v", err)
+	}
+	checksums, err := writeUSBFile(filepath.Join(productDir, fileName), object)
+	object.Close()
+	if err != nil {
+		return fmt.Errorf("写入固件镜像失败: %v", err)
+	}
+	if entry.Info.SHA256 != "" && checksums.SHA256 != entry.Info.SHA256 {
+		os.Remove(filepath.Join(productDir, fileName))
+		return fmt.Errorf("checksum mismatch: recorded %s, actual %s", entry.Info.SHA256, checksums.SHA256)
+	}
+
+	// 早期固件没有签名清单
+	if envelope, _, err := loadFirmwareManifest(ctx, repo, entry.Info); err == nil {
+		data, err := json.MarshalIndent(envelope, "", "  ")
+		if err != nil {
+			return fmt.Errorf("JSON 编码失败: %v", err)
+		}
+		if _, err := writeUSBFile(filepath.Join(productDir, firmwareManifestKey(fileName)), strings.NewReader(string(data))); err != nil {
+			return fmt.Errorf("写入签名清单失败: %v", err)
+		}
+	}
+
+	for lang, notes := range entry.Info.ReleaseNotes {
+		if !safeUSBLanguage(lang) {
+			continue
+		}
+		notesPath := filepath.Join(productDir, fmt.Sprintf("%s.release-notes.%s.md", fileName, lang))
+		if _, err := writeUSBFile(notesPath, strings.NewReader(notes)); err != nil {
+			return fmt.Errorf("写入发布说明失败: %v", err)
+		}
+	}
+	return nil
+}
+
+// readUSBFirmwareInfo 读取 U 盘上产品目录中的固件条目，文件不存在时返回空列表
+func readUSBFirmwareInfo(productDir string) ([]FirmwareInfo, error) {
+	data, err := os.ReadFile(filepath.Join(productDir, firmwareUSBInfoFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var firmwareList []FirmwareInfo
+	if err := json.Unmarshal(data, &firmwareList); err != nil {
+		return nil, fmt.Errorf("解析 %s 失败: %v", firmwareUSBInfoFile, err)
+	}
+	return firmwareList, nil
+}
+
+// readUSBChecksums 读取 sha256sum 格式的校验和文件，返回文件名到 SHA-256 的映射；文件不存在时返回 nil
+func readUSBChecksums(productDir string) (map[string]string, error) {
+	file, err := os.Open(filepath.Join(productDir, firmwareUSBSumsFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		// 二进制模式下文件名前有 *
+		sums[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+	}
+	return sums, scanner.Err()
+}
+
+// writeUSBProductIndex 把导出的条目合并到 U 盘上产品目录的 firmwareInfo.json，并重新生成 SHA256SUMS
+func writeUSBProductIndex(productDir string, exported []FirmwareInfo) error {
+	firmwareList, err := readUSBFirmwareInfo(productDir)
+	if err != nil {
+		return err
+	}
+	for _, info := range exported {
+		// 预签名链接在其他网桥上无效
+		info.URL = ""
+		replaced := false
+		for i := range firmwareList {
+			if firmwareList[i].ID == info.ID {
+				firmwareList[i] = info
+				replaced = true
+			}
+		}
+		if !replaced {
+			firmwareList = append(firmwareList, info)
+		}
+	}
+
+	data, err := json.MarshalIndent(firmwareList, "", "  ")
+	if err != nil {
+		return fmt.Errorf("JSON 编码失败: %v", err)
+	}
+	if _, err := writeUSBFile(filepath.Join(productDir, firmwareUSBInfoFile), strings.NewReader(string(data))); err != nil {
+		return err
+	}
+
+	var lines []string
+	for _, info := range firmwareList {
+		desc, err := firmwareDescriptorFromInfo(info)
+		if err != nil || info.SHA256 == "" {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s  %s\n", info.SHA256, desc.FileName()))
+	}
+	sort.Strings(lines)
+	_, err = writeUSBFile(filepath.Join(productDir, firmwareUSBSumsFile), strings.NewReader(strings.Join(lines, "")))
+	return err
+}
+
+// runFirmwareUSBExport 依次导出固件，每个产品导出完成后更新 U 盘上的索引
+func runFirmwareUSBExport(ctx context.Context, repo *firmwareRepository, job *FirmwareUSBJob, entries []firmwareEntry) {
+	exported := make(map[string][]FirmwareInfo)
+	var products []string
+	for _, entry := range entries {
+		updateFirmwareUSBJob(job, func(job *FirmwareUSBJob) {
+			job.Current = entry.Desc.ProductName + " " + entry.Desc.DisplayVersion()
+		})
+
+		item := FirmwareUSBItem{
+			ProductName: entry.Desc.ProductName,
+			Version:     entry.Desc.DisplayVersion(),
+			ID:          entry.Info.ID,
+			Status:      "exported",
+		}
+		if err := exportFirmwareToUSB(ctx, repo, job.Dir, entry); err != nil {
+			item.Status = "failed"
+			item.Message = err.Error()
+		} else {
+			if _, ok := exported[entry.Desc.ProductName]; !ok {
+				products = append(products, entry.Desc.ProductName)
+			}
+			exported[entry.Desc.ProductName] = append(exported[entry.Desc.ProductName], entry.Info)
+		}
+		addFirmwareUSBItem(job, item)
+	}
+
+	var errs []string
+	for _, productName := range products {
+		if err := writeUSBProductIndex(filepath.Join(job.Dir, productName), exported[productName]); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", productName, err))
+		}
+	}
+	if len(errs) > 0 {
+		finishFirmwareUSBJob(job, fmt.Errorf("写入 U 盘索引失败: %s", strings.Join(errs, "; ")))
+		return
+	}
+	finishFirmwareUSBJob(job, nil)
+}
+
+// U 盘上待导入的固件
+type firmwareUSBImport struct {
+	Info     FirmwareInfo
+	Desc     FirmwareDescriptor
+	Path     string // 镜像文件路径
+	Expected string // SHA256SUMS 中记录的校验和，没有校验和文件时为空
+}
+
+// scanUSBFirmware 扫描 U 盘上的固件目录，productNames 不为空时只返回这些产品的固件
+func scanUSBFirmware(dir string, productNames []string) ([]firmwareUSBImport, error) {
+	indexes, err := filepath.Glob(filepath.Join(dir, "*", firmwareUSBInfoFile))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(indexes)
+
+	var imports []firmwareUSBImport
+	for _, index := range indexes {
+		productDir := filepath.Dir(index)
+		firmwareList, err := readUSBFirmwareInfo(productDir)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", productDir, err)
+		}
+		sums, err := readUSBChecksums(productDir)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", productDir, err)
+		}
+
+		for _, info := range firmwareList {
+			if len(productNames) > 0 && !containsString(productNames, info.ProductName) {
+				continue
+			}
+			desc, err := firmwareDescriptorFromInfo(info)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", productDir, err)
+			}
+			item := firmwareUSBImport{
+				Info: info,
+				Desc: desc,
+				Path: filepath.Join(productDir, desc.FileName()),
+			}
+			if sums != nil {
+				item.Expected = sums[desc.FileName()]
+			}
+			imports = append(imports, item)
+		}
+	}
+	return imports, nil
+}
+
+// hashUSBFile 计算 U 盘上文件的校验和
+func hashUSBFile(path string) (FirmwareChecksums, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return FirmwareChecksums{}, err
+	}
+	defer file.Close()
+
+	hasher := newFirmwareHasher()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return FirmwareChecksums{}, err
+	}
+	return hasher.Checksums(), nil
+}
+
+// readUSBManifest 读取镜像旁的签名清单，清单不存在时返回 nil
+func readUSBManifest(imagePath string) (*FirmwareManifest, error) {
+	data, err := os.ReadFile(firmwareManifestKey(imagePath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var envelope FirmwareManifestEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("解析签名清单失败: %v", err)
+	}
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("解析签名清单失败: %v", err)
+	}
+	var manifest FirmwareManifest
+	if err := json.Unmarshal(payload, &manifest); err != nil {
+		return nil, fmt.Errorf("解析签名清单失败: %v", err)
+	}
+	return &manifest, nil
+}
+
+// firmwareImageExists 检查固件镜像是否已在存储中
+func firmwareImageExists(ctx context.Context, repo *firmwareRepository, objectKey string) (bool, error) {
+	_, err := minioClient.StatObject(ctx, repo.Bucket, objectKey, minio.StatObjectOptions{})
+	if err == nil {
+		return true, nil
+	}
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return false, nil
+	}
+	return false, fmt.Errorf("检查固件文件失败: %v", err)
+}
+
+// importFirmwareFromUSB 校验 U 盘上的固件并登记到目录：
+// 镜像的大小和 SHA-256 必须与条目、SHA256SUMS 和签名清单一致；
+// 产品未注册时自动注册；清单中有受信任发布者的签名时保留，否则由本地私钥重新签名
+func importFirmwareFromUSB(ctx context.Context, repo *firmwareRepository, item firmwareUSBImport, dryRun bool, uploadUser string) FirmwareUSBItem {
+	result := FirmwareUSBItem{
+		ProductName: item.Desc.ProductName,
+		Version:     item.Desc.DisplayVersion(),
+	}
+	fail := func(err error) FirmwareUSBItem {
+		result.Status = "failed"
+		result.Message = err.Error()
+		return result
+	}
+
+	product, err := importTargetProduct(ctx, repo, item.Desc.ProductName, item.Desc.FileType, firmwareUSBImportUser, dryRun)
+	if err != nil {
+		return fail(err)
+	}
+	if err := product.checkRelease(item.Desc.Edition, item.Info.HardwareRevisions); err != nil {
+		return fail(err)
+	}
+	if err := product.checkFileType(item.Desc.FileType); err != nil {
+		return fail(err)
+	}
+	desc := item.Desc
+	desc.ProductName = product.Name
+	result.ProductName = product.Name
+
+	exists, err := firmwareImageExists(ctx, repo, desc.ObjectKey(repo))
+	if err != nil {
+		return fail(err)
+	}
+	if exists {
+		result.Status = "skipped"
+		result.Message = errFirmwareExists.Error()
+		return result
+	}
+
+	checksums, err := hashUSBFile(item.Path)
+	if err != nil {
+		return fail(err)
+	}
+	if item.Info.SHA256 == "" {
+		return fail(fmt.Errorf("%s has no recorded sha256", filepath.Base(item.Path)))
+	}
+	if checksums.SHA256 != item.Info.SHA256 || (item.Info.Size > 0 && checksums.Size != item.Info.Size) {
+		return fail(fmt.Errorf("checksum mismatch: recorded %s, actual %s", item.Info.SHA256, checksums.SHA256))
+	}
+	if item.Expected != "" && item.Expected != checksums.SHA256 {
+		return fail(fmt.Errorf("checksum mismatch: %s lists %s, actual %s", firmwareUSBSumsFile, item.Expected, checksums.SHA256))
+	}
+
+	manifest, err := readUSBManifest(item.Path)
+	if err != nil {
+		return fail(err)
+	}
+	var signature []byte
+	if manifest != nil {
+		if manifest.SHA256 != checksums.SHA256 {
+			return fail(fmt.Errorf("checksum mismatch: manifest lists %s, actual %s", manifest.SHA256, checksums.SHA256))
+		}
+		signature = trustedImageSignature(manifest.ImageSignatures)
+	}
+
+	if dryRun {
+		result.Status = "would_import"
+		return result
+	}
+
+	file, err := os.Open(item.Path)
+	if err != nil {
+		return fail(err)
+	}
+	defer file.Close()
+
+	// U 盘上的固件可能比目录中的版本旧，不检查版本号
+	info, err := publishFirmware(ctx, repo, desc, file, checksums.Size, firmwarePublishOptions{
+		ContentType: "application/octet-stream",
+		UploadUser:  uploadUser,
+		Channel:     firmwareChannelOf(item.Info),
+		Force:       true,
+		Signature:   signature,
+		Release:     item.Info.FirmwareReleaseInfo,
+	})
+	if errors.Is(err, errFirmwareExists) {
+		result.Status = "skipped"
+		result.Message = err.Error()
+		return result
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	// 读取过程中 U 盘上的文件被修改
+	if info.SHA256 != checksums.SHA256 {
+		if err := deleteFirmwareInfo(repo, info.ID, product.Name); err != nil {
+			log.Printf("删除导入失败的固件 %s 失败: %v", info.ID, err)
+		}
+		return fail(fmt.Errorf("checksum mismatch: verified %s, uploaded %s", checksums.SHA256, info.SHA256))
+	}
+
+	result.ID = info.ID
+	result.Status = "imported"
+	if warnings := logUpgradePathWarnings(repo, product.Name, *info); len(warnings) > 0 {
+		result.Message = strings.Join(warnings, "; ")
+	}
+	return result
+}
+
+// runFirmwareUSBImport 依次导入 U 盘上的固件
+func runFirmwareUSBImport(ctx context.Context, repo *firmwareRepository, job *FirmwareUSBJob, imports []firmwareUSBImport) {
+	uploadUser := job.StartedBy
+	if uploadUser == "" {
+		uploadUser = firmwareUSBImportUser
+	}
+	for _, item := range imports {
+		updateFirmwareUSBJob(job, func(job *FirmwareUSBJob) {
+			job.Current = item.Desc.ProductName + " " + item.Desc.DisplayVersion()
+		})
+		addFirmwareUSBItem(job, importFirmwareFromUSB(ctx, repo, item, job.DryRun, uploadUser))
+	}
+	finishFirmwareUSBJob(job, nil)
+}
+
+// U 盘不可用时返回的错误
+func checkFirmwareUSBDir(dir string, create bool) error {
+	if create {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("USB directory %s is not writable: %v", dir, err)
+		}
+		return nil
+	}
+	stat, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("USB directory %s is not available: %v", dir, err)
+	}
+	if !stat.IsDir() {
+		return fmt.Errorf("USB path %s is not a directory", dir)
+	}
+	return nil
+}
+
+// exportFirmwareToUSBHandler 把选择的固件导出到 U 盘，立即返回任务，进度通过 /usbEvents 推送
+func exportFirmwareToUSBHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	type ExportFirmwareToUSBRequest struct {
+		Products   []firmwareExportSelection `json:"products"`
+		ExportedBy string                    `json:"exported_by"`
+	}
+
+	var request ExportFirmwareToUSBRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(request.Products) == 0 {
+		http.Error(w, "products is required", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := selectFirmwareForExportAll(r.Context(), repo, request.Products)
+	if err != nil {
+		http.Error(w, err.Error(), exportSelectionErrorStatus(err))
+		return
+	}
+
+	dir := firmwareUSBDir()
+	if err := checkFirmwareUSBDir(dir, true); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	job, err := startFirmwareUSBJob(repo, "export", dir, false, request.ExportedBy, len(entries))
+	if errors.Is(err, errFirmwareUSBBusy) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	snapshot := job.snapshot()
+	go runFirmwareUSBExport(context.Background(), repo, job, entries)
+
+	jsonResponse, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(jsonResponse)
+}
+
+// importFirmwareFromUSBHandler 校验并导入 U 盘上的固件，dry_run 时只校验不导入；立即返回任务，进度通过 /usbEvents 推送
+func importFirmwareFromUSBHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	type ImportFirmwareFromUSBRequest struct {
+		ProductNameList []string `json:"product_name_list"` // 为空时导入 U 盘上的所有产品
+		DryRun          bool     `json:"dry_run"`
+		ImportedBy      string   `json:"imported_by"`
+	}
+
+	var request ImportFirmwareFromUSBRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	dir := firmwareUSBDir()
+	if err := checkFirmwareUSBDir(dir, false); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	imports, err := scanUSBFirmware(dir, request.ProductNameList)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(imports) == 0 {
+		http.Error(w, "no firmware found on USB", http.StatusNotFound)
+		return
+	}
+
+	job, err := startFirmwareUSBJob(repo, "import", dir, request.DryRun, request.ImportedBy, len(imports))
+	if errors.Is(err, errFirmwareUSBBusy) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	snapshot := job.snapshot()
+	go runFirmwareUSBImport(context.Background(), repo, job, imports)
+
+	jsonResponse, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(jsonResponse)
+}
+
+// getFirmwareUSBJobHandler 查询 U 盘任务，未指定 id 时返回最近的任务
+func getFirmwareUSBJobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "GET")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+
+	firmwareUSBJobsMu.Lock()
+	var response any
+	if id != "" {
+		job, ok := firmwareUSBJobs[id]
+		if ok {
+			response = job.snapshot()
+		}
+	} else {
+		jobs := []FirmwareUSBJob{}
+		for i := len(firmwareUSBJobOrder) - 1; i >= 0; i-- {
+			jobs = append(jobs, firmwareUSBJobs[firmwareUSBJobOrder[i]].snapshot())
+		}
+		response = jobs
+	}
+	firmwareUSBJobsMu.Unlock()
+
+	if response == nil {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+
+	jsonResponse, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+)
+
+// 发布的版本不高于当前最新版本且未强制发布时返回
+var errFirmwareVersionNotNewer = errors.New("firmware version is not newer than the latest version")
+
+// 目录条目及其解析后的固件描述和版本号
+type firmwareEntry struct {
+	Info    FirmwareInfo
+	Desc    FirmwareDescriptor
+	Version SemVer
+}
+
+// 固件查询条件
+type firmwareQuery struct {
+	Edition          string            // 为空时不限制版本类型
+	Channel          string            // 查询的发布通道，为空时不限制；通道可见范围见 firmwareChannelVisible
+	Constraint       versionConstraint // 版本约束，为空时匹配所有版本
+	HardwareRevision string            // 只返回该硬件版本可以安装的固件，为空时不限制
+}
+
+func (q firmwareQuery) match(entry firmwareEntry) bool {
+	if q.Edition != "" && entry.Desc.Edition != q.Edition {
+		return false
+	}
+	if q.Channel != "" && !firmwareChannelVisible(firmwareChannelOf(entry.Info), q.Channel) {
+		return false
+	}
+	if q.HardwareRevision != "" && !firmwareSupportsHardware(entry.Info, q.HardwareRevision) {
+		return false
+	}
+	return q.Constraint.Match(entry.Version)
+}
+
+// 比较两个固件的先后：先比较版本号，版本号相同时比较构建日期
+func compareFirmwareEntries(a, b firmwareEntry) int {
+	if c := a.Version.Compare(b.Version); c != 0 {
+		return c
+	}
+	switch {
+	case a.Desc.BuildDate < b.Desc.BuildDate:
+		return -1
+	case a.Desc.BuildDate > b.Desc.BuildDate:
+		return 1
+	}
+	return 0
+}
+
+// firmwareEntriesFromList 解析目录条目并按查询条件过滤，结果按版本号、构建日期升序排列；
+// 无法解析的条目会被跳过
+func firmwareEntriesFromList(firmwareList []FirmwareInfo, query firmwareQuery) []firmwareEntry {
+	var entries []firmwareEntry
+	for _, info := range firmwareList {
+		desc, err := firmwareDescriptorFromInfo(info)
+		if err != nil {
+			log.Printf("跳过无法解析的固件条目 %s: %v", info.ID, err)
+			continue
+		}
+		version, err := desc.SemVer()
+		if err != nil {
+			log.Printf("跳过无法解析的固件条目 %s: %v", info.ID, err)
+			continue
+		}
+		entry := firmwareEntry{Info: info, Desc: desc, Version: version}
+		if query.match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return compareFirmwareEntries(entries[i], entries[j]) < 0
+	})
+	return entries
+}
+
+// listFirmwareEntries 查询产品中满足条件的固件，按版本号、构建日期升序排列
+func listFirmwareEntries(repo *firmwareRepository, productName string, query firmwareQuery) ([]firmwareEntry, error) {
+	firmwareList, err := getFirmwareList(repo, productName)
+	if err != nil {
+		return nil, err
+	}
+	return firmwareEntriesFromList(firmwareList, query), nil
+}
+
+// evaluateFirmwareVersion 检查待发布的版本是否高于同一版本类型的当前最新版本
+func evaluateFirmwareVersion(firmwareList []FirmwareInfo, productName, edition, version string, force bool) FirmwareVersionCheck {
+	check := FirmwareVersionCheck{
+		ProductName: productName,
+		Edition:     edition,
+		Version:     version,
+		Force:       force,
+	}
+
+	proposed, err := parseSemVer(version)
+	if err != nil {
+		check.Message = err.Error()
+		return check
+	}
+	check.Version = proposed.String()
+
+	entries := firmwareEntriesFromList(firmwareList, firmwareQuery{Edition: edition})
+	if len(entries) == 0 {
+		check.Valid = true
+		return check
+	}
+
+	latest := entries[len(entries)-1]
+	check.LatestVersion = latest.Version.String()
+	if proposed.Compare(latest.Version) > 0 {
+		check.Valid = true
+		return check
+	}
+
+	check.Message = fmt.Sprintf("version %s is not newer than the latest version %s", check.Version, check.LatestVersion)
+	if force {
+		// 强制发布时允许不高于最新版本，但保留提示信息
+		check.Valid = true
+	}
+	return check
+}
+
+// checkFirmwareVersion 根据当前固件目录检查待发布的版本
+func checkFirmwareVersion(repo *firmwareRepository, productName, edition, version string, force bool) (*FirmwareVersionCheck, error) {
+	firmwareList, err := getFirmwareList(repo, productName)
+	if err != nil {
+		return nil, err
+	}
+	check := evaluateFirmwareVersion(firmwareList, productName, edition, version, force)
+	return &check, nil
+}
+
+// validateFirmwareVersionHandler 校验待发布的版本号，不高于当前最新版本时 valid 为 false（force 为 true 时除外）
+func validateFirmwareVersionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	type ValidateFirmwareVersionRequest struct {
+		ProductName string `json:"product_name"`
+		Edition     string `json:"edition"`
+		Version     string `json:"version"`
+		Force       bool   `json:"force"`
+	}
+
+	var request ValidateFirmwareVersionRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.ProductName == "" || request.Version == "" {
+		http.Error(w, "product_name and version are required", http.StatusBadRequest)
+		return
+	}
+
+	request.ProductName, err = resolveFirmwareProductName(r.Context(), repo, request.ProductName)
+	if err != nil {
+		http.Error(w, err.Error(), firmwareProductErrorStatus(err))
+		return
+	}
+
+	check, err := checkFirmwareVersion(repo, request.ProductName, request.Edition, request.Version, request.Force)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.MarshalIndent(check, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResponse)
+}
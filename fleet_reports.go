@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 默认超过 30 天未检查更新的设备视为失联
+const defaultFleetStaleDays = 30
+
+// fleetDevices 为产品的设备记录补充最新版本和失联天数
+func fleetDevices(repo *firmwareRepository, productName string, now time.Time) []FleetDevice {
+	checkins := listDeviceCheckins(repo, productName)
+
+	// 每个 产品/通道/版本类型/硬件版本 的最新版本只查询一次
+	latestVersions := make(map[string]*SemVer)
+	latestFor := func(checkin DeviceCheckin) *SemVer {
+		key := checkin.ProductName + "/" + checkin.Channel + "/" + checkin.Edition + "/" + checkin.HardwareRevision
+		if latest, ok := latestVersions[key]; ok {
+			return latest
+		}
+		var version *SemVer
+		channel := checkin.Channel
+		if channel == "" {
+			channel = firmwareChannelStable
+		}
+		entries, err := listFirmwareEntries(repo, checkin.ProductName, firmwareQuery{
+			Edition:          checkin.Edition,
+			Channel:          channel,
+			HardwareRevision: checkin.HardwareRevision,
+		})
+		if err == nil && len(entries) > 0 {
+			version = &entries[len(entries)-1].Version
+		}
+		latestVersions[key] = version
+		return version
+	}
+
+	devices := make([]FleetDevice, 0, len(checkins))
+	for _, checkin := range checkins {
+		device := FleetDevice{
+			DeviceCheckin: checkin,
+			DaysSinceSeen: int(now.Sub(checkin.LastCheckin).Hours() / 24),
+		}
+		if latest := latestFor(checkin); latest != nil {
+			device.LatestVersion = latest.String()
+			if current, err := parseSemVer(checkin.CurrentVersion); err == nil {
+				device.BehindLatest = current.Compare(*latest) < 0
+			}
+		}
+		devices = append(devices, device)
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].ProductName != devices[j].ProductName {
+			return devices[i].ProductName < devices[j].ProductName
+		}
+		return devices[i].DeviceID < devices[j].DeviceID
+	})
+	return devices
+}
+
+// buildFleetReport 生成产品的版本分布、落后于最新版本的设备和失联设备报表
+func buildFleetReport(repo *firmwareRepository, productName string, staleDays int, now time.Time) FleetReport {
+	devices := fleetDevices(repo, productName, now)
+	report := FleetReport{
+		ProductName:         productName,
+		GeneratedAt:         firmwareTimestamp(),
+		TotalDevices:        len(devices),
+		VersionDistribution: []FleetVersionCount{},
+		BehindLatest:        []FleetDevice{},
+		StaleDays:           staleDays,
+		Stale:               []FleetDevice{},
+	}
+
+	counts := make(map[string]int)
+	for _, device := range devices {
+		counts[device.CurrentVersion]++
+		if device.BehindLatest {
+			report.BehindLatest = append(report.BehindLatest, device)
+		}
+		if device.DaysSinceSeen >= staleDays {
+			report.Stale = append(report.Stale, device)
+		}
+	}
+
+	for version, count := range counts {
+		report.VersionDistribution = append(report.VersionDistribution, FleetVersionCount{
+			Version: version,
+			Devices: count,
+			Percent: float64(count) * 100 / float64(len(devices)),
+		})
+	}
+	// 版本号从新到旧，无法解析的版本排在最后
+	sort.Slice(report.VersionDistribution, func(i, j int) bool {
+		vi, erri := parseSemVer(report.VersionDistribution[i].Version)
+		vj, errj := parseSemVer(report.VersionDistribution[j].Version)
+		switch {
+		case erri == nil && errj == nil:
+			if c := vi.Compare(vj); c != 0 {
+				return c > 0
+			}
+		case erri == nil:
+			return true
+		case errj == nil:
+			return false
+		}
+		return report.VersionDistribution[i].Version < report.VersionDistribution[j].Version
+	})
+
+	// 失联设备按最近检查时间从早到晚
+	sort.Slice(report.Stale, func(i, j int) bool {
+		return report.Stale[i].LastCheckin.Before(report.Stale[j].LastCheckin)
+	})
+	return report
+}
+
+// 报表涉及的产品：指定产品时只有该产品，否则为所有有设备记录的产品
+func fleetReportProducts(repo *firmwareRepository, productName string) []string {
+	if productName != "" {
+		return []string{productName}
+	}
+	seen := make(map[string]bool)
+	var products []string
+	for _, checkin := range listDeviceCheckins(repo, "") {
+		if !seen[checkin.ProductName] {
+			seen[checkin.ProductName] = true
+			products = append(products, checkin.ProductName)
+		}
+	}
+	sort.Strings(products)
+	return products
+}
+
+// getFleetReportHandler 返回设备固件版本报表，product_name 为空时返回所有产品
+func getFleetReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	type GetFleetReportRequest struct {
+		ProductName string `json:"product_name"`
+		StaleDays   int    `json:"stale_days"` // 默认 30
+	}
+
+	var request GetFleetReportRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.StaleDays <= 0 {
+		request.StaleDays = defaultFleetStaleDays
+	}
+
+	now := time.Now()
+	reports := []FleetReport{}
+	for _, productName := range fleetReportProducts(repo, request.ProductName) {
+		reports = append(reports, buildFleetReport(repo, productName, request.StaleDays, now))
+	}
+
+	jsonResponse, err := json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResponse)
+}
+
+// exportFleetCSVHandler 以 CSV 导出设备固件版本清单，支持 product_name、stale_days 查询参数
+func exportFleetCSVHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "GET")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, ok := firmwareRepoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	productName := r.URL.Query().Get("product_name")
+	staleDays, err := strconv.Atoi(r.URL.Query().Get("stale_days"))
+	if err != nil || staleDays <= 0 {
+		staleDays = defaultFleetStaleDays
+	}
+
+	now := time.Now()
+	fileName := fmt.Sprintf("fleet_%s.csv", now.Format("20060102"))
+	if productName != "" {
+		fileName = fmt.Sprintf("fleet_%s_%s.csv", productName, now.Format("20060102"))
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+
+	location := shanghaiLocation
+	if location == nil {
+		location = time.UTC
+	}
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{
+		"product_name", "device_id", "current_version", "latest_version", "behind_latest",
+		"hardware_revision", "edition", "channel", "first_seen", "last_checkin", "days_since_seen", "stale", "checkin_count",
+	})
+	for _, device := range fleetDevices(repo, productName, now) {
+		writer.Write(csvSafeRecord([]string{
+			device.ProductName,
+			device.DeviceID,
+			device.CurrentVersion,
+			device.LatestVersion,
+			strconv.FormatBool(device.BehindLatest),
+			device.HardwareRevision,
+			device.Edition,
+			device.Channel,
+			device.FirstSeen.In(location).Format("2006-01-02 15:04:05"),
+			device.LastCheckin.In(location).Format("2006-01-02 15:04:05"),
+			strconv.Itoa(device.DaysSinceSeen),
+			strconv.FormatBool(device.DaysSinceSeen >= staleDays),
+			strconv.FormatInt(device.CheckinCount, 10),
+		}))
+	}
+	writer.Flush()
+}
+
+// 设备上报的字段以 =、+、-、@ 开头时会被电子表格当作公式执行，加上单引号前缀按文本显示
+func csvSafeRecord(record []string) []string {
+	for i, cell := range record {
+		if cell != "" && strings.ContainsRune("=+-@", rune(cell[0])) {
+			record[i] = "'" + cell
+		}
+	}
+	return record
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// 导出的单元格以公式字符开头时加上单引号前缀，其他单元格保持不变
+func TestCSVSafeRecord(t *testing.T) {
+	tests := []struct {
+		cell, want string
+	}{
+		{"", ""},
+		{"NXT2204", "NXT2204"},
+		{"1.0.0", "1.0.0"},
+		{"=HYPERLINK(\"http://x\")", "'=HYPERLINK(\"http://x\")"},
+		{"+1+1", "'+1+1"},
+		{"-2+3", "'-2+3"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"a=b", "a=b"},
+		{"'quoted", "'quoted"},
+	}
+	record := make([]string, len(tests))
+	want := make([]string, len(tests))
+	for i, tt := range tests {
+		record[i], want[i] = tt.cell, tt.want
+	}
+	if got := csvSafeRecord(record); !reflect.DeepEqual(got, want) {
+		t.Errorf("csvSafeRecord = %q, want %q", got, want)
+	}
+}
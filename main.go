@@ -29,12 +29,14 @@ func main() {
 	// // 启动 USB 设备监听
 	// go monitorUSBEvents()
 
+	initFirmwareFormats() // 读取固件格式校验配置
+
 	initMinio() // 初始化MinIO
 
 	router := mux.NewRouter() // 创建路由
 
 	// 启动 SSE 服务器
-	// router.HandleFunc("/usbEvents", sseHandler)
+	router.HandleFunc("/usbEvents", sseHandler)
 
 	// 路由-资源文件上传、下载、删除
 	router.HandleFunc("/upload", uploadFileHandler)
@@ -43,11 +45,62 @@ func main() {
 	router.HandleFunc("/resourceList", getResourceListHanlder)
 	router.HandleFunc("/previewFile", previewFileHandler)
 	router.HandleFunc("/createFolder", createFolderHandler)
+	// 路由-资源标签和元数据
+	router.HandleFunc("/getObjectMeta", getObjectMetaHandler)
+	router.HandleFunc("/setObjectTags", setObjectTagsHandler)
+	router.HandleFunc("/removeObjectTags", removeObjectTagsHandler)
+	router.HandleFunc("/setObjectMetadata", setObjectMetadataHandler)
+	router.HandleFunc("/removeObjectMetadata", removeObjectMetadataHandler)
+	// 路由-资源版本历史、恢复、撤销删除
+	router.HandleFunc("/versionList", getVersionListHandler)
+	router.HandleFunc("/restoreVersion", restoreVersionHandler)
+	router.HandleFunc("/undeleteObject", undeleteObjectHandler)
+	// 路由-租户加密配置、密钥轮换
+	router.HandleFunc("/getTenantEncryption", getTenantEncryptionHandler)
+	router.HandleFunc("/setTenantEncryption", setTenantEncryptionHandler)
+	router.HandleFunc("/rotateTenantKey", rotateTenantKeyHandler)
+	router.HandleFunc("/keyRotationStatus", getKeyRotationStatusHandler)
+	// 路由-租户配额
+	router.HandleFunc("/quotaUsage", getQuotaUsageHandler)
+	router.HandleFunc("/setQuota", setQuotaHandler)
+	// 路由-固件产品注册
+	router.HandleFunc("/createFirmwareProduct", createFirmwareProductHandler)
+	router.HandleFunc("/listFirmwareProducts", listFirmwareProductsHandler)
+	router.HandleFunc("/updateFirmwareProduct", updateFirmwareProductHandler)
+	router.HandleFunc("/renameFirmwareProduct", renameFirmwareProductHandler)
 	// 路由-固件上传、删除、列表
 	router.HandleFunc("/uploadFirmware", uploadFirmwareHandler)
 	router.HandleFunc("/deleteFirmware", deleteFirmwareHandler)
 	router.HandleFunc("/getFirmwareList", getFirmwareListHandler)
 	router.HandleFunc("/getLatestFirmwares", getLatestFirmwaresHandler)
+	router.HandleFunc("/validateFirmwareVersion", validateFirmwareVersionHandler)
+	router.HandleFunc("/promoteFirmware", promoteFirmwareHandler)
+	router.HandleFunc("/updateFirmwareInfo", updateFirmwareInfoHandler)
+	router.HandleFunc("/setFirmwareRollout", setFirmwareRolloutHandler)
+	router.HandleFunc("/setFirmwareRolloutStatus", setFirmwareRolloutStatusHandler)
+	router.HandleFunc("/getFirmwareRollouts", getFirmwareRolloutsHandler)
+	router.HandleFunc("/resolveDeviceFirmware", resolveDeviceFirmwareHandler)
+	router.HandleFunc("/getUpgradePath", getUpgradePathHandler)
+	router.HandleFunc("/verifyFirmware", verifyFirmwareHandler)
+	router.HandleFunc("/getFirmwareManifest", getFirmwareManifestHandler)
+	router.HandleFunc("/getFirmwareSigningKeys", getFirmwareSigningKeysHandler)
+	router.HandleFunc("/getFirmwareUpdate", getFirmwareUpdateHandler)
+	router.HandleFunc("/deviceCheckin", deviceCheckinHandler)
+	router.HandleFunc("/fleetReport", getFleetReportHandler)
+	router.HandleFunc("/fleetReport.csv", exportFleetCSVHandler)
+	router.HandleFunc("/pinFirmware", pinFirmwareHandler)
+	router.HandleFunc("/runFirmwareRetention", runFirmwareRetentionHandler)
+	router.HandleFunc("/checkFirmwareConsistency", checkFirmwareConsistencyHandler)
+	router.HandleFunc("/exportFirmwareToUSB", exportFirmwareToUSBHandler)
+	router.HandleFunc("/importFirmwareFromUSB", importFirmwareFromUSBHandler)
+	router.HandleFunc("/firmwareUSBJob", getFirmwareUSBJobHandler)
+	router.HandleFunc("/exportFirmwareBundle", exportFirmwareBundleHandler)
+	router.HandleFunc("/importFirmwareBundle", importFirmwareBundleHandler)
+	router.HandleFunc(firmwareDownloadPathPrefix+"{product}/{version}", downloadFirmwareHandler)
+	router.HandleFunc("/getFirmwareDownloads", getFirmwareDownloadsHandler)
+	router.HandleFunc("/getFirmwareStats", getFirmwareStatsHandler)
+	router.HandleFunc("/getFirmwareAccessLog", getFirmwareAccessLogHandler)
+	router.HandleFunc("/metrics", metricsHandler)
 
 	// 静态文件服务
 	// router.PathPrefix("/").Handler(http.FileServer(http.Dir("/static")))
@@ -35,9 +35,17 @@ func initMinio() {
 		bucketName = "nxt-tenant"
 	}
 
+	// SSE-C 要求使用 HTTPS 连接 MinIO
+	secure := os.Getenv("MINIO_USE_SSL") == "true"
+
+	transport, err := newMinioTransport(secure)
+	if err != nil {
+		log.Fatalln(err)
+	}
 	minioClient, err = minio.New(endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
-		Secure: false,
+		Creds:     credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure:    secure,
+		Transport: transport,
 	})
 
 	if err != nil {
@@ -55,6 +63,37 @@ func initMinio() {
 		}
 	}
 
+	// 开启版本控制
+	initVersioning()
+
+	// 加载租户配额配置
+	initQuota()
+
+	// 加载主密钥和租户加密配置
+	initEncryption()
+
+	// 加载去重存储的引用计数索引
+	initDedup()
+
+	// 确保固件存储桶存在
+	initFirmwareRepository()
+
+	// 加载固件签名密钥
+	initFirmwareSigning()
+
+	// 导入已有的固件产品到产品注册表
+	initFirmwareProducts()
+
+	// 启动固件差分生成任务
+	initFirmwareDeltas()
+
+	// 加载设备检查更新记录
+	initDeviceCheckins()
+	initFirmwareAccessLog()
+
+	// 启动固件保留策略任务，依赖设备检查更新记录
+	initFirmwareRetention()
+
 	// 加载时区
 	shanghaiLocation, err = time.LoadLocation("Asia/Shanghai")
 	if err != nil {
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// 测试用的内存 S3 服务：只实现 GET/HEAD/PUT/DELETE 单个对象和 CopyObject。
+// 按 S3 的语义支持 GET 的 If-Match 和 PUT 的 If-Match / If-None-Match 条件写入
+// （只有不带引号的 * 是通配符），用于验证乐观锁
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]fakeS3Object // key 为 bucket/key
+
+	// afterRead 在 GET/HEAD 响应写完、请求返回前调用（不持有锁），found 表示对象是否存在；
+	// 响应在请求返回后才发给客户端，因此可以模拟其他副本在本次读取之后、下一次请求之前的写入
+	afterRead func(method, bucket, key string, found bool)
+}
+
+type fakeS3Object struct {
+	data        []byte
+	etag        string
+	contentType string
+	modified    time.Time
+}
+
+// newFakeMinio 启动内存 S3 服务并把 minioClient 指向它，注册 repos 为固件仓库
+// （未指定时只有一个默认仓库），测试结束时恢复
+func newFakeMinio(t *testing.T, repos ...*firmwareRepository) *fakeS3 {
+	t.Helper()
+	fake := &fakeS3{objects: make(map[string]fakeS3Object)}
+	server := httptest.NewServer(fake)
+
+	transport, err := newMinioTransport(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := minio.New(strings.TrimPrefix(server.URL, "http://"), &minio.Options{
+		Creds:     credentials.NewStaticV4("test", "testtest", ""),
+		Region:    "us-east-1",
+		Transport: transport,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(repos) == 0 {
+		repos = []*firmwareRepository{{Name: defaultFirmwareRepoName, Bucket: "nxt-device", Prefix: "firmware/", URLScheme: "oss"}}
+	}
+	prevRepos, prevDefaultRepo := firmwareRepos, defaultFirmwareRepo
+	if err := registerFirmwareRepositories(repos); err != nil {
+		t.Fatal(err)
+	}
+
+	prevClient, prevLocation := minioClient, shanghaiLocation
+	minioClient, shanghaiLocation = client, time.UTC
+	t.Cleanup(func() {
+		minioClient, shanghaiLocation = prevClient, prevLocation
+		firmwareRepos, defaultFirmwareRepo = prevRepos, prevDefaultRepo
+		server.Close()
+	})
+	return fake
+}
+
+// put 直接写入对象，绕过 HTTP 接口，返回新的 ETag
+func (f *fakeS3) put(bucket, key string, data []byte) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.putLocked(bucket+"/"+key, data, "application/json")
+}
+
+func (f *fakeS3) putLocked(path string, data []byte, contentType string) string {
+	sum := md5.Sum(data)
+	etag := hex.EncodeToString(sum[:])
+	f.objects[path] = fakeS3Object{data: data, etag: etag, contentType: contentType, modified: time.Now().UTC()}
+	return etag
+}
+
+// get 直接读取对象
+func (f *fakeS3) get(bucket, key string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	object, ok := f.objects[bucket+"/"+key]
+	return object.data, ok
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+	if key == "" {
+		// 存储桶级别的请求只需要返回成功
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		f.mu.Lock()
+		object, ok := f.objects[path]
+		f.mu.Unlock()
+		if f.afterRead != nil {
+			defer f.afterRead(r.Method, bucket, key, ok)
+		}
+		if !ok {
+			writeFakeS3Error(w, r, http.StatusNotFound, "NoSuchKey", path)
+			return
+		}
+		if match := r.Header.Get("If-Match"); match != "" && strings.Trim(match, `"`) != object.etag {
+			writeFakeS3Error(w, r, http.StatusPreconditionFailed, "PreconditionFailed", path)
+			return
+		}
+		w.Header().Set("ETag", `"`+object.etag+`"`)
+		w.Header().Set("Content-Type", object.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+		w.Header().Set("Last-Modified", object.modified.Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(object.data)
+		}
+
+	case http.MethodPut:
+		data, err := readFakeS3Body(r)
+		if err != nil {
+			writeFakeS3Error(w, r, http.StatusBadRequest, "IncompleteBody", path)
+			return
+		}
+		copySource := r.Header.Get("X-Amz-Copy-Source")
+		if copySource != "" {
+			// CopyObject：请求体为空，内容取自源对象
+			source, _ := url.PathUnescape(strings.TrimPrefix(copySource, "/"))
+			f.mu.Lock()
+			object, ok := f.objects[source]
+			f.mu.Unlock()
+			if !ok {
+				writeFakeS3Error(w, r, http.StatusNotFound, "NoSuchKey", source)
+				return
+			}
+			data = object.data
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		current, exists := f.objects[path]
+		if match := r.Header.Get("If-Match"); match != "" {
+			if !exists || strings.Trim(match, `"`) != current.etag {
+				writeFakeS3Error(w, r, http.StatusPreconditionFailed, "PreconditionFailed", path)
+				return
+			}
+		}
+		if noneMatch := r.Header.Get("If-None-Match"); noneMatch != "" {
+			if exists && (strings.TrimSpace(noneMatch) == "*" || strings.Trim(noneMatch, `"`) == current.etag) {
+				writeFakeS3Error(w, r, http.StatusPreconditionFailed, "PreconditionFailed", path)
+				return
+			}
+		}
+		etag := f.putLocked(path, data, r.Header.Get("Content-Type"))
+		w.Header().Set("ETag", `"`+etag+`"`)
+		w.WriteHeader(http.StatusOK)
+		if copySource != "" {
+			fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><CopyObjectResult><ETag>"%s"</ETag><LastModified>%s</LastModified></CopyObjectResult>`, etag, time.Now().UTC().Format(time.RFC3339))
+		}
+
+	case http.MethodDelete:
+		f.mu.Lock()
+		delete(f.objects, path)
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeFakeS3Error(w, r, http.StatusNotImplemented, "NotImplemented", path)
+	}
+}
+
+// readFakeS3Body 读取请求体，兼容 aws-chunked 分块编码
+func readFakeS3Body(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+
+	var data bytes.Buffer
+	reader := bufio.NewReader(r.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return data.Bytes(), nil
+		}
+		if _, err := io.CopyN(&data, reader, size); err != nil {
+			return nil, err
+		}
+		reader.ReadString('\n')
+	}
+}
+
+func writeFakeS3Error(w http.ResponseWriter, r *http.Request, status int, code, resource string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message><Resource>/%s</Resource></Error>`, code, code, resource)
+	}
+}
@@ -4,15 +4,23 @@ import (
 	"compress/gzip"
 	"io"
 	"net/http"
+	"time"
 )
 
 // 定义文件信息结构体
 type ObjectInfo struct {
-	FileName     string  `json:"fileName"`     // 文件名
-	Key          string  `json:"key"`          // 文件路径
-	IsDir        bool    `json:"isDir"`        // 是否为目录
-	Size         float64 `json:"size"`         // 文件大小，单位为 MB
-	LastModified string  `json:"lastModified"` // 上次修改时间
+	FileName     string  `json:"fileName"`         // 文件名
+	Key          string  `json:"key"`              // 文件路径
+	IsDir        bool    `json:"isDir"`            // 是否为目录
+	Size         float64 `json:"size"`             // 文件大小，单位为 MB
+	LastModified string  `json:"lastModified"`     // 上次修改时间
+	Sha256       string  `json:"sha256,omitempty"` // SHA-256 校验和
+
+	Tags     map[string]string `json:"tags,omitempty"`     // 对象标签
+	Metadata map[string]string `json:"metadata,omitempty"` // 用户元数据
+
+	Deleted   bool   `json:"deleted,omitempty"`   // 是否已删除（当前版本为删除标记）
+	VersionID string `json:"versionId,omitempty"` // 删除标记的版本 ID，可用于撤销删除
 }
 
 type gzipResponseWriter struct {
@@ -30,12 +38,456 @@ type FirmwareInfo struct {
 	ID          string `json:"id"`
 	ProductName string `json:"product_name"`
 	Version     string `json:"version"`
+	Edition     string `json:"edition,omitempty"`    // 版本类型，例如 Std
+	BuildDate   string `json:"build_date,omitempty"` // 构建日期，格式 YYYYMMDD
+	FileType    string `json:"file_type,omitempty"`  // 文件类型，例如 .swu，早期条目为空视为 .img
 	UploadUser  string `json:"upload_user"`
 	UploadTime  string `json:"upload_time"`
 	URL         string `json:"url"`
+	ObjectKey   string `json:"object_key,omitempty"` // 固件镜像在存储桶中的 key
+	Channel     string `json:"channel,omitempty"`    // 发布通道：stable、beta、nightly，早期条目为空视为 stable
+
+	// 发布说明和安装要求，发布后可通过 /updateFirmwareInfo 修改
+	FirmwareReleaseInfo
+	ReleaseInfoUpdatedBy string `json:"release_info_updated_by,omitempty"`
+	ReleaseInfoUpdatedAt string `json:"release_info_updated_at,omitempty"`
+
+	// 最近一次通道提升的记录
+	PromotedBy   string `json:"promoted_by,omitempty"`
+	PromotedAt   string `json:"promoted_at,omitempty"`
+	PromotedFrom string `json:"promoted_from,omitempty"`
+
+	// 固定的固件不会被保留策略删除
+	Pinned   bool   `json:"pinned,omitempty"`
+	PinnedBy string `json:"pinned_by,omitempty"`
+	PinnedAt string `json:"pinned_at,omitempty"`
+
+	// 镜像大小和校验和，上传时流式计算
+	FirmwareChecksums
+	IntegrityStatus string `json:"integrity_status,omitempty"` // ok、mismatch、missing，未校验过时为空
+	LastVerifiedAt  string `json:"last_verified_at,omitempty"`
+
+	// 签名清单，与镜像存放在同一目录
+	ManifestKey     string   `json:"manifest_key,omitempty"`
+	SignatureKeyIDs []string `json:"signature_key_ids,omitempty"` // 镜像签名使用的密钥
+}
+
+// 固件产品注册信息，产品名称用于存储路径和固件文件名
+type FirmwareProduct struct {
+	Name              string   `json:"name"`
+	DisplayName       string   `json:"display_name,omitempty"`
+	Variants          []string `json:"variants,omitempty"`           // 允许的版本类型，例如 Std，为空表示不限制
+	HardwareRevisions []string `json:"hardware_revisions,omitempty"` // 产品的硬件版本，为空表示不限制
+	FileTypes         []string `json:"file_types,omitempty"`         // 允许上传的固件文件类型，为空表示只允许 .img
+	Aliases           []string `json:"aliases,omitempty"`            // 改名前的名称，仍可用于查询
+	Archived          bool     `json:"archived,omitempty"`           // 归档后不能再发布或修改固件
+	CreatedBy         string   `json:"created_by,omitempty"`
+	CreatedAt         string   `json:"created_at,omitempty"`
+	UpdatedBy         string   `json:"updated_by,omitempty"`
+	UpdatedAt         string   `json:"updated_at,omitempty"`
+}
+
+// 按语言保存的文本，key 为语言代码，例如 zh、en
+type LocalizedText map[string]string
+
+// 固件的发布说明和安装要求
+type FirmwareReleaseInfo struct {
+	ReleaseNotes      LocalizedText `json:"release_notes,omitempty"`      // Markdown 格式
+	MinVersion        string        `json:"min_version,omitempty"`        // 设备当前版本不低于该版本才能安装
+	CompatibleFrom    string        `json:"compatible_from,omitempty"`    // 可以直接升级到该固件的源版本约束，例如 ">=1.4.0 <2.0.0"
+	HardwareRevisions []string      `json:"hardware_revisions,omitempty"` // 支持的硬件版本，为空表示不限制
+	Mandatory         bool          `json:"mandatory,omitempty"`          // 强制更新
+	ChangelogURL      string        `json:"changelog_url,omitempty"`
+}
+
+// 固件镜像的大小和校验和
+type FirmwareChecksums struct {
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	MD5    string `json:"md5,omitempty"`
+	CRC32  string `json:"crc32,omitempty"` // IEEE 多项式，8 位十六进制
+}
+
+// 固件镜像的校验结果
+type FirmwareVerifyResult struct {
+	ID         string            `json:"id"`
+	Version    string            `json:"version"`
+	ObjectKey  string            `json:"object_key"`
+	Status     string            `json:"status"`               // ok、mismatch、missing、error
+	Recorded   FirmwareChecksums `json:"recorded"`             // 目录中记录的校验和
+	Actual     FirmwareChecksums `json:"actual"`               // 重新计算的校验和
+	Backfilled bool              `json:"backfilled,omitempty"` // 早期条目没有记录校验和，本次补录
+	Error      string            `json:"error,omitempty"`
+}
+
+// 待发布固件版本的校验结果
+type FirmwareVersionCheck struct {
+	ProductName   string `json:"product_name"`
+	Edition       string `json:"edition,omitempty"`
+	Version       string `json:"version"`                  // 规范化后的版本号
+	LatestVersion string `json:"latest_version,omitempty"` // 同一版本类型的当前最新版本
+	Valid         bool   `json:"valid"`
+	Force         bool   `json:"force,omitempty"`
+	Message       string `json:"message,omitempty"`
 }
 
 type LatestFirmware struct {
+	ProductName   string        `json:"product_name"`
+	NewestVersion string        `json:"newest_version"`
+	Channel       string        `json:"channel,omitempty"`  // 查询的发布通道
+	Firmware      *FirmwareInfo `json:"firmware,omitempty"` // 最新固件的目录条目
+
+	// 请求中提供设备当前版本时返回
+	UpgradePath  []string      `json:"upgrade_path,omitempty"`  // 依次安装的版本描述，最后一个为可以到达的最新版本
+	NextFirmware *FirmwareInfo `json:"next_firmware,omitempty"` // 下一步要安装的固件
+}
+
+// 设备从当前版本升级到目标版本的路径
+type FirmwareUpgradePath struct {
+	ProductName    string         `json:"product_name"`
+	CurrentVersion string         `json:"current_version"`
+	TargetVersion  string         `json:"target_version,omitempty"` // 目标版本描述
+	Reachable      bool           `json:"reachable"`
+	Hops           []FirmwareInfo `json:"hops"` // 依次安装的固件，第一个为下一步要安装的固件
+}
+
+// 租户存储配额
+type TenantQuota struct {
+	MaxBytes         int64 `json:"maxBytes"`         // 字节数上限，0 表示不限制
+	MaxObjects       int64 `json:"maxObjects"`       // 对象数上限，0 表示不限制
+	SoftLimitPercent int   `json:"softLimitPercent"` // 软限制百分比，超过后给出警告
+}
+
+// 租户存储用量
+type TenantUsage struct {
+	ComID           string      `json:"comID"`              // 租户 ID
+	UsedBytes       int64       `json:"usedBytes"`          // 已用字节数
+	UsedObjects     int64       `json:"usedObjects"`        // 已用对象数
+	ReservedBytes   int64       `json:"reservedBytes"`      // 上传中预留的字节数
+	ReservedObjects int64       `json:"reservedObjects"`    // 上传中预留的对象数
+	Quota           TenantQuota `json:"quota"`              // 生效的配额
+	Warnings        []string    `json:"warnings,omitempty"` // 软限制警告
+}
+
+// 对象版本信息
+type ObjectVersion struct {
+	Key            string  `json:"key"`            // 文件路径
+	VersionID      string  `json:"versionId"`      // 版本 ID
+	Size           float64 `json:"size"`           // 文件大小，单位为 MB
+	LastModified   string  `json:"lastModified"`   // 版本创建时间
+	Uploader       string  `json:"uploader"`       // 上传人
+	IsLatest       bool    `json:"isLatest"`       // 是否为当前版本
+	IsDeleteMarker bool    `json:"isDeleteMarker"` // 是否为删除标记
+}
+
+// 固件分阶段发布策略，每个固件版本（按目录条目 ID）一条
+type RolloutPolicy struct {
+	FirmwareID  string   `json:"firmware_id"`
+	ProductName string   `json:"product_name"`
+	Version     string   `json:"version"`
+	Percentage  int      `json:"percentage"`           // 可获得该版本的设备百分比，0-100
+	AllowList   []string `json:"allow_list,omitempty"` // 始终可获得该版本的设备
+	DenyList    []string `json:"deny_list,omitempty"`  // 始终不可获得该版本的设备
+	StartTime   string   `json:"start_time,omitempty"` // RFC3339 格式，为空时立即开始
+	Status      string   `json:"status"`               // active、paused、aborted、completed
+	UpdatedBy   string   `json:"updated_by,omitempty"`
+	UpdatedAt   string   `json:"updated_at,omitempty"`
+}
+
+// 设备应运行的固件
+type DeviceFirmwareDecision struct {
+	ProductName string        `json:"product_name"`
+	DeviceID    string        `json:"device_id"`
+	Channel     string        `json:"channel"`
+	Firmware    *FirmwareInfo `json:"firmware,omitempty"`     // 下一步要安装的固件，为空表示没有可用固件
+	Version     string        `json:"version,omitempty"`      // 固件版本描述，例如 [Std]_V1.0.5_20211011
+	UpgradePath []string      `json:"upgrade_path,omitempty"` // 需要经过中间版本时依次安装的版本描述，最后一个为最终目标版本
+	Rollout     string        `json:"rollout,omitempty"`      // 命中的发布策略状态，没有策略时为空
+	Reason      string        `json:"reason,omitempty"`       // 选择该版本的原因
+	Skipped     []string      `json:"skipped,omitempty"`      // 因发布策略跳过的更新版本
+}
+
+// 固件签名清单的内容，设备下载前校验清单签名和镜像哈希
+type FirmwareManifest struct {
+	FirmwareID        string              `json:"firmware_id"`
+	ProductName       string              `json:"product_name"`
+	Version           string              `json:"version"`
+	Edition           string              `json:"edition,omitempty"`
+	BuildDate         string              `json:"build_date,omitempty"`
+	FileType          string              `json:"file_type,omitempty"`
+	Channel           string              `json:"channel"`
+	ObjectKey         string              `json:"object_key"`
+	Size              int64               `json:"size"`
+	SHA256            string              `json:"sha256"`
+	Timestamp         string              `json:"timestamp"` // RFC3339
+	MinVersion        string              `json:"min_version,omitempty"`
+	CompatibleFrom    string              `json:"compatible_from,omitempty"`
+	HardwareRevisions []string            `json:"hardware_revisions,omitempty"`
+	Mandatory         bool                `json:"mandatory,omitempty"`
+	ImageSignatures   []FirmwareSignature `json:"image_signatures,omitempty"` // 对镜像 SHA-256 摘要的签名
+}
+
+// 单个签名
+type FirmwareSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"` // base64
+}
+
+// DSSE 格式的签名信封
+type FirmwareManifestEnvelope struct {
+	PayloadType string              `json:"payloadType"`
+	Payload     string              `json:"payload"` // base64 编码的 FirmwareManifest
+	Signatures  []FirmwareSignature `json:"signatures"`
+}
+
+// 签名公钥
+type FirmwareSigningKey struct {
+	KeyID     string `json:"keyid"`
+	Algorithm string `json:"algorithm"`  // ed25519、ecdsa-p256 等
+	Role      string `json:"role"`       // bridge：本地签名密钥，publisher：受信任的发布者密钥
+	PublicKey string `json:"public_key"` // PEM
+}
+
+// 两个固件版本之间的二进制差分
+type FirmwareDelta struct {
+	FromID       string `json:"from_id"`
+	ToID         string `json:"to_id"`
+	FromVersion  string `json:"from_version"` // 版本描述，例如 [Std]_V1.0.4_20210901
+	ToVersion    string `json:"to_version"`
+	ObjectKey    string `json:"object_key"`
+	Size         int64  `json:"size"`
+	SHA256       string `json:"sha256"`        // 差分文件的 SHA-256
+	SourceSHA256 string `json:"source_sha256"` // 应用差分前镜像的 SHA-256
+	TargetSHA256 string `json:"target_sha256"` // 应用差分后镜像的 SHA-256
+	CreatedAt    string `json:"created_at"`
+}
+
+// 差分下载链接
+type FirmwareDeltaLink struct {
+	FirmwareDelta
+	URL string `json:"url"`
+}
+
+// 设备的升级方案：按顺序应用差分，或直接下载完整镜像
+type FirmwareUpdatePlan struct {
+	ProductName     string              `json:"product_name"`
+	CurrentVersion  string              `json:"current_version"`
+	UpdateAvailable bool                `json:"update_available"`
+	Target          *FirmwareInfo       `json:"target,omitempty"`
+	TargetVersion   string              `json:"target_version,omitempty"`
+	UpgradePath     []string            `json:"upgrade_path,omitempty"` // Target 为中间版本时的完整升级路径
+	Method          string              `json:"method"`                 // delta、full、none
+	Deltas          []FirmwareDeltaLink `json:"deltas,omitempty"`       // method 为 delta 时按顺序应用
+	FullURL         string              `json:"full_url,omitempty"`
+	DownloadSize    int64               `json:"download_size"`
+}
+
+// 设备最近一次检查更新的记录
+type DeviceCheckin struct {
+	Repository       string    `json:"repository,omitempty"`
+	DeviceID         string    `json:"device_id"`
+	ProductName      string    `json:"product_name"`
+	CurrentVersion   string    `json:"current_version"`
+	HardwareRevision string    `json:"hardware_revision,omitempty"`
+	Edition          string    `json:"edition,omitempty"`
+	Channel          string    `json:"channel"`
+	TargetVersion    string    `json:"target_version,omitempty"` // 最近一次下发的目标版本
+	UpdateAvailable  bool      `json:"update_available"`
+	FirstSeen        time.Time `json:"first_seen"`
+	LastCheckin      time.Time `json:"last_checkin"`
+	CheckinCount     int64     `json:"checkin_count"`
+}
+
+// 设备检查更新的响应
+type DeviceCheckinResponse struct {
+	UpdateAvailable bool   `json:"update_available"`
+	CurrentVersion  string `json:"current_version"`
+	TargetVersion   string `json:"target_version,omitempty"` // 版本描述，例如 [Std]_V1.0.5_20211011
+	Version         string `json:"version,omitempty"`        // 目标版本号
+	FirmwareID      string `json:"firmware_id,omitempty"`
+	Size            int64  `json:"size,omitempty"`
+	SHA256          string `json:"sha256,omitempty"`
+	FirmwareReleaseInfo
+	UpgradePath []string            `json:"upgrade_path,omitempty"` // 需要经过中间版本时的完整升级路径
+	URL         string              `json:"url,omitempty"`          // 完整镜像的预签名下载链接
+	Method      string              `json:"method"`                 // delta、full、none
+	Deltas      []FirmwareDeltaLink `json:"deltas,omitempty"`
+}
+
+// 保留策略处理的固件
+type FirmwareRetentionItem struct {
+	ID      string `json:"id"`
+	Version string `json:"version"` // 版本描述，例如 [Std]_V1.0.5_20211011
+	Channel string `json:"channel"`
+	Reason  string `json:"reason"`
+}
+
+// 一个产品执行保留策略的结果
+type FirmwareRetentionResult struct {
+	ProductName string                  `json:"product_name"`
+	Keep        int                     `json:"keep"` // 每个通道、版本类型保留的最新版本数
+	DryRun      bool                    `json:"dry_run"`
+	Deleted     []FirmwareRetentionItem `json:"deleted"`
+	Protected   []FirmwareRetentionItem `json:"protected"` // 超出保留数量但因固定、设备在用等原因保留的固件
+	Errors      []string                `json:"errors,omitempty"`
+}
+
+// 固件目录与存储的一致性检查结果
+type FirmwareConsistencyReport struct {
+	ProductName   string         `json:"product_name"`
+	OrphanImages  []string       `json:"orphan_images"`  // 存储中没有目录条目的镜像
+	PendingImages []string       `json:"pending_images"` // 没有目录条目但刚上传的镜像，可能正在发布，不修复
+	MissingImages []FirmwareInfo `json:"missing_images"` // 镜像已不存在的目录条目
+	Repaired      bool           `json:"repaired"`
+	Adopted       []FirmwareInfo `json:"adopted,omitempty"` // 修复时登记到目录的镜像
+	Dropped       []string       `json:"dropped,omitempty"` // 修复时从目录删除的条目 ID
+	Errors        []string       `json:"errors,omitempty"`
+}
+
+// 某个固件版本的设备数量
+type FleetVersionCount struct {
+	Version string  `json:"version"`
+	Devices int     `json:"devices"`
+	Percent float64 `json:"percent"`
+}
+
+// 设备固件版本报表中的设备
+type FleetDevice struct {
+	DeviceCheckin
+	LatestVersion string `json:"latest_version,omitempty"` // 设备所在通道的最新版本号
+	BehindLatest  bool   `json:"behind_latest"`
+	DaysSinceSeen int    `json:"days_since_seen"`
+}
+
+// 产品的设备固件版本报表
+type FleetReport struct {
+	ProductName         string              `json:"product_name"`
+	GeneratedAt         string              `json:"generated_at"`
+	TotalDevices        int                 `json:"total_devices"`
+	VersionDistribution []FleetVersionCount `json:"version_distribution"`
+	BehindLatest        []FleetDevice       `json:"behind_latest"`
+	StaleDays           int                 `json:"stale_days"`
+	Stale               []FleetDevice       `json:"stale"` // 超过 StaleDays 天未检查更新的设备
+}
+
+// U 盘固件导出、导入任务中的单个固件
+type FirmwareUSBItem struct {
+	ProductName string `json:"product_name"`
+	Version     string `json:"version"`      // 版本描述，例如 [Std]_V1.0.5_20211011
+	ID          string `json:"id,omitempty"` // 导出时为源目录条目 ID，导入时为新登记的条目 ID
+	Status      string `json:"status"`       // exported、imported、skipped、failed，试运行时为 would_import
+	Message     string `json:"message,omitempty"`
+}
+
+// U 盘固件导出、导入任务，进度通过 /usbEvents 推送
+type FirmwareUSBJob struct {
+	ID         string            `json:"id"`
+	Type       string            `json:"type"` // export 或 import
+	Repository string            `json:"repository"`
+	Status     string            `json:"status"` // running、completed、failed
+	Dir        string            `json:"dir"`
+	DryRun     bool              `json:"dry_run,omitempty"`
+	Total      int               `json:"total"`
+	Done       int               `json:"done"`
+	Current    string            `json:"current,omitempty"` // 正在处理的固件
+	Items      []FirmwareUSBItem `json:"items"`
+	Error      string            `json:"error,omitempty"`
+	StartedBy  string            `json:"started_by,omitempty"`
+	StartedAt  string            `json:"started_at"`
+	FinishedAt string            `json:"finished_at,omitempty"`
+}
+
+// 离线固件包中的固件
+type FirmwareBundleEntry struct {
+	ProductName string `json:"product_name"`
+	Version     string `json:"version"`
+	Edition     string `json:"edition"`
+	BuildDate   string `json:"build_date"`
+	FileType    string `json:"file_type,omitempty"`
+	Channel     string `json:"channel"`
+	Path        string `json:"path"` // 镜像在包中的路径：{product}/{标准文件名}
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	FirmwareReleaseInfo
+	ImageSignatures []FirmwareSignature `json:"image_signatures,omitempty"` // 镜像的发布者签名或源网桥签名
+}
+
+// 离线固件包清单，签名后作为 bundle.json 放在 tar 包的第一个文件
+type FirmwareBundleManifest struct {
+	FormatVersion int                   `json:"format_version"`
+	CreatedBy     string                `json:"created_by,omitempty"`
+	CreatedAt     string                `json:"created_at"`
+	Firmware      []FirmwareBundleEntry `json:"firmware"`
+}
+
+// 离线固件包导入时单个固件的对比或导入结果
+type FirmwareBundleItem struct {
+	ProductName string `json:"product_name"`
+	Version     string `json:"version"` // 版本描述，例如 [Std]_V1.0.5_20211011
+	Channel     string `json:"channel"`
+	SHA256      string `json:"sha256"`
+	ID          string `json:"id,omitempty"` // 导入后新登记的条目 ID
+	Status      string `json:"status"`       // new、exists、conflict、invalid，导入后为 imported 或 failed
+	Message     string `json:"message,omitempty"`
+}
+
+// 离线固件包导入结果，dry_run 时只包含与目录的对比
+type FirmwareBundleReport struct {
+	CreatedBy string               `json:"created_by,omitempty"`
+	CreatedAt string               `json:"created_at"`
+	SignedBy  string               `json:"signed_by,omitempty"` // 签名有效的密钥 ID，未签名时为空
+	DryRun    bool                 `json:"dry_run"`
+	Items     []FirmwareBundleItem `json:"items"`
+	Warnings  []string             `json:"warnings,omitempty"`
+}
+
+// 固件的下载次数，只统计完整发送到最后一个字节的下载
+type FirmwareDownloadCount struct {
+	ID             string `json:"id"`
+	Version        string `json:"version"`
+	Edition        string `json:"edition,omitempty"`
+	BuildDate      string `json:"build_date,omitempty"`
+	Downloads      int64  `json:"downloads"`
+	LastDownloadAt string `json:"last_download_at,omitempty"`
+}
+
+// 固件下载或检查更新的访问记录
+type FirmwareAccessEvent struct {
+	Time          string `json:"time"` // RFC3339
+	Type          string `json:"type"` // download 或 check
+	Repository    string `json:"repository,omitempty"`
 	ProductName   string `json:"product_name"`
-	NewestVersion string `json:"newest_version"`
+	FirmwareID    string `json:"firmware_id,omitempty"`
+	Version       string `json:"version"` // 下载的版本，检查更新时为设备当前版本
+	Edition       string `json:"edition,omitempty"`
+	TargetVersion string `json:"target_version,omitempty"` // 检查更新时下发的目标版本
+	DeviceID      string `json:"device_id,omitempty"`
+	User          string `json:"user,omitempty"`
+	RemoteAddr    string `json:"remote_addr,omitempty"`
+	BytesServed   int64  `json:"bytes_served,omitempty"`
+	Resumed       bool   `json:"resumed,omitempty"` // 带 Range 的续传请求
+	Result        string `json:"result"`            // 下载为 complete 或 aborted，检查更新为 update_available 或 up_to_date
+}
+
+// 某天（或整个时间范围）某个版本的下载和检查更新统计
+type FirmwareAccessStats struct {
+	Date               string `json:"date,omitempty"` // 合计时为空
+	Version            string `json:"version"`
+	Edition            string `json:"edition,omitempty"`
+	Downloads          int    `json:"downloads"`
+	CompletedDownloads int    `json:"completed_downloads"`
+	AbortedDownloads   int    `json:"aborted_downloads"`
+	BytesServed        int64  `json:"bytes_served"`
+	UniqueDevices      int    `json:"unique_devices"`  // 完整下载的设备数
+	UpdateChecks       int    `json:"update_checks"`   // 运行该版本的设备检查更新的次数
+	UpdatesOffered     int    `json:"updates_offered"` // 检查更新时下发该版本的次数
+}
+
+// 产品的下载和检查更新统计
+type FirmwareStatsReport struct {
+	ProductName string                `json:"product_name"`
+	From        string                `json:"from"`
+	To          string                `json:"to"`
+	Daily       []FirmwareAccessStats `json:"daily"`
+	Totals      []FirmwareAccessStats `json:"totals"`
 }
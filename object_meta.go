@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"regexp"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/tags"
+)
+
+// S3 对用户元数据的总大小限制
+const maxUserMetadataSize = 2 << 10
+
+// 元数据键只允许字母、数字、中划线和下划线
+var metadataKeyRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// 由网桥自身维护的元数据键，不允许通过接口修改
+var reservedMetadataKeys = map[string]bool{
+	uploaderMetadataKey: true,
+}
+
+// 校验并规范化对象标签，标签键值限制与 MinIO 保持一致
+func normalizeObjectTags(tagMap map[string]string) (map[string]string, error) {
+	t, err := tags.NewTags(tagMap, true)
+	if err != nil {
+		return nil, err
+	}
+	return t.ToMap(), nil
+}
+
+// 校验并规范化用户元数据
+func normalizeUserMetadata(metadata map[string]string) (map[string]string, error) {
+	normalized := make(map[string]string, len(metadata))
+	size := 0
+	for k, v := range metadata {
+		if !metadataKeyRegex.MatchString(k) {
+			return nil, fmt.Errorf("invalid metadata key %q", k)
+		}
+		key := textproto.CanonicalMIMEHeaderKey(k)
+		if reservedMetadataKeys[key] || isStandardMetadataKey(key) {
+			return nil, fmt.Errorf("metadata key %q is reserved", k)
+		}
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, fmt.Errorf("invalid value for metadata key %q", k)
+		}
+		normalized[key] = v
+		size += len(key) + len(v)
+	}
+	if size > maxUserMetadataSize {
+		return nil, fmt.Errorf("user metadata exceeds %d bytes", maxUserMetadataSize)
+	}
+	return normalized, nil
+}
+
+// 判断元数据键是否与标准 HTTP 头冲突
+func isStandardMetadataKey(key string) bool {
+	switch strings.ToLower(key) {
+	case "content-type", "content-encoding", "content-disposition", "content-language", "cache-control", "expires":
+		return true
+	}
+	return strings.HasPrefix(strings.ToLower(key), "x-amz-")
+}
+
+// 从列表接口返回的元数据中提取用户元数据（去掉 X-Amz-Meta- 前缀）
+func userMetadataFromList(metadata map[string]string) map[string]string {
+	result := make(map[string]string)
+	for k, v := range metadata {
+		if len(k) > len("x-amz-meta-") && strings.EqualFold(k[:len("x-amz-meta-")], "x-amz-meta-") {
+			result[textproto.CanonicalMIMEHeaderKey(k[len("x-amz-meta-"):])] = v
+		}
+	}
+	return result
+}
+
+// 去掉网桥内部维护的元数据，只返回用户可见部分
+func visibleUserMetadata(metadata map[string]string) map[string]string {
+	result := make(map[string]string)
+	for k, v := range metadata {
+		key := textproto.CanonicalMIMEHeaderKey(k)
+		if reservedMetadataKeys[key] {
+			continue
+		}
+		result[key] = v
+	}
+	return result
+}
+
+// 判断对象标签是否满足全部过滤条件
+func matchTags(objectTags map[string]string, filter map[string]string) bool {
+	for k, v := range filter {
+		value, ok := objectTags[k]
+		if !ok {
+			return false
+		}
+		// 过滤值为空时只要求存在该标签
+		if v != "" && value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// 获取对象标签和用户元数据
+func getObjectMeta(ctx context.Context, key string) (map[string]string, map[string]string, error) {
+	_, stat, err := readSSE(ctx, key, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	t, err := minioClient.GetObjectTagging(ctx, bucketName, key, minio.GetObjectTaggingOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	return t.ToMap(), visibleUserMetadata(stat.UserMetadata), nil
+}
+
+// 通过服务端复制替换对象的用户元数据，保留内容类型和内部元数据，并按租户当前配置加密
+func replaceUserMetadata(ctx context.Context, key string, update func(map[string]string)) error {
+	_, stat, err := readSSE(ctx, key, "")
+	if err != nil {
+		return err
+	}
+
+	metadata := make(map[string]string)
+	for k, v := range stat.UserMetadata {
+		metadata[textproto.CanonicalMIMEHeaderKey(k)] = v
+	}
+	update(metadata)
+
+	size := 0
+	for k, v := range metadata {
+		if !reservedMetadataKeys[k] {
+			size += len(k) + len(v)
+		}
+	}
+	if size > maxUserMetadataSize {
+		return fmt.Errorf("user metadata exceeds %d bytes", maxUserMetadataSize)
+	}
+
+	return copyObjectSSE(ctx, key, "", key, metadata)
+}
+
+// 对象标签和元数据接口共用的请求体
+type objectMetaRequest struct {
+	Key          string            `json:"key"`
+	Tags         map[string]string `json:"tags"`
+	TagKeys      []string          `json:"tagKeys"`
+	Replace      bool              `json:"replace"`
+	Metadata     map[string]string `json:"metadata"`
+	MetadataKeys []string          `json:"metadataKeys"`
+}
+
+// 解析对象元数据请求，并处理预检和 CORS
+func decodeObjectMetaRequest(w http.ResponseWriter, r *http.Request) (*objectMetaRequest, bool) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return nil, false
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+
+	var request objectMetaRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || request.Key == "" || strings.HasSuffix(request.Key, "/") {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+	return &request, true
+}
+
+// 返回对象当前的标签和元数据
+func writeObjectMeta(w http.ResponseWriter, ctx context.Context, key string) {
+	objectTags, metadata, err := getObjectMeta(ctx, key)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			http.Error(w, "Object not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"key":      key,
+		"tags":     objectTags,
+		"metadata": metadata,
+	}
+
+	jsonResponse, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// 获取对象标签和元数据
+func getObjectMetaHandler(w http.ResponseWriter, r *http.Request) {
+	request, ok := decodeObjectMetaRequest(w, r)
+	if !ok {
+		return
+	}
+	writeObjectMeta(w, r.Context(), request.Key)
+}
+
+// 设置对象标签，默认与已有标签合并，replace 为 true 时整体替换
+func setObjectTagsHandler(w http.ResponseWriter, r *http.Request) {
+	request, ok := decodeObjectMetaRequest(w, r)
+	if !ok {
+		return
+	}
+
+	newTags := make(map[string]string)
+	if !request.Replace {
+		current, err := minioClient.GetObjectTagging(r.Context(), bucketName, request.Key, minio.GetObjectTaggingOptions{})
+		if err != nil {
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				http.Error(w, "Object not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		newTags = current.ToMap()
+	}
+	for k, v := range request.Tags {
+		newTags[k] = v
+	}
+
+	t, err := tags.NewTags(newTags, true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = minioClient.PutObjectTagging(r.Context(), bucketName, request.Key, t, minio.PutObjectTaggingOptions{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeObjectMeta(w, r.Context(), request.Key)
+}
+
+// 删除对象标签，tagKeys 为空时删除全部标签
+func removeObjectTagsHandler(w http.ResponseWriter, r *http.Request) {
+	request, ok := decodeObjectMetaRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var err error
+	if len(request.TagKeys) == 0 {
+		err = minioClient.RemoveObjectTagging(r.Context(), bucketName, request.Key, minio.RemoveObjectTaggingOptions{})
+	} else {
+		var current *tags.Tags
+		current, err = minioClient.GetObjectTagging(r.Context(), bucketName, request.Key, minio.GetObjectTaggingOptions{})
+		if err == nil {
+			for _, k := range request.TagKeys {
+				current.Remove(k)
+			}
+			err = minioClient.PutObjectTagging(r.Context(), bucketName, request.Key, current, minio.PutObjectTaggingOptions{})
+		}
+	}
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			http.Error(w, "Object not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeObjectMeta(w, r.Context(), request.Key)
+}
+
+// 设置对象用户元数据，默认与已有元数据合并，replace 为 true 时整体替换
+func setObjectMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	request, ok := decodeObjectMetaRequest(w, r)
+	if !ok {
+		return
+	}
+
+	metadata, err := normalizeUserMetadata(request.Metadata)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = replaceUserMetadata(r.Context(), request.Key, func(current map[string]string) {
+		if request.Replace {
+			for k := range current {
+				if !reservedMetadataKeys[k] {
+					delete(current, k)
+				}
+			}
+		}
+		for k, v := range metadata {
+			current[k] = v
+		}
+	})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			http.Error(w, "Object not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeObjectMeta(w, r.Context(), request.Key)
+}
+
+// 删除对象用户元数据，metadataKeys 为空时删除全部用户元数据
+func removeObjectMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	request, ok := decodeObjectMetaRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := replaceUserMetadata(r.Context(), request.Key, func(current map[string]string) {
+		for k := range current {
+			if reservedMetadataKeys[k] {
+				continue
+			}
+			if len(request.MetadataKeys) == 0 {
+				delete(current, k)
+			}
+		}
+		for _, k := range request.MetadataKeys {
+			key := textproto.CanonicalMIMEHeaderKey(k)
+			if !reservedMetadataKeys[key] {
+				delete(current, key)
+			}
+		}
+	})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			http.Error(w, "Object not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeObjectMeta(w, r.Context(), request.Key)
+}
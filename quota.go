@@ -0,0 +1,507 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// 配额配置在租户桶中的存放位置，不属于任何租户前缀
+const quotaConfigKey = ".bridge/quotas.json"
+
+var errQuotaExceeded = errors.New("quota exceeded")
+
+// 单个租户的用量状态，所有字段由 mu 保护
+type tenantUsageState struct {
+	mu              sync.Mutex
+	loaded          bool            // 是否已从 MinIO 统计过现有用量
+	usedBytes       int64           // 已提交的字节数
+	usedObjects     int64           // 已提交的对象数
+	reservedBytes   int64           // 上传中预留的字节数
+	reservedObjects int64           // 上传中预留的对象数
+	inflight        map[string]bool // 正在上传的对象 key，防止同名并发上传重复计数
+}
+
+var (
+	quotaMu       sync.Mutex
+	defaultQuota  TenantQuota
+	tenantQuotas  = make(map[string]TenantQuota)
+	tenantUsages  = make(map[string]*tenantUsageState)
+	quotaConfigMu sync.Mutex // 串行化配额配置文件的读写
+)
+
+// 配额预留，上传成功后 commit，失败后 release
+type quotaReservation struct {
+	tenant string
+	key    string
+	bytes  int64
+	state  *tenantUsageState
+	done   bool
+}
+
+// 初始化配额：默认值来自环境变量，租户覆盖值来自桶内配置文件
+func initQuota() {
+	defaultQuota = TenantQuota{
+		MaxBytes:         envInt64("MINIO_TENANT_QUOTA_BYTES", 0),
+		MaxObjects:       envInt64("MINIO_TENANT_QUOTA_OBJECTS", 0),
+		SoftLimitPercent: int(envInt64("MINIO_TENANT_QUOTA_SOFT_PERCENT", 80)),
+	}
+
+	quotas, err := loadTenantQuotas(context.Background())
+	if err != nil {
+		log.Printf("加载租户配额配置失败: %v", err)
+		return
+	}
+	quotaMu.Lock()
+	tenantQuotas = quotas
+	quotaMu.Unlock()
+}
+
+// 读取整数类型的环境变量，未设置或格式错误时使用默认值
+func envInt64(name string, def int64) int64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.Printf("环境变量 %s 格式错误，使用默认值 %d: %v", name, def, err)
+		return def
+	}
+	return n
+}
+
+// 从对象 key 中取出租户 ID（第一级目录）
+func tenantFromKey(key string) string {
+	if i := strings.Index(key, "/"); i > 0 {
+		return key[:i]
+	}
+	return ""
+}
+
+// 获取租户生效的配额
+func quotaForTenant(tenant string) TenantQuota {
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	if q, ok := tenantQuotas[tenant]; ok {
+		return q
+	}
+	return defaultQuota
+}
+
+func usageStateForTenant(tenant string) *tenantUsageState {
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	state, ok := tenantUsages[tenant]
+	if !ok {
+		state = &tenantUsageState{inflight: make(map[string]bool)}
+		tenantUsages[tenant] = state
+	}
+	return state
+}
+
+// 统计租户现有用量，调用方需持有 state.mu。
+// 字节数包括历史版本，它们在过期删除前仍占用磁盘；对象数只统计当前版本
+func (s *tenantUsageState) load(ctx context.Context, tenant string) error {
+	if s.loaded {
+		return nil
+	}
+	var usedBytes, usedObjects int64
+	opts := minio.ListObjectsOptions{Prefix: tenant + "/", Recursive: true, WithMetadata: true, WithVersions: bucketVersioning}
+	for object := range minioClient.ListObjects(ctx, bucketName, opts) {
+		if object.Err != nil {
+			return fmt.Errorf("统计租户 %s 用量失败: %w", tenant, object.Err)
+		}
+		// 目录占位对象和删除标记不计入用量
+		if strings.HasSuffix(object.Key, "/") || object.IsDeleteMarker {
+			continue
+		}
+		usedBytes += logicalSize(object)
+		if object.IsLatest || !bucketVersioning {
+			usedObjects++
+		}
+	}
+	s.usedBytes = usedBytes
+	s.usedObjects = usedObjects
+	s.loaded = true
+	return nil
+}
+
+// 为即将上传的对象预留配额；对象已在上传中时返回 ok=false
+func reserveQuota(ctx context.Context, key string, size int64) (res *quotaReservation, ok bool, err error) {
+	tenant := tenantFromKey(key)
+	state := usageStateForTenant(tenant)
+	quota := quotaForTenant(tenant)
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if err := state.load(ctx, tenant); err != nil {
+		return nil, false, err
+	}
+	if state.inflight[key] {
+		return nil, false, nil
+	}
+	if quota.MaxBytes > 0 && state.usedBytes+state.reservedBytes+size > quota.MaxBytes {
+		return nil, false, fmt.Errorf("%w: tenant %s would use %d of %d bytes", errQuotaExceeded, tenant, state.usedBytes+state.reservedBytes+size, quota.MaxBytes)
+	}
+	if quota.MaxObjects > 0 && state.usedObjects+state.reservedObjects+1 > quota.MaxObjects {
+		return nil, false, fmt.Errorf("%w: tenant %s would store %d of %d objects", errQuotaExceeded, tenant, state.usedObjects+state.reservedObjects+1, quota.MaxObjects)
+	}
+
+	state.reservedBytes += size
+	state.reservedObjects++
+	state.inflight[key] = true
+	return &quotaReservation{tenant: tenant, key: key, bytes: size, state: state}, true, nil
+}
+
+// 上传成功，将预留转为实际用量
+func (r *quotaReservation) commit(actualBytes int64) {
+	r.state.mu.Lock()
+	defer r.state.mu.Unlock()
+	if r.done {
+		return
+	}
+	r.done = true
+	r.state.reservedBytes -= r.bytes
+	r.state.reservedObjects--
+	r.state.usedBytes += actualBytes
+	r.state.usedObjects++
+	delete(r.state.inflight, r.key)
+}
+
+// 上传失败，释放预留
+func (r *quotaReservation) release() {
+	r.state.mu.Lock()
+	defer r.state.mu.Unlock()
+	if r.done {
+		return
+	}
+	r.done = true
+	r.state.reservedBytes -= r.bytes
+	r.state.reservedObjects--
+	delete(r.state.inflight, r.key)
+}
+
+// 在流式上传过程中检查实际写入的字节数不超过预留值
+type quotaReader struct {
+	r     io.Reader
+	res   *quotaReservation
+	count int64
+}
+
+func newQuotaReader(r io.Reader, res *quotaReservation) *quotaReader {
+	return &quotaReader{r: r, res: res}
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	n, err := q.r.Read(p)
+	q.count += int64(n)
+	if q.count > q.res.bytes {
+		return n, fmt.Errorf("%w: upload of %s exceeds reserved %d bytes", errQuotaExceeded, q.res.key, q.res.bytes)
+	}
+	return n, err
+}
+
+// 删除对象时同步扣减用量；删除操作在租户锁内执行，避免与用量统计交错。
+// 开启版本控制时只写入删除标记，原版本成为历史版本，仍计入字节用量直到过期删除；
+// 未开启版本控制时对象被永久删除，同时释放其 blob 引用
+func removeObjectWithQuota(ctx context.Context, bucket string, object minio.ObjectInfo) error {
+	tenant := tenantFromKey(object.Key)
+	state := usageStateForTenant(tenant)
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if err := minioClient.RemoveObject(ctx, bucket, object.Key, minio.RemoveObjectOptions{}); err != nil {
+		return err
+	}
+	permanent := bucket != bucketName || !bucketVersioning
+	if sum, _, ok := blobRef(object.UserMetadata); ok && permanent {
+		if err := releaseBlobRef(ctx, sum); err != nil {
+			log.Printf("释放 blob 引用失败: %v", err)
+		}
+	}
+	if state.loaded && bucket == bucketName && !strings.HasSuffix(object.Key, "/") {
+		if permanent {
+			state.usedBytes -= logicalSize(object)
+		}
+		state.usedObjects--
+		state.clamp()
+	}
+	return nil
+}
+
+// removeObjectVersionWithQuota 永久删除对象的一个版本，扣减字节用量并释放其 blob 引用。
+// 用于删除过期的历史版本，不影响当前版本和对象数
+func removeObjectVersionWithQuota(ctx context.Context, object minio.ObjectInfo) error {
+	tenant := tenantFromKey(object.Key)
+	state := usageStateForTenant(tenant)
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	err := minioClient.RemoveObject(ctx, bucketName, object.Key, minio.RemoveObjectOptions{VersionID: object.VersionID})
+	if err != nil {
+		return err
+	}
+	if object.IsDeleteMarker {
+		return nil
+	}
+	if sum, _, ok := blobRef(object.UserMetadata); ok {
+		if err := releaseBlobRef(ctx, sum); err != nil {
+			log.Printf("释放 blob 引用失败: %v", err)
+		}
+	}
+	if state.loaded && !strings.HasSuffix(object.Key, "/") {
+		state.usedBytes -= logicalSize(object)
+		state.clamp()
+	}
+	return nil
+}
+
+// 用量不小于 0，调用方需持有 s.mu
+func (s *tenantUsageState) clamp() {
+	if s.usedBytes < 0 {
+		s.usedBytes = 0
+	}
+	if s.usedObjects < 0 {
+		s.usedObjects = 0
+	}
+}
+
+// 在租户锁内执行替换对象当前版本的操作（恢复历史版本、撤销删除、重新加密），
+// prev 和 next 分别为操作前后的当前版本，nil 表示不存在；
+// addedBytes 为操作新写入的字节数，被替换的版本作为历史版本保留，不扣减字节用量
+func replaceObjectWithQuota(ctx context.Context, key string, prev, next *minio.ObjectInfo, addedBytes int64, op func() error) error {
+	tenant := tenantFromKey(key)
+	state := usageStateForTenant(tenant)
+	quota := quotaForTenant(tenant)
+
+	deltaBytes := addedBytes
+	var deltaObjects int64
+	if prev != nil {
+		deltaObjects--
+	}
+	if next != nil {
+		deltaObjects++
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if err := state.load(ctx, tenant); err != nil {
+		return err
+	}
+	if deltaBytes > 0 && quota.MaxBytes > 0 && state.usedBytes+state.reservedBytes+deltaBytes > quota.MaxBytes {
+		return fmt.Errorf("%w: tenant %s would use %d of %d bytes", errQuotaExceeded, tenant, state.usedBytes+state.reservedBytes+deltaBytes, quota.MaxBytes)
+	}
+	if deltaObjects > 0 && quota.MaxObjects > 0 && state.usedObjects+state.reservedObjects+deltaObjects > quota.MaxObjects {
+		return fmt.Errorf("%w: tenant %s would store %d of %d objects", errQuotaExceeded, tenant, state.usedObjects+state.reservedObjects+deltaObjects, quota.MaxObjects)
+	}
+
+	if err := op(); err != nil {
+		return err
+	}
+	state.usedBytes += deltaBytes
+	state.usedObjects += deltaObjects
+	return nil
+}
+
+// 根据错误类型返回 HTTP 状态码，超出配额时返回 413
+func quotaErrorStatus(err error) int {
+	if errors.Is(err, errQuotaExceeded) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusInternalServerError
+}
+
+// 获取租户用量及软限制警告
+func getTenantUsage(ctx context.Context, tenant string) (TenantUsage, error) {
+	state := usageStateForTenant(tenant)
+	quota := quotaForTenant(tenant)
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if err := state.load(ctx, tenant); err != nil {
+		return TenantUsage{}, err
+	}
+
+	usage := TenantUsage{
+		ComID:           tenant,
+		UsedBytes:       state.usedBytes,
+		UsedObjects:     state.usedObjects,
+		ReservedBytes:   state.reservedBytes,
+		ReservedObjects: state.reservedObjects,
+		Quota:           quota,
+	}
+	usage.Warnings = quotaWarnings(usage)
+	return usage, nil
+}
+
+// 根据软限制百分比生成警告信息
+func quotaWarnings(usage TenantUsage) []string {
+	var warnings []string
+	percent := usage.Quota.SoftLimitPercent
+	if percent <= 0 {
+		return nil
+	}
+	if max := usage.Quota.MaxBytes; max > 0 && usage.UsedBytes*100 >= max*int64(percent) {
+		warnings = append(warnings, fmt.Sprintf("tenant %s has used %d%% of its byte quota", usage.ComID, usage.UsedBytes*100/max))
+	}
+	if max := usage.Quota.MaxObjects; max > 0 && usage.UsedObjects*100 >= max*int64(percent) {
+		warnings = append(warnings, fmt.Sprintf("tenant %s has used %d%% of its object quota", usage.ComID, usage.UsedObjects*100/max))
+	}
+	return warnings
+}
+
+// 从租户桶读取租户配额配置
+func loadTenantQuotas(ctx context.Context) (map[string]TenantQuota, error) {
+	quotas := make(map[string]TenantQuota)
+	object, err := minioClient.GetObject(ctx, bucketName, quotaConfigKey, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	err = json.NewDecoder(object).Decode(&quotas)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return quotas, nil
+		}
+		return nil, fmt.Errorf("解析配额配置失败: %v", err)
+	}
+	return quotas, nil
+}
+
+// 设置租户配额并持久化到租户桶
+func setTenantQuota(ctx context.Context, tenant string, quota TenantQuota) error {
+	quotaConfigMu.Lock()
+	defer quotaConfigMu.Unlock()
+
+	quotas, err := loadTenantQuotas(ctx)
+	if err != nil {
+		return err
+	}
+	quotas[tenant] = quota
+
+	data, err := json.MarshalIndent(quotas, "", "  ")
+	if err != nil {
+		return fmt.Errorf("JSON 编码失败: %v", err)
+	}
+	_, err = minioClient.PutObject(ctx, bucketName, quotaConfigKey, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/json",
+	})
+	if err != nil {
+		return fmt.Errorf("保存配额配置失败: %v", err)
+	}
+
+	quotaMu.Lock()
+	tenantQuotas[tenant] = quota
+	quotaMu.Unlock()
+	return nil
+}
+
+// 查询租户用量
+func getQuotaUsageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	type GetQuotaUsageRequest struct {
+		ComID string `json:"comID"`
+	}
+
+	var request GetQuotaUsageRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || request.ComID == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	usage, err := getTenantUsage(r.Context(), request.ComID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jsonUsage, err := json.MarshalIndent(usage, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonUsage)
+}
+
+// 设置租户配额
+func setQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	type SetQuotaRequest struct {
+		ComID string `json:"comID"`
+		TenantQuota
+	}
+
+	var request SetQuotaRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || request.ComID == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.MaxBytes < 0 || request.MaxObjects < 0 || request.SoftLimitPercent < 0 || request.SoftLimitPercent > 100 {
+		http.Error(w, "Invalid quota values", http.StatusBadRequest)
+		return
+	}
+
+	err = setTenantQuota(r.Context(), request.ComID, request.TenantQuota)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Quota updated successfully",
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
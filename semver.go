@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SemVer 语义化版本 2.0：MAJOR.MINOR.PATCH[-PRERELEASE][+BUILD]
+type SemVer struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	Prerelease []string // 预发布标识，例如 rc.1 为 ["rc", "1"]
+	Build      []string // 构建元数据，不参与优先级比较
+}
+
+// 预发布和构建元数据的标识只允许字母、数字和连字符
+var semverIdentifierRegex = regexp.MustCompile(`^[0-9A-Za-z-]+$`)
+
+// parseSemVer 解析语义化版本，允许带 v/V 前缀
+func parseSemVer(version string) (SemVer, error) {
+	s := strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(version), "v"), "V")
+	if s == "" {
+		return SemVer{}, fmt.Errorf("版本号为空")
+	}
+
+	var v SemVer
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		build, err := parseSemVerIdentifiers(s[i+1:], false)
+		if err != nil {
+			return SemVer{}, fmt.Errorf("版本格式不正确: %s: build metadata %v", version, err)
+		}
+		v.Build = build
+		s = s[:i]
+	}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		pre, err := parseSemVerIdentifiers(s[i+1:], true)
+		if err != nil {
+			return SemVer{}, fmt.Errorf("版本格式不正确: %s: pre-release %v", version, err)
+		}
+		v.Prerelease = pre
+		s = s[:i]
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return SemVer{}, fmt.Errorf("版本格式不正确: %s", version)
+	}
+	nums := make([]uint64, 3)
+	for i, part := range parts {
+		num, err := parseSemVerNumber(part)
+		if err != nil {
+			return SemVer{}, fmt.Errorf("版本格式不正确: %s: %v", version, err)
+		}
+		nums[i] = num
+	}
+	v.Major, v.Minor, v.Patch = nums[0], nums[1], nums[2]
+	return v, nil
+}
+
+// 数字部分不能为空，也不能有前导零
+func parseSemVerNumber(s string) (uint64, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty numeric part")
+	}
+	if len(s) > 1 && s[0] == '0' {
+		return 0, fmt.Errorf("numeric part %q has leading zero", s)
+	}
+	num, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid numeric part %q", s)
+	}
+	return num, nil
+}
+
+func parseSemVerIdentifiers(s string, prerelease bool) ([]string, error) {
+	ids := strings.Split(s, ".")
+	for _, id := range ids {
+		if !semverIdentifierRegex.MatchString(id) {
+			return nil, fmt.Errorf("invalid identifier %q", id)
+		}
+		// 预发布中的纯数字标识不能有前导零
+		if prerelease && isNumericIdentifier(id) && len(id) > 1 && id[0] == '0' {
+			return nil, fmt.Errorf("numeric identifier %q has leading zero", id)
+		}
+	}
+	return ids, nil
+}
+
+func isNumericIdentifier(id string) bool {
+	for _, c := range id {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return id != ""
+}
+
+// String 返回规范形式（不带 v 前缀）
+func (v SemVer) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) > 0 {
+		s += "-" + strings.Join(v.Prerelease, ".")
+	}
+	if len(v.Build) > 0 {
+		s += "+" + strings.Join(v.Build, ".")
+	}
+	return s
+}
+
+// Compare 按 SemVer 2.0 优先级比较，返回 -1、0、1；构建元数据不参与比较
+func (v SemVer) Compare(o SemVer) int {
+	if c := compareUint(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+
+	// 有预发布标识的版本低于对应的正式版本
+	switch {
+	case len(v.Prerelease) == 0 && len(o.Prerelease) == 0:
+		return 0
+	case len(v.Prerelease) == 0:
+		return 1
+	case len(o.Prerelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.Prerelease) && i < len(o.Prerelease); i++ {
+		if c := comparePrereleaseIdentifier(v.Prerelease[i], o.Prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return compareUint(uint64(len(v.Prerelease)), uint64(len(o.Prerelease)))
+}
+
+// 纯数字标识按数值比较且低于非数字标识，非数字标识按 ASCII 比较
+func comparePrereleaseIdentifier(a, b string) int {
+	an, bn := isNumericIdentifier(a), isNumericIdentifier(b)
+	switch {
+	case an && bn:
+		if len(a) != len(b) {
+			return compareUint(uint64(len(a)), uint64(len(b)))
+		}
+		return strings.Compare(a, b)
+	case an:
+		return -1
+	case bn:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// 版本约束中的单个比较条件
+type versionComparator struct {
+	op      string // =, !=, >, >=, <, <=
+	version SemVer
+}
+
+func (c versionComparator) match(v SemVer) bool {
+	cmp := v.Compare(c.version)
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+// versionConstraint 版本约束表达式，例如 ">=1.2.0 <2.0.0 || ^3.1.0"：
+// "||" 分隔的各组满足其一即可，组内以空格或逗号分隔的条件需全部满足。
+// 支持 =、!=、>、>=、<、<=，以及 ~1.2.3（>=1.2.3 <1.3.0）和 ^1.2.3（>=1.2.3 <2.0.0）
+type versionConstraint struct {
+	expr   string
+	groups [][]versionComparator
+}
+
+// parseVersionConstraint 解析版本约束，空表达式或 "*" 匹配所有版本
+func parseVersionConstraint(expr string) (versionConstraint, error) {
+	c := versionConstraint{expr: strings.TrimSpace(expr)}
+	if c.expr == "" || c.expr == "*" {
+		return c, nil
+	}
+
+	for _, group := range strings.Split(c.expr, "||") {
+		var comparators []versionComparator
+		for _, term := range strings.Fields(strings.ReplaceAll(group, ",", " ")) {
+			parsed, err := parseVersionComparator(term)
+			if err != nil {
+				return versionConstraint{}, fmt.Errorf("invalid version constraint %q: %v", expr, err)
+			}
+			comparators = append(comparators, parsed...)
+		}
+		if len(comparators) == 0 {
+			return versionConstraint{}, fmt.Errorf("invalid version constraint %q: empty range", expr)
+		}
+		c.groups = append(c.groups, comparators)
+	}
+	return c, nil
+}
+
+func parseVersionComparator(term string) ([]versionComparator, error) {
+	for _, op := range []string{">=", "<=", "!=", "==", ">", "<", "=", "~", "^"} {
+		if !strings.HasPrefix(term, op) {
+			continue
+		}
+		operand := term[len(op):]
+		switch op {
+		case "~", "^":
+			return parseRangeComparator(op, operand)
+		case "==":
+			op = "="
+		}
+		v, _, err := parsePartialSemVer(operand)
+		if err != nil {
+			return nil, err
+		}
+		return []versionComparator{{op: op, version: v}}, nil
+	}
+
+	// 不带运算符时表示精确匹配
+	v, _, err := parsePartialSemVer(term)
+	if err != nil {
+		return nil, err
+	}
+	return []versionComparator{{op: "=", version: v}}, nil
+}
+
+// ~ 和 ^ 展开为区间
+func parseRangeComparator(op, operand string) ([]versionComparator, error) {
+	lower, parts, err := parsePartialSemVer(operand)
+	if err != nil {
+		return nil, err
+	}
+
+	var upper SemVer
+	switch {
+	case op == "~" && parts == 1:
+		upper = SemVer{Major: lower.Major + 1}
+	case op == "~":
+		upper = SemVer{Major: lower.Major, Minor: lower.Minor + 1}
+	case lower.Major > 0 || parts == 1:
+		upper = SemVer{Major: lower.Major + 1}
+	case lower.Minor > 0 || parts == 2:
+		upper = SemVer{Minor: lower.Minor + 1}
+	default:
+		upper = SemVer{Patch: lower.Patch + 1}
+	}
+	return []versionComparator{{op: ">=", version: lower}, {op: "<", version: upper}}, nil
+}
+
+// 约束中的版本可以省略次版本号和修订号，例如 1 或 1.2，省略部分按 0 处理；返回给出的数字部分个数
+func parsePartialSemVer(s string) (SemVer, int, error) {
+	trimmed := strings.TrimPrefix(strings.TrimPrefix(s, "v"), "V")
+	core := trimmed
+	if i := strings.IndexAny(core, "-+"); i >= 0 {
+		core = core[:i]
+	}
+	parts := strings.Count(core, ".") + 1
+	if parts < 3 && core == trimmed {
+		trimmed += strings.Repeat(".0", 3-parts)
+	}
+	v, err := parseSemVer(trimmed)
+	if err != nil {
+		return SemVer{}, 0, err
+	}
+	return v, parts, nil
+}
+
+// Match 判断版本是否满足约束
+func (c versionConstraint) Match(v SemVer) bool {
+	if len(c.groups) == 0 {
+		return true
+	}
+	for _, group := range c.groups {
+		matched := true
+		for _, comparator := range group {
+			if !comparator.match(v) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (c versionConstraint) String() string {
+	return c.expr
+}
@@ -0,0 +1,214 @@
+package main
+
+import "testing"
+
+func mustParseSemVer(t *testing.T, version string) SemVer {
+	t.Helper()
+	v, err := parseSemVer(version)
+	if err != nil {
+		t.Fatalf("parseSemVer(%q): %v", version, err)
+	}
+	return v
+}
+
+func TestParseSemVer(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string // 规范形式，为空表示应解析失败
+	}{
+		{"1.2.3", "1.2.3"},
+		{"v1.2.3", "1.2.3"},
+		{"V1.2.3", "1.2.3"},
+		{" 1.2.3 ", "1.2.3"},
+		{"0.0.0", "0.0.0"},
+		{"10.20.30", "10.20.30"},
+		{"1.0.0-alpha", "1.0.0-alpha"},
+		{"1.0.0-alpha.1", "1.0.0-alpha.1"},
+		{"1.0.0-0.3.7", "1.0.0-0.3.7"},
+		{"1.0.0-x.7.z.92", "1.0.0-x.7.z.92"},
+		{"1.0.0-x-y-z.--", "1.0.0-x-y-z.--"},
+		{"1.0.0-alpha+001", "1.0.0-alpha+001"},
+		{"1.0.0+20130313144700", "1.0.0+20130313144700"},
+		{"1.0.0-beta+exp.sha.5114f85", "1.0.0-beta+exp.sha.5114f85"},
+		{"1.0.0+21AF26D3----117B344092BD", "1.0.0+21AF26D3----117B344092BD"},
+
+		// 数字部分和预发布中的纯数字标识不能有前导零，构建元数据可以
+		{"01.2.3", ""},
+		{"1.02.3", ""},
+		{"1.2.03", ""},
+		{"1.0.0-01", ""},
+		{"1.0.0-alpha.01", ""},
+		{"1.0.0-0a", "1.0.0-0a"},
+		{"1.0.0+01", "1.0.0+01"},
+
+		// 部分版本和非法格式
+		{"", ""},
+		{"v", ""},
+		{"1", ""},
+		{"1.2", ""},
+		{"1.2.3.4", ""},
+		{"1.2.x", ""},
+		{"-1.2.3", ""},
+		{"1.2.3-", ""},
+		{"1.2.3+", ""},
+		{"1.2.3-alpha..1", ""},
+		{"1.2.3-alpha_1", ""},
+		{"1.2.3+build+meta", ""},
+		{"99999999999999999999.0.0", ""},
+	}
+	for _, tt := range tests {
+		v, err := parseSemVer(tt.version)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("parseSemVer(%q) = %s, want error", tt.version, v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSemVer(%q): %v", tt.version, err)
+			continue
+		}
+		if got := v.String(); got != tt.want {
+			t.Errorf("parseSemVer(%q) = %s, want %s", tt.version, got, tt.want)
+		}
+	}
+}
+
+// SemVer 2.0 规范第 11 条中的优先级示例，按从低到高排列
+func TestSemVerCompareSpecPrecedence(t *testing.T) {
+	ordered := [][]string{
+		{"1.0.0", "2.0.0", "2.1.0", "2.1.1"},
+		{"1.0.0-alpha", "1.0.0"},
+		{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0"},
+	}
+	for _, versions := range ordered {
+		for i := range versions {
+			for j := range versions {
+				a, b := mustParseSemVer(t, versions[i]), mustParseSemVer(t, versions[j])
+				want := compareUint(uint64(i), uint64(j))
+				if got := a.Compare(b); got != want {
+					t.Errorf("%s.Compare(%s) = %d, want %d", versions[i], versions[j], got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestSemVerCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.3", "v1.2.3", 0},
+		{"1.10.0", "1.9.0", 1},
+		{"1.0.10", "1.0.9", 1},
+		{"10.0.0", "9.99.99", 1},
+		// 构建元数据不参与比较
+		{"1.0.0+build.1", "1.0.0+build.2", 0},
+		{"1.0.0-rc.1+a", "1.0.0-rc.1", 0},
+		// 纯数字标识按数值比较且低于非数字标识
+		{"1.0.0-2", "1.0.0-10", -1},
+		{"1.0.0-10", "1.0.0-a", -1},
+		{"1.0.0-rc.9", "1.0.0-rc.10", -1},
+		// 非数字标识按 ASCII 比较
+		{"1.0.0-RC", "1.0.0-rc", -1},
+		{"1.0.0-alpha-1", "1.0.0-alpha", 1},
+		// 前面的标识相同时，标识多的优先级高
+		{"1.0.0-alpha.1.1", "1.0.0-alpha.1", 1},
+	}
+	for _, tt := range tests {
+		a, b := mustParseSemVer(t, tt.a), mustParseSemVer(t, tt.b)
+		if got := a.Compare(b); got != tt.want {
+			t.Errorf("%s.Compare(%s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := b.Compare(a); got != -tt.want {
+			t.Errorf("%s.Compare(%s) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestParseVersionConstraint(t *testing.T) {
+	tests := []struct {
+		expr     string
+		match    []string
+		notMatch []string
+	}{
+		// 空表达式和 * 匹配所有版本
+		{"", []string{"0.0.0", "1.0.0-rc.1", "99.0.0"}, nil},
+		{"*", []string{"0.0.0", "1.0.0-rc.1", "99.0.0"}, nil},
+
+		// 比较运算符
+		{"1.2.3", []string{"1.2.3", "1.2.3+build"}, []string{"1.2.4", "1.2.3-rc.1"}},
+		{"=1.2.3", []string{"1.2.3"}, []string{"1.2.2"}},
+		{"==1.2.3", []string{"1.2.3"}, []string{"1.2.2"}},
+		{"!=1.2.3", []string{"1.2.2", "1.2.4"}, []string{"1.2.3"}},
+		{">1.2.3", []string{"1.2.4", "2.0.0"}, []string{"1.2.3", "1.2.3-rc.1"}},
+		{">=1.2.3", []string{"1.2.3", "1.3.0"}, []string{"1.2.2", "1.2.3-rc.1"}},
+		{"<1.2.3", []string{"1.2.2", "1.2.3-rc.1"}, []string{"1.2.3"}},
+		{"<=1.2.3", []string{"1.2.3", "0.1.0"}, []string{"1.2.4"}},
+		{">=v1.2.3", []string{"1.2.3"}, []string{"1.2.2"}},
+		{">=1.0.0-rc.1", []string{"1.0.0-rc.1", "1.0.0-rc.2", "1.0.0"}, []string{"1.0.0-beta"}},
+
+		// 部分版本，省略的部分按 0 处理
+		{"1", []string{"1.0.0"}, []string{"1.0.1"}},
+		{">=1.2", []string{"1.2.0", "1.2.5"}, []string{"1.1.9"}},
+		{"<2", []string{"1.99.99"}, []string{"2.0.0"}},
+
+		// ~ 只允许修订号变化，只给出主版本号时允许次版本号变化
+		{"~1.2.3", []string{"1.2.3", "1.2.99"}, []string{"1.2.2", "1.3.0"}},
+		{"~1.2", []string{"1.2.0", "1.2.99"}, []string{"1.1.9", "1.3.0"}},
+		{"~1", []string{"1.0.0", "1.99.0"}, []string{"0.9.9", "2.0.0"}},
+		{"~0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.3.0"}},
+
+		// ^ 允许最左边非零部分以右的变化
+		{"^1.2.3", []string{"1.2.3", "1.9.0", "1.99.99"}, []string{"1.2.2", "2.0.0"}},
+		{"^1.2", []string{"1.2.0", "1.9.0"}, []string{"1.1.0", "2.0.0"}},
+		{"^1", []string{"1.0.0", "1.9.9"}, []string{"2.0.0"}},
+		{"^0.2.3", []string{"0.2.3", "0.2.99"}, []string{"0.2.2", "0.3.0"}},
+		{"^0.2", []string{"0.2.0", "0.2.99"}, []string{"0.3.0"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.2", "0.0.4"}},
+		{"^0.0", []string{"0.0.0", "0.0.99"}, []string{"0.1.0"}},
+		{"^0", []string{"0.0.0", "0.99.0"}, []string{"1.0.0"}},
+
+		// 组内条件全部满足，|| 分隔的组满足其一
+		{">=1.2.0 <2.0.0", []string{"1.2.0", "1.9.9"}, []string{"1.1.9", "2.0.0"}},
+		{">=1.2.0, <2.0.0", []string{"1.5.0"}, []string{"2.0.0"}},
+		{">=1.2.0 <2.0.0 || ^3.1.0", []string{"1.2.0", "3.1.0", "3.9.0"}, []string{"2.5.0", "3.0.9", "4.0.0"}},
+		{"1.0.0 || 2.0.0 || ~3.1", []string{"1.0.0", "2.0.0", "3.1.7"}, []string{"1.0.1", "3.2.0"}},
+	}
+	for _, tt := range tests {
+		c, err := parseVersionConstraint(tt.expr)
+		if err != nil {
+			t.Errorf("parseVersionConstraint(%q): %v", tt.expr, err)
+			continue
+		}
+		for _, version := range tt.match {
+			if !c.Match(mustParseSemVer(t, version)) {
+				t.Errorf("%q should match %s", tt.expr, version)
+			}
+		}
+		for _, version := range tt.notMatch {
+			if c.Match(mustParseSemVer(t, version)) {
+				t.Errorf("%q should not match %s", tt.expr, version)
+			}
+		}
+	}
+}
+
+func TestParseVersionConstraintInvalid(t *testing.T) {
+	for _, expr := range []string{
+		">=",
+		"^",
+		"~x",
+		">=1.2.3.4",
+		">=01.2.3",
+		"1.2.3 ||",
+		"|| 1.2.3",
+		">=1.0.0 || , ",
+		">1.0.0 <abc",
+	} {
+		if _, err := parseVersionConstraint(expr); err == nil {
+			t.Errorf("parseVersionConstraint(%q) should fail", expr)
+		}
+	}
+}
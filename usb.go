@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
@@ -22,48 +23,50 @@ var (
 	clientsMu sync.Mutex
 )
 
+// 每个 SSE 客户端缓冲的消息数，客户端处理不过来时丢弃新消息
+const sseClientBuffer = 64
+
 func sseHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	clientChan := make(chan string)
-	defer close(clientChan)
+	clientChan := make(chan string, sseClientBuffer)
 
 	clientsMu.Lock()
 	clients[clientChan] = true
 	clientsMu.Unlock()
 
+	// 广播在持有锁时发送，移除后不会再有发送方，不需要关闭通道
 	defer func() {
 		clientsMu.Lock()
 		delete(clients, clientChan)
 		clientsMu.Unlock()
-		close(clientChan)
 	}()
 
-	// 心跳 goroutine
-	go func() {
-		ticker := time.NewTicker(10 * time.Second)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				// 发送心跳
-				select {
-				case clientChan <- "heartbeat\n\n":
-				case <-time.After(time.Second): // 防止阻塞
-				}
-			}
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	// 接收消息并发送到客户端，客户端断开时退出
+	for {
+		var msg string
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			msg = "heartbeat" // 发送心跳
+		case msg = <-clientChan:
 		}
-	}()
 
-	// 接收消息并发送到客户端
-	for msg := range clientChan {
-		_, err := fmt.Fprintf(w, "data: %s\n\n", msg)
-		if err != nil {
-			return // 处理写入错误
+		// 多行消息的每一行都需要 data: 前缀
+		for _, line := range strings.Split(strings.TrimRight(msg, "\n"), "\n") {
+			if _, err := fmt.Fprintf(w, "data: %s\n", line); err != nil {
+				return // 处理写入错误
+			}
+		}
+		if _, err := fmt.Fprint(w, "\n"); err != nil {
+			return
 		}
 		flusher, ok := w.(http.Flusher)
 		if ok {
@@ -174,8 +177,22 @@ func broadcastMessage(msg string) {
 	clientsMu.Lock()
 	defer clientsMu.Unlock()
 	for clientChan := range clients {
-		clientChan <- msg
+		select {
+		case clientChan <- msg:
+		default:
+			// 客户端缓冲已满，丢弃消息，避免阻塞广播方
+		}
+	}
+}
+
+// broadcastEvent 以 JSON 格式广播结构化事件
+func broadcastEvent(event any) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("JSON 编码失败: %v", err)
+		return
 	}
+	broadcastMessage(string(data))
 }
 
 func broadcastMinioSpace() {
@@ -4,42 +4,14 @@ import (
 	"bytes"
 	"compress/flate"
 	"compress/gzip"
-	"fmt"
 	"net/http"
 	"path/filepath"
-	"strconv"
 	"strings"
 )
 
-// 解析版本号
-func parseVersion(version string) ([]int, error) {
-	parts := strings.Split(version, ".")
-	if len(parts) != 3 {
-		return nil, fmt.Errorf("版本格式不正确: %s", version)
-	}
-
-	var nums []int
-	for _, part := range parts {
-		num, err := strconv.Atoi(part)
-		if err != nil {
-			return nil, fmt.Errorf("解析版本号失败: %v", err)
-		}
-		nums = append(nums, num)
-	}
-
-	return nums, nil
-}
-
 // 验证固件文件是否是有效的文件类型
 func isValidFirmwareType(filename string) bool {
-	validExtensions := []string{".img"}
-	ext := strings.ToLower(filepath.Ext(filename))
-	for _, validExt := range validExtensions {
-		if ext == validExt {
-			return true
-		}
-	}
-	return false
+	return firmwareFileTypeOf(filename) != ""
 }
 
 // 验证音频资源文件是否是有效的文件类型
@@ -82,8 +54,8 @@ func shouldInline(contentType string) bool {
 // 定义 Gzip、deflate 响应写入器
 func CompressionMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// 忽略上传、下载、预览文件接口
-		if r.URL.Path == "/upload" || r.URL.Path == "/download" || r.URL.Path == "/previewFile" {
+		// 忽略上传、下载、预览文件、导出固件包、下载固件接口和需要逐条推送的 SSE 接口
+		if r.URL.Path == "/upload" || r.URL.Path == "/download" || r.URL.Path == "/previewFile" || r.URL.Path == "/exportFirmwareBundle" || r.URL.Path == "/usbEvents" || isFirmwareDownloadPath(r.URL.Path) {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -134,7 +106,7 @@ func CompressionMiddleware(next http.Handler) http.Handler {
 func handlePreflight(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Firmware-Repository")
 	w.Header().Set("Access-Control-Max-Age", "86400") // 缓存 1 天
 	w.WriteHeader(http.StatusNoContent)
 }
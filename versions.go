@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// 上传人信息在对象元数据中的键
+const uploaderMetadataKey = "Uploader"
+
+// 租户桶是否开启了版本控制
+var bucketVersioning bool
+
+// 历史版本保留天数，超过后由网桥永久删除，0 表示永久保留
+var noncurrentVersionDays int64
+
+// 开启租户桶的版本控制，可通过 MINIO_BUCKET_VERSIONING=false 关闭
+func initVersioning() {
+	if strings.EqualFold(os.Getenv("MINIO_BUCKET_VERSIONING"), "false") {
+		log.Printf("存储桶 %s 未开启版本控制", bucketName)
+		return
+	}
+
+	err := minioClient.EnableVersioning(context.Background(), bucketName)
+	if err != nil {
+		log.Printf("开启存储桶 %s 版本控制失败: %v", bucketName, err)
+		return
+	}
+	bucketVersioning = true
+
+	initNoncurrentVersionExpiry()
+}
+
+// 历史版本默认永久保留，以便随时恢复和撤销删除；配置 MINIO_NONCURRENT_VERSION_DAYS 后
+// 定期删除超过保留天数的历史版本。不使用 MinIO 生命周期规则，
+// 因为生命周期删除版本时网桥无法同步扣减用量和释放 blob 引用
+func initNoncurrentVersionExpiry() {
+	noncurrentVersionDays = envInt64("MINIO_NONCURRENT_VERSION_DAYS", 0)
+	if noncurrentVersionDays <= 0 {
+		log.Printf("存储桶 %s 的历史版本永久保留", bucketName)
+		return
+	}
+	log.Printf("存储桶 %s 的历史版本保留 %d 天，过期后无法恢复", bucketName, noncurrentVersionDays)
+
+	interval, err := time.ParseDuration(os.Getenv("MINIO_NONCURRENT_VERSION_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = 24 * time.Hour
+	}
+	go func() {
+		for {
+			cutoff := time.Now().Add(-time.Duration(noncurrentVersionDays) * 24 * time.Hour)
+			expired, err := expireNoncurrentVersions(context.Background(), cutoff)
+			if err != nil {
+				log.Printf("清理历史版本失败: %v", err)
+			} else if expired > 0 {
+				log.Printf("已删除 %d 个过期的历史版本", expired)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// expireNoncurrentVersions 永久删除在 cutoff 之前就成为历史版本的对象版本（含删除标记）。
+// 版本成为历史版本的时间是其后一个版本的创建时间，与 S3 NoncurrentVersionExpiration 一致
+func expireNoncurrentVersions(ctx context.Context, cutoff time.Time) (int, error) {
+	opts := minio.ListObjectsOptions{
+		Recursive:    true,
+		WithVersions: true,
+		WithMetadata: true,
+	}
+
+	expired := 0
+	var lastKey string
+	var successorTime time.Time
+	for object := range minioClient.ListObjects(ctx, bucketName, opts) {
+		if object.Err != nil {
+			return expired, object.Err
+		}
+		// 同一 key 的版本按时间从新到旧排列
+		noncurrentSince := successorTime
+		if object.Key != lastKey {
+			lastKey = object.Key
+			noncurrentSince = time.Time{}
+		}
+		successorTime = object.LastModified
+		if object.IsLatest || noncurrentSince.IsZero() || !noncurrentSince.Before(cutoff) {
+			continue
+		}
+
+		if err := removeObjectVersionWithQuota(ctx, object); err != nil {
+			log.Printf("删除历史版本 %s (%s) 失败: %v", object.Key, object.VersionID, err)
+			continue
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// 列出对象的全部版本（含删除标记），按时间从新到旧排列
+func listObjectVersions(ctx context.Context, key string) ([]minio.ObjectInfo, error) {
+	opts := minio.ListObjectsOptions{
+		Prefix:       key,
+		Recursive:    true,
+		WithVersions: true,
+		WithMetadata: true,
+	}
+
+	var versions []minio.ObjectInfo
+	for object := range minioClient.ListObjects(ctx, bucketName, opts) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		if object.Key != key {
+			continue
+		}
+		versions = append(versions, object)
+	}
+	return versions, nil
+}
+
+// 转换为接口返回的版本信息
+func toObjectVersion(object minio.ObjectInfo) ObjectVersion {
+	sizeMB := float64(logicalSize(object)) / (1024 * 1024) // 转换为MB
+	return ObjectVersion{
+		Key:            object.Key,
+		VersionID:      object.VersionID,
+		Size:           math.Round(sizeMB*100) / 100, // 保留两位小数
+		LastModified:   object.LastModified.In(shanghaiLocation).Format("2006-01-02 15:04:05"),
+		Uploader:       userMetadataFromList(object.UserMetadata)[uploaderMetadataKey],
+		IsLatest:       object.IsLatest,
+		IsDeleteMarker: object.IsDeleteMarker,
+	}
+}
+
+// 根据版本列表计算当前可见的对象：最新版本为删除标记或不存在时返回 nil
+func currentVersion(versions []minio.ObjectInfo) *minio.ObjectInfo {
+	for i := range versions {
+		if versions[i].IsLatest {
+			if versions[i].IsDeleteMarker {
+				return nil
+			}
+			return &versions[i]
+		}
+	}
+	return nil
+}
+
+// 列出目录下当前版本为删除标记的文件，供资源列表展示和撤销删除
+func listDeletedObjects(ctx context.Context, prefix string) []ObjectInfo {
+	opts := minio.ListObjectsOptions{
+		Prefix:       prefix,
+		Recursive:    false,
+		WithVersions: true,
+	}
+
+	var deleted []ObjectInfo
+	for object := range minioClient.ListObjects(ctx, bucketName, opts) {
+		if object.Err != nil {
+			log.Println(object.Err)
+			continue
+		}
+		if !object.IsLatest || !object.IsDeleteMarker {
+			continue
+		}
+		name := strings.TrimPrefix(object.Key, prefix)
+		if name == "" || strings.HasSuffix(name, "/") {
+			continue
+		}
+		deleted = append(deleted, ObjectInfo{
+			FileName:     name,
+			Key:          object.Key,
+			LastModified: object.LastModified.In(shanghaiLocation).Format("2006-01-02 15:04:05"),
+			Deleted:      true,
+			VersionID:    object.VersionID,
+		})
+	}
+	return deleted
+}
+
+// 将指定历史版本恢复为当前版本
+func restoreObjectVersion(ctx context.Context, key, versionID string) error {
+	versions, err := listObjectVersions(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	var target *minio.ObjectInfo
+	for i := range versions {
+		if versions[i].VersionID == versionID {
+			target = &versions[i]
+			break
+		}
+	}
+	if target == nil {
+		return fmt.Errorf("version %s of %s not found", versionID, key)
+	}
+	if target.IsDeleteMarker {
+		return fmt.Errorf("version %s of %s is a delete marker", versionID, key)
+	}
+	if target.IsLatest {
+		return nil
+	}
+
+	// 复制出的新版本占用新的空间，原版本仍作为历史版本保留
+	return replaceObjectWithQuota(ctx, key, currentVersion(versions), target, logicalSize(*target), func() error {
+		return copyObjectSSE(ctx, key, versionID, key, nil)
+	})
+}
+
+// 撤销删除：移除删除标记，versionID 为空时移除最新的删除标记
+func undeleteObject(ctx context.Context, key, versionID string) error {
+	versions, err := listObjectVersions(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	index := -1
+	for i := range versions {
+		if versionID == "" && versions[i].IsLatest || versionID != "" && versions[i].VersionID == versionID {
+			index = i
+			break
+		}
+	}
+	if index < 0 || !versions[index].IsDeleteMarker {
+		return fmt.Errorf("no delete marker found for %s", key)
+	}
+
+	// 移除的是最新的删除标记时，下一个版本成为当前版本
+	prev := currentVersion(versions)
+	next := prev
+	if versions[index].IsLatest {
+		next = nil
+		if index+1 < len(versions) && !versions[index+1].IsDeleteMarker {
+			next = &versions[index+1]
+		}
+	}
+
+	// 只移除删除标记，不占用新的空间
+	return replaceObjectWithQuota(ctx, key, prev, next, 0, func() error {
+		return minioClient.RemoveObject(ctx, bucketName, key, minio.RemoveObjectOptions{VersionID: versions[index].VersionID})
+	})
+}
+
+// 版本接口共用的请求体
+type objectVersionRequest struct {
+	Key       string `json:"key"`
+	VersionID string `json:"versionId"`
+}
+
+// 解析版本接口请求，并处理预检和 CORS
+func decodeObjectVersionRequest(w http.ResponseWriter, r *http.Request) (*objectVersionRequest, bool) {
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return nil, false
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许所有来源，或者指定具体的来源
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+
+	if !bucketVersioning {
+		http.Error(w, "Bucket versioning is disabled", http.StatusConflict)
+		return nil, false
+	}
+
+	var request objectVersionRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || request.Key == "" || strings.HasSuffix(request.Key, "/") {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+	return &request, true
+}
+
+// 返回对象的版本历史
+func writeObjectVersions(w http.ResponseWriter, ctx context.Context, key string) {
+	versions, err := listObjectVersions(ctx, key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	versionList := []ObjectVersion{}
+	for _, version := range versions {
+		versionList = append(versionList, toObjectVersion(version))
+	}
+
+	jsonVersionList, err := json.MarshalIndent(versionList, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonVersionList)
+}
+
+// 获取对象版本历史
+func getVersionListHandler(w http.ResponseWriter, r *http.Request) {
+	request, ok := decodeObjectVersionRequest(w, r)
+	if !ok {
+		return
+	}
+	writeObjectVersions(w, r.Context(), request.Key)
+}
+
+// 将历史版本恢复为当前版本
+func restoreVersionHandler(w http.ResponseWriter, r *http.Request) {
+	request, ok := decodeObjectVersionRequest(w, r)
+	if !ok {
+		return
+	}
+	if request.VersionID == "" {
+		http.Error(w, "versionId is required", http.StatusBadRequest)
+		return
+	}
+
+	err := restoreObjectVersion(r.Context(), request.Key, request.VersionID)
+	if err != nil {
+		http.Error(w, err.Error(), quotaErrorStatus(err))
+		return
+	}
+	writeObjectVersions(w, r.Context(), request.Key)
+}
+
+// 撤销删除
+func undeleteObjectHandler(w http.ResponseWriter, r *http.Request) {
+	request, ok := decodeObjectVersionRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := undeleteObject(r.Context(), request.Key, request.VersionID)
+	if err != nil {
+		http.Error(w, err.Error(), quotaErrorStatus(err))
+		return
+	}
+	writeObjectVersions(w, r.Context(), request.Key)
+}